@@ -677,15 +677,15 @@ func MakeDataDir(ctx *cli.Context) string {
 			path = filepath.Join(path, "local")
 		}
 		// Set specific directory for node location within the hierarchy
-		switch common.NodeLocation.Context() {
+		switch common.GetNodeLocation().Context() {
 		case common.PRIME_CTX:
 			path = filepath.Join(path, "prime")
 		case common.REGION_CTX:
-			regionNum := strconv.Itoa(common.NodeLocation.Region())
+			regionNum := strconv.Itoa(common.GetNodeLocation().Region())
 			path = filepath.Join(path, "region-"+regionNum)
 		case common.ZONE_CTX:
-			regionNum := strconv.Itoa(common.NodeLocation.Region())
-			zoneNum := strconv.Itoa(common.NodeLocation.Zone())
+			regionNum := strconv.Itoa(common.GetNodeLocation().Region())
+			zoneNum := strconv.Itoa(common.GetNodeLocation().Zone())
 			path = filepath.Join(path, "zone-"+regionNum+"-"+zoneNum)
 		}
 		return path
@@ -1095,15 +1095,15 @@ func setDataDir(ctx *cli.Context, cfg *node.Config) {
 		cfg.DataDir = filepath.Join(node.DefaultDataDir(), "local")
 	}
 	// Set specific directory for node location within the hierarchy
-	switch common.NodeLocation.Context() {
+	switch common.GetNodeLocation().Context() {
 	case common.PRIME_CTX:
 		cfg.DataDir = filepath.Join(cfg.DataDir, "prime")
 	case common.REGION_CTX:
-		regionNum := strconv.Itoa(common.NodeLocation.Region())
+		regionNum := strconv.Itoa(common.GetNodeLocation().Region())
 		cfg.DataDir = filepath.Join(cfg.DataDir, "region-"+regionNum)
 	case common.ZONE_CTX:
-		regionNum := strconv.Itoa(common.NodeLocation.Region())
-		zoneNum := strconv.Itoa(common.NodeLocation.Zone())
+		regionNum := strconv.Itoa(common.GetNodeLocation().Region())
+		zoneNum := strconv.Itoa(common.GetNodeLocation().Zone())
 		cfg.DataDir = filepath.Join(cfg.DataDir, "zone-"+regionNum+"-"+zoneNum)
 	}
 }
@@ -1280,18 +1280,20 @@ func CheckExclusive(ctx *cli.Context, args ...interface{}) {
 }
 
 func SetGlobalVars(ctx *cli.Context) {
-	// Configure global NodeLocation
+	// Configure global node location
 	if !ctx.GlobalIsSet(RegionFlag.Name) && ctx.GlobalIsSet(ZoneFlag.Name) {
 		log.Crit("zone idx given, but missing region idx!")
 	}
+	location := common.GetNodeLocation()
 	if ctx.GlobalIsSet(RegionFlag.Name) {
 		region := ctx.GlobalInt(RegionFlag.Name)
-		common.NodeLocation = append(common.NodeLocation, byte(region))
+		location = append(location, byte(region))
 	}
 	if ctx.GlobalIsSet(ZoneFlag.Name) {
 		zone := ctx.GlobalInt(ZoneFlag.Name)
-		common.NodeLocation = append(common.NodeLocation, byte(zone))
+		location = append(location, byte(zone))
 	}
+	common.SetNodeLocation(location)
 }
 
 // SetEthConfig applies eth-related command line flags to the config.
@@ -0,0 +1,185 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// bech32Charset is the BIP-173 alphabet, mapping 5 bit groups to characters.
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32MaxLength is the longest bech32 string BIP-173 allows.
+const bech32MaxLength = 90
+
+var (
+	// ErrInvalidBech32 is returned for strings which are not bech32 encoded.
+	ErrInvalidBech32 = errors.New("invalid bech32 string")
+
+	// ErrBech32Checksum is returned for bech32 strings failing their checksum.
+	ErrBech32Checksum = errors.New("invalid bech32 checksum")
+
+	// ErrBech32Length is returned for bech32 strings whose payload is not an
+	// address.
+	ErrBech32Length = errors.New("invalid bech32 address length")
+)
+
+// bech32Generator holds the generator coefficients of the BIP-173 checksum.
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// bech32Polymod computes the BIP-173 checksum polynomial over values.
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// bech32HrpExpand expands the human-readable part for the checksum.
+func bech32HrpExpand(hrp string) []byte {
+	values := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&31)
+	}
+	return values
+}
+
+// bech32Encode encodes the 5 bit groups of data with the given human-readable
+// part, which must be lowercase.
+func bech32Encode(hrp string, data []byte) string {
+	values := append(bech32HrpExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ 1
+
+	var b strings.Builder
+	b.Grow(len(hrp) + 1 + len(data) + 6)
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	for _, v := range data {
+		b.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		b.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return b.String()
+}
+
+// bech32Decode splits a bech32 string into its human-readable part and the 5
+// bit groups of its data, without the checksum.
+func bech32Decode(s string) (string, []byte, error) {
+	if len(s) > bech32MaxLength {
+		return "", nil, fmt.Errorf("%w: length %d exceeds %d", ErrInvalidBech32, len(s), bech32MaxLength)
+	}
+	lower, upper := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 33 || c > 126 {
+			return "", nil, fmt.Errorf("%w: invalid character %q", ErrInvalidBech32, c)
+		}
+		lower = lower || (c >= 'a' && c <= 'z')
+		upper = upper || (c >= 'A' && c <= 'Z')
+	}
+	if lower && upper {
+		return "", nil, fmt.Errorf("%w: mixed case", ErrInvalidBech32)
+	}
+	s = strings.ToLower(s)
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, fmt.Errorf("%w: missing separator or checksum", ErrInvalidBech32)
+	}
+	hrp := s[:sep]
+	data := make([]byte, len(s)-sep-1)
+	for i := range data {
+		v := strings.IndexByte(bech32Charset, s[sep+1+i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("%w: invalid data character %q", ErrInvalidBech32, s[sep+1+i])
+		}
+		data[i] = byte(v)
+	}
+	if bech32Polymod(append(bech32HrpExpand(hrp), data...)) != 1 {
+		return "", nil, ErrBech32Checksum
+	}
+	return hrp, data[:len(data)-6], nil
+}
+
+// convertBits regroups data from groups of from bits into groups of to bits.
+// When pad is unset, leftover bits must be zero padding of less than from bits.
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, bool) {
+	var (
+		acc   uint32
+		bits  uint
+		out   []byte
+		maxv  = uint32(1)<<to - 1
+		limit = uint32(1)<<from - 1
+	)
+	for _, v := range data {
+		if uint32(v) > limit {
+			return nil, false
+		}
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+// Bech32 returns the BIP-173 bech32 encoding of the address under the given
+// human-readable part, for display purposes. The hrp must be non-empty and
+// consist of printable ASCII characters; it is encoded in lowercase.
+func (a Address) Bech32(hrp string) (string, error) {
+	if len(hrp) == 0 {
+		return "", fmt.Errorf("%w: empty human-readable part", ErrInvalidBech32)
+	}
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", fmt.Errorf("%w: invalid human-readable part character %q", ErrInvalidBech32, hrp[i])
+		}
+	}
+	data, _ := convertBits(a[:], 8, 5, true)
+	s := bech32Encode(strings.ToLower(hrp), data)
+	if len(s) > bech32MaxLength {
+		return "", fmt.Errorf("%w: length %d exceeds %d", ErrInvalidBech32, len(s), bech32MaxLength)
+	}
+	return s, nil
+}
+
+// Bech32ToAddress decodes a bech32 encoded address with any human-readable
+// part. Unlike SetBytes, payloads which are not exactly AddressLength bytes
+// are rejected rather than truncated or padded.
+func Bech32ToAddress(s string) (Address, error) {
+	_, data, err := bech32Decode(s)
+	if err != nil {
+		return Address{}, err
+	}
+	payload, ok := convertBits(data, 5, 8, false)
+	if !ok {
+		return Address{}, fmt.Errorf("%w: invalid padding", ErrInvalidBech32)
+	}
+	if len(payload) != AddressLength {
+		return Address{}, fmt.Errorf("%w: have %d bytes, want %d", ErrBech32Length, len(payload), AddressLength)
+	}
+	var a Address
+	copy(a[:], payload)
+	return a, nil
+}
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestBech32Checksum(t *testing.T) {
+	// Test vectors from BIP-173
+	valid := []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+		"?1ezyfcl",
+	}
+	for _, s := range valid {
+		hrp, data, err := bech32Decode(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if enc := bech32Encode(hrp, data); enc != strings.ToLower(s) {
+			t.Errorf("%s: re-encoded as %s", s, enc)
+		}
+	}
+	invalid := []string{
+		"\x201nwldj5", // hrp character out of range
+		"\x7f1axkwrx", // hrp character out of range
+		"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx", // overall max length exceeded
+		"pzry9x0s0muk",  // no separator
+		"1pzry9x0s0muk", // empty hrp
+		"x1b4n0q5v",     // invalid data character
+		"li1dgmt3",      // too short checksum
+		"de1lg7wt\xff",  // invalid character in checksum
+		"A1G7SGD8",      // checksum calculated with uppercase hrp
+		"10a06t8",       // empty hrp
+		"1qzzfhee",      // empty hrp
+		"a12UEL5L",      // mixed case
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxx", // altered checksum
+	}
+	for _, s := range invalid {
+		if _, _, err := bech32Decode(s); err == nil {
+			t.Errorf("%q: invalid string accepted", s)
+		}
+	}
+}
+
+func TestAddressBech32(t *testing.T) {
+	tests := []struct {
+		addr Address
+		hrp  string
+		want string
+	}{
+		{HexToAddress("0x1400000000000000000000000000000000000c0b"), "quai", "quai1zsqqqqqqqqqqqqqqqqqqqqqqqqqqqrqtnd8z5v"},
+		{HexToAddress("0x751e76e8199196d454941c45d1b3a323f1433bd6"), "QUAI", "quai1w508d6qejxtdg4y5r3zarvary0c5xw7kyxtatg"},
+	}
+	for _, tt := range tests {
+		have, err := tt.addr.Bech32(tt.hrp)
+		if err != nil {
+			t.Fatalf("%x: %v", tt.addr, err)
+		}
+		if have != tt.want {
+			t.Errorf("%x: encoding mismatch: have %s, want %s", tt.addr, have, tt.want)
+		}
+		for _, s := range []string{tt.want, strings.ToUpper(tt.want)} {
+			addr, err := Bech32ToAddress(s)
+			if err != nil {
+				t.Errorf("%s: %v", s, err)
+			} else if addr != tt.addr {
+				t.Errorf("%s: decoding mismatch: have %x, want %x", s, addr, tt.addr)
+			}
+		}
+	}
+	for i := 0; i < 100; i++ {
+		var addr Address
+		rand.Read(addr[:])
+		s, err := addr.Bech32("quai")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, err := Bech32ToAddress(s); err != nil || have != addr {
+			t.Errorf("%x: round trip mismatch: have %x (%v)", addr, have, err)
+		}
+	}
+	if _, err := (Address{}).Bech32(""); !errors.Is(err, ErrInvalidBech32) {
+		t.Errorf("empty hrp: have %v, want %v", err, ErrInvalidBech32)
+	}
+	if _, err := (Address{}).Bech32(strings.Repeat("q", 60)); !errors.Is(err, ErrInvalidBech32) {
+		t.Errorf("overlong hrp: have %v, want %v", err, ErrInvalidBech32)
+	}
+}
+
+func TestBech32ToAddressErrors(t *testing.T) {
+	short, _ := convertBits(make([]byte, AddressLength-1), 8, 5, true)
+	long, _ := convertBits(make([]byte, AddressLength+1), 8, 5, true)
+	tests := []struct {
+		s    string
+		want error
+	}{
+		{"quai1zsqqqqqqqqqqqqqqqqqqqqqqqqqqqrqtnd8z5w", ErrBech32Checksum},
+		{"quai1zsqqqqqqqqqqqqqqqqqqqqqqqqqqqrqtnd8z5V", ErrInvalidBech32},
+		{bech32Encode("quai", short), ErrBech32Length},
+		{bech32Encode("quai", long), ErrBech32Length},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", ErrInvalidBech32}, // witness version makes the padding invalid
+		{"0x1400000000000000000000000000000000000c0b", ErrInvalidBech32},
+	}
+	for _, tt := range tests {
+		if _, err := Bech32ToAddress(tt.s); !errors.Is(err, tt.want) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.s, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNoHandler is returned when a message is dispatched to a location without
+// a handler for it or any of its dominant chains.
+var ErrNoHandler = errors.New("no handler for location")
+
+// Handler processes a message addressed to the given location.
+type Handler func(loc Location, msg interface{}) error
+
+// LocationRouter routes messages to handlers registered per chain. A message
+// goes to the handler of its exact location if there is one, and otherwise to
+// the handler of its closest dominant chain, so e.g. a region handler receives
+// the messages of all zones in the region without handlers of their own.
+//
+// LocationRouter is safe for concurrent use. The zero value is ready to use.
+type LocationRouter struct {
+	lock     sync.RWMutex
+	handlers map[string]Handler
+}
+
+// RegisterHandler sets the handler of loc, replacing any previous one. A nil
+// handler removes it.
+func (r *LocationRouter) RegisterHandler(loc Location, h Handler) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if h == nil {
+		delete(r.handlers, string(loc))
+		return
+	}
+	if r.handlers == nil {
+		r.handlers = make(map[string]Handler)
+	}
+	r.handlers[string(loc)] = h
+}
+
+// Handler returns the handler messages to loc are dispatched to, and the
+// location it is registered for.
+func (r *LocationRouter) Handler(loc Location) (Handler, Location) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var (
+		handler Handler
+		match   Location
+	)
+	loc.WalkUp(func(l Location) bool {
+		handler, match = r.handlers[string(l)], l
+		return handler == nil
+	})
+	if handler == nil {
+		return nil, nil
+	}
+	return handler, match
+}
+
+// Dispatch routes msg to the handler of loc, falling back to the handlers of
+// its dominant chains, and returns the handler's result.
+func (r *LocationRouter) Dispatch(loc Location, msg interface{}) error {
+	handler, _ := r.Handler(loc)
+	if handler == nil {
+		return fmt.Errorf("%w: %v", ErrNoHandler, loc)
+	}
+	return handler(loc, msg)
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLocationWalkUp(t *testing.T) {
+	var visited []Location
+	Location{1, 2}.WalkUp(func(l Location) bool {
+		visited = append(visited, l)
+		return true
+	})
+	want := []Location{{1, 2}, {1}, {}}
+	if len(visited) != len(want) {
+		t.Fatalf("visited %v, want %v", visited, want)
+	}
+	for i := range want {
+		if !visited[i].Equal(want[i]) {
+			t.Errorf("step %d: have %v, want %v", i, visited[i], want[i])
+		}
+	}
+	// Walking stops as soon as the callback asks to
+	visited = visited[:0]
+	Location{1, 2}.WalkUp(func(l Location) bool {
+		visited = append(visited, l)
+		return false
+	})
+	if len(visited) != 1 {
+		t.Errorf("walk did not stop: visited %v", visited)
+	}
+}
+
+func TestLocationRouter(t *testing.T) {
+	var (
+		router   LocationRouter
+		received = make(map[string][]string)
+	)
+	handler := func(name string) Handler {
+		return func(loc Location, msg interface{}) error {
+			received[name] = append(received[name], loc.Name()+":"+msg.(string))
+			return nil
+		}
+	}
+	if err := router.Dispatch(Location{0, 0}, "early"); !errors.Is(err, ErrNoHandler) {
+		t.Fatalf("dispatch without handlers: have %v, want %v", err, ErrNoHandler)
+	}
+	router.RegisterHandler(Location{0, 1}, handler("cyprus2"))
+	router.RegisterHandler(Location{0}, handler("cyprus"))
+	router.RegisterHandler(Location{}, handler("prime"))
+
+	router.Dispatch(Location{0, 1}, "exact")     // exact zone match
+	router.Dispatch(Location{0, 2}, "zone")      // falls back to the region
+	router.Dispatch(Location{0}, "region")       // exact region match
+	router.Dispatch(Location{2, 0}, "elsewhere") // falls back to prime
+
+	want := map[string][]string{
+		"cyprus2": {"cyprus2:exact"},
+		"cyprus":  {"cyprus3:zone", "cyprus:region"},
+		"prime":   {"hydra1:elsewhere"},
+	}
+	for name, msgs := range want {
+		if len(received[name]) != len(msgs) {
+			t.Errorf("%s: received %v, want %v", name, received[name], msgs)
+			continue
+		}
+		for i := range msgs {
+			if received[name][i] != msgs[i] {
+				t.Errorf("%s: message %d: have %s, want %s", name, i, received[name][i], msgs[i])
+			}
+		}
+	}
+	// Removing a handler makes its chain fall back again
+	router.RegisterHandler(Location{0}, nil)
+	if _, match := router.Handler(Location{0, 2}); !match.Equal(Location{}) {
+		t.Errorf("fallback after removal: have %v, want prime", match)
+	}
+	// Handler errors are passed on
+	failure := errors.New("failure")
+	router.RegisterHandler(Location{1}, func(Location, interface{}) error { return failure })
+	if err := router.Dispatch(Location{1, 1}, "msg"); err != failure {
+		t.Errorf("handler error mismatch: have %v, want %v", err, failure)
+	}
+}
@@ -0,0 +1,221 @@
+package common
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// AddressSet is a set of addresses, e.g. for permissioned-chain allowlists.
+// Sets must be created with NewAddressSet before adding to them.
+type AddressSet map[Address]struct{}
+
+// NewAddressSet creates a set containing the given addresses.
+func NewAddressSet(addrs ...Address) AddressSet {
+	s := make(AddressSet, len(addrs))
+	for _, addr := range addrs {
+		s.Add(addr)
+	}
+	return s
+}
+
+// Add inserts addr into the set.
+func (s AddressSet) Add(addr Address) {
+	s[addr] = struct{}{}
+}
+
+// Remove deletes addr from the set, if present.
+func (s AddressSet) Remove(addr Address) {
+	delete(s, addr)
+}
+
+// Contains reports whether addr is in the set.
+func (s AddressSet) Contains(addr Address) bool {
+	_, ok := s[addr]
+	return ok
+}
+
+// Len returns the number of addresses in the set.
+func (s AddressSet) Len() int {
+	return len(s)
+}
+
+// Union returns a new set holding the addresses in either s or other.
+func (s AddressSet) Union(other AddressSet) AddressSet {
+	union := make(AddressSet, len(s)+len(other))
+	for addr := range s {
+		union.Add(addr)
+	}
+	for addr := range other {
+		union.Add(addr)
+	}
+	return union
+}
+
+// Intersect returns a new set holding the addresses in both s and other.
+func (s AddressSet) Intersect(other AddressSet) AddressSet {
+	small, large := s, other
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	intersection := make(AddressSet, len(small))
+	for addr := range small {
+		if large.Contains(addr) {
+			intersection.Add(addr)
+		}
+	}
+	return intersection
+}
+
+// Difference returns a new set holding the addresses in s but not in other.
+func (s AddressSet) Difference(other AddressSet) AddressSet {
+	diff := make(AddressSet, len(s))
+	for addr := range s {
+		if !other.Contains(addr) {
+			diff.Add(addr)
+		}
+	}
+	return diff
+}
+
+// List returns the addresses of the set in ascending byte order.
+func (s AddressSet) List() []Address {
+	list := make(Addresses, 0, len(s))
+	for addr := range s {
+		list = append(list, addr)
+	}
+	sort.Sort(list)
+	return list
+}
+
+// MarshalJSON encodes the set as an array of checksummed addresses in
+// ascending byte order, so that equal sets always produce identical output.
+func (s AddressSet) MarshalJSON() ([]byte, error) {
+	list := s.List()
+	hexes := make([]string, len(list))
+	for i, addr := range list {
+		hexes[i] = addr.Hex()
+	}
+	return json.Marshal(hexes)
+}
+
+// UnmarshalJSON decodes an array of addresses into the set.
+func (s *AddressSet) UnmarshalJSON(input []byte) error {
+	var addrs []Address
+	if err := json.Unmarshal(input, &addrs); err != nil {
+		return err
+	}
+	*s = NewAddressSet(addrs...)
+	return nil
+}
+
+// HashSet is a set of hashes, e.g. of known blocks or transactions. Sets must
+// be created with NewHashSet before adding to them.
+type HashSet map[Hash]struct{}
+
+// NewHashSet creates a set containing the given hashes.
+func NewHashSet(hashes ...Hash) HashSet {
+	s := make(HashSet, len(hashes))
+	for _, hash := range hashes {
+		s.Add(hash)
+	}
+	return s
+}
+
+// Add inserts hash into the set.
+func (s HashSet) Add(hash Hash) {
+	s[hash] = struct{}{}
+}
+
+// Remove deletes hash from the set, if present.
+func (s HashSet) Remove(hash Hash) {
+	delete(s, hash)
+}
+
+// Contains reports whether hash is in the set.
+func (s HashSet) Contains(hash Hash) bool {
+	_, ok := s[hash]
+	return ok
+}
+
+// Len returns the number of hashes in the set.
+func (s HashSet) Len() int {
+	return len(s)
+}
+
+// List returns the hashes of the set in ascending byte order.
+func (s HashSet) List() []Hash {
+	list := make([]Hash, 0, len(s))
+	for hash := range s {
+		list = append(list, hash)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Less(list[j]) })
+	return list
+}
+
+// RecentHashes remembers the last hashes added to it, up to a fixed capacity,
+// e.g. of recently seen blocks. Once full, adding a hash evicts the oldest one.
+// It is safe for concurrent use.
+type RecentHashes struct {
+	ring  []Hash       // Hashes in insertion order, wrapping around at next
+	next  int          // Index of the oldest hash, overwritten by the next Add
+	index HashSet      // Hashes in ring, for constant time lookups
+	lock  sync.RWMutex // Protects the fields above
+}
+
+// NewRecentHashes creates a buffer remembering the last size hashes. A size
+// below one is treated as one.
+func NewRecentHashes(size int) *RecentHashes {
+	if size < 1 {
+		size = 1
+	}
+	return &RecentHashes{
+		ring:  make([]Hash, 0, size),
+		index: NewHashSet(),
+	}
+}
+
+// Add remembers hash, evicting the oldest hash if the buffer is full. Adding a
+// hash already remembered does not refresh it.
+func (r *RecentHashes) Add(hash Hash) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.index.Contains(hash) {
+		return
+	}
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, hash)
+	} else {
+		r.index.Remove(r.ring[r.next])
+		r.ring[r.next] = hash
+		r.next = (r.next + 1) % len(r.ring)
+	}
+	r.index.Add(hash)
+}
+
+// Seen reports whether hash is among the remembered hashes.
+func (r *RecentHashes) Seen(hash Hash) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.index.Contains(hash)
+}
+
+// Reset forgets every remembered hash.
+func (r *RecentHashes) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.ring = r.ring[:0]
+	r.next = 0
+	r.index = NewHashSet()
+}
+
+// Len returns the number of remembered hashes.
+func (r *RecentHashes) Len() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return len(r.ring)
+}
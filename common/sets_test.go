@@ -0,0 +1,229 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+)
+
+func TestAddressSet(t *testing.T) {
+	a, b, c := HexToAddress("0x03"), HexToAddress("0x01"), HexToAddress("0x02")
+
+	set := NewAddressSet(a, b)
+	set.Add(a)
+	if set.Len() != 2 {
+		t.Fatalf("length mismatch: have %d, want 2", set.Len())
+	}
+	if !set.Contains(a) || !set.Contains(b) || set.Contains(c) {
+		t.Errorf("membership mismatch for %v", set.List())
+	}
+
+	union := set.Union(NewAddressSet(c))
+	if union.Len() != 3 || !union.Contains(c) {
+		t.Errorf("union mismatch: have %v", union.List())
+	}
+	diff := union.Difference(NewAddressSet(a))
+	if diff.Len() != 2 || diff.Contains(a) {
+		t.Errorf("difference mismatch: have %v", diff.List())
+	}
+	inter := union.Intersect(NewAddressSet(a, c, HexToAddress("0x04")))
+	if inter.Len() != 2 || !inter.Contains(a) || !inter.Contains(c) {
+		t.Errorf("intersection mismatch: have %v", inter.List())
+	}
+	if empty := set.Intersect(NewAddressSet()); empty.Len() != 0 {
+		t.Errorf("intersection with empty set: have %v", empty.List())
+	}
+	if set.Len() != 2 || union.Len() != 3 {
+		t.Errorf("set operations modified the receiver")
+	}
+	set.Remove(a)
+	set.Remove(c)
+	if set.Len() != 1 || set.Contains(a) || !set.Contains(b) {
+		t.Errorf("removal mismatch: have %v", set.List())
+	}
+}
+
+func TestAddressSetJSON(t *testing.T) {
+	set := NewAddressSet(HexToAddress("0x03"), HexToAddress("0x01"), HexToAddress("0x02"))
+	enc, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `["0x0000000000000000000000000000000000000001","0x0000000000000000000000000000000000000002","0x0000000000000000000000000000000000000003"]`
+	if string(enc) != want {
+		t.Errorf("encoding mismatch:\nhave %s\nwant %s", enc, want)
+	}
+	var dec AddressSet
+	if err := json.Unmarshal(enc, &dec); err != nil {
+		t.Fatal(err)
+	}
+	if dec.Len() != set.Len() || dec.Difference(set).Len() != 0 {
+		t.Errorf("round trip mismatch: have %v, want %v", dec.List(), set.List())
+	}
+	// Addresses are checksummed and ordered by value, not by their encoding
+	set = NewAddressSet(
+		HexToAddress("0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359"),
+		HexToAddress("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"),
+	)
+	enc, err = json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `["0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed","0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"]`
+	if string(enc) != want {
+		t.Errorf("checksummed encoding mismatch:\nhave %s\nwant %s", enc, want)
+	}
+	if err := json.Unmarshal(enc, &dec); err != nil {
+		t.Fatal(err)
+	}
+	if dec.Intersect(set).Len() != 2 {
+		t.Errorf("checksummed round trip mismatch: have %v, want %v", dec.List(), set.List())
+	}
+}
+
+func BenchmarkAddressSetContains(b *testing.B) {
+	set := NewAddressSet()
+	for i := 0; i < 1000; i++ {
+		set.Add(BigToAddress(big.NewInt(int64(i))))
+	}
+	addr := BigToAddress(big.NewInt(500))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.Contains(addr)
+	}
+}
+
+func TestHashSet(t *testing.T) {
+	a, b, c := HexToHash("0x03"), HexToHash("0x01"), HexToHash("0x02")
+
+	set := NewHashSet(a, b)
+	set.Add(a)
+	if set.Len() != 2 {
+		t.Fatalf("length mismatch: have %d, want 2", set.Len())
+	}
+	if !set.Contains(a) || !set.Contains(b) || set.Contains(c) {
+		t.Errorf("membership mismatch for %v", set.List())
+	}
+	if list := set.List(); len(list) != 2 || list[0] != b || list[1] != a {
+		t.Errorf("list mismatch: have %v, want [%v %v]", list, b, a)
+	}
+	set.Remove(a)
+	set.Remove(c)
+	if set.Len() != 1 || set.Contains(a) || !set.Contains(b) {
+		t.Errorf("removal mismatch: have %v", set.List())
+	}
+}
+
+// hashSetSizes are the set sizes the HashSet benchmarks run at.
+var hashSetSizes = []int{1000, 100000}
+
+func BenchmarkHashSetAdd(b *testing.B) {
+	for _, size := range hashSetSizes {
+		hashes := make([]Hash, size)
+		for i := range hashes {
+			hashes[i] = BigToHash(big.NewInt(int64(i)))
+		}
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				set := NewHashSet()
+				for _, hash := range hashes {
+					set.Add(hash)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkHashSetContains(b *testing.B) {
+	for _, size := range hashSetSizes {
+		set := NewHashSet()
+		for i := 0; i < size; i++ {
+			set.Add(BigToHash(big.NewInt(int64(i))))
+		}
+		hash := BigToHash(big.NewInt(int64(size / 2)))
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				set.Contains(hash)
+			}
+		})
+	}
+}
+
+func TestRecentHashes(t *testing.T) {
+	hashes := make([]Hash, 5)
+	for i := range hashes {
+		hashes[i] = BigToHash(big.NewInt(int64(i + 1)))
+	}
+	recent := NewRecentHashes(3)
+	for _, hash := range hashes[:3] {
+		recent.Add(hash)
+	}
+	// Re-adding a remembered hash neither duplicates nor refreshes it
+	recent.Add(hashes[0])
+	if recent.Len() != 3 {
+		t.Fatalf("length mismatch: have %d, want 3", recent.Len())
+	}
+	for i, hash := range hashes[:3] {
+		if !recent.Seen(hash) {
+			t.Errorf("hash %d forgotten before eviction", i)
+		}
+	}
+	// Each further hash evicts the oldest one
+	for i := 3; i < len(hashes); i++ {
+		recent.Add(hashes[i])
+		if recent.Seen(hashes[i-3]) {
+			t.Errorf("hash %d not evicted by hash %d", i-3, i)
+		}
+		for j := i - 2; j <= i; j++ {
+			if !recent.Seen(hashes[j]) {
+				t.Errorf("hash %d forgotten after adding hash %d", j, i)
+			}
+		}
+	}
+	if recent.Len() != 3 {
+		t.Errorf("length mismatch after eviction: have %d, want 3", recent.Len())
+	}
+	// Reset forgets everything, and the buffer fills up again
+	recent.Reset()
+	if recent.Len() != 0 || recent.Seen(hashes[4]) {
+		t.Errorf("hashes remembered after reset")
+	}
+	for _, hash := range hashes {
+		recent.Add(hash)
+	}
+	if recent.Len() != 3 || recent.Seen(hashes[1]) || !recent.Seen(hashes[2]) {
+		t.Errorf("buffer mismatch after refilling")
+	}
+	// A buffer of one remembers the last hash only
+	single := NewRecentHashes(0)
+	single.Add(hashes[0])
+	single.Add(hashes[1])
+	if single.Seen(hashes[0]) || !single.Seen(hashes[1]) {
+		t.Errorf("single entry buffer mismatch")
+	}
+}
+
+func TestRecentHashesConcurrent(t *testing.T) {
+	recent := NewRecentHashes(64)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(offset int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				hash := BigToHash(big.NewInt(int64(offset*1000 + j)))
+				recent.Add(hash)
+				recent.Seen(hash)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if recent.Len() != 64 {
+		t.Errorf("length mismatch: have %d, want 64", recent.Len())
+	}
+}
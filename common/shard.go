@@ -0,0 +1,55 @@
+package common
+
+import "strconv"
+
+// Shard identifies one of the chains of the live networks' hierarchy, see
+// DefaultHierarchyParams. Shards are numbered like Location.ID, so they can be
+// switched on exhaustively instead of comparing location bytes.
+type Shard uint8
+
+const (
+	ShardPrime Shard = iota
+	ShardCyprus
+	ShardCyprus1
+	ShardCyprus2
+	ShardCyprus3
+	ShardPaxos
+	ShardPaxos1
+	ShardPaxos2
+	ShardPaxos3
+	ShardHydra
+	ShardHydra1
+	ShardHydra2
+	ShardHydra3
+
+	// numShards is the number of chains of the default hierarchy.
+	numShards = iota
+)
+
+// Location returns the location of the shard, or nil if s is not a shard.
+func (s Shard) Location() Location {
+	if s >= numShards {
+		return nil
+	}
+	loc, _ := DefaultHierarchyParams.locationFromID(int(s))
+	return loc
+}
+
+// String returns the name of the shard's chain, e.g. "cyprus1".
+func (s Shard) String() string {
+	if s >= numShards {
+		return "Shard(" + strconv.Itoa(int(s)) + ")"
+	}
+	return locationName(s.Location())
+}
+
+// ShardFromLocation returns the shard of a location, if the location names a
+// chain of the default hierarchy.
+func ShardFromLocation(loc Location) (Shard, bool) {
+	for s := ShardPrime; s < numShards; s++ {
+		if s.Location().Equal(loc) {
+			return s, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,78 @@
+package common
+
+import "testing"
+
+func TestShardLocation(t *testing.T) {
+	tests := []struct {
+		shard Shard
+		loc   Location
+		name  string
+	}{
+		{ShardPrime, Location{}, "prime"},
+		{ShardCyprus, Location{0}, "cyprus"},
+		{ShardCyprus1, Location{0, 0}, "cyprus1"},
+		{ShardCyprus2, Location{0, 1}, "cyprus2"},
+		{ShardCyprus3, Location{0, 2}, "cyprus3"},
+		{ShardPaxos, Location{1}, "paxos"},
+		{ShardPaxos1, Location{1, 0}, "paxos1"},
+		{ShardPaxos2, Location{1, 1}, "paxos2"},
+		{ShardPaxos3, Location{1, 2}, "paxos3"},
+		{ShardHydra, Location{2}, "hydra"},
+		{ShardHydra1, Location{2, 0}, "hydra1"},
+		{ShardHydra2, Location{2, 1}, "hydra2"},
+		{ShardHydra3, Location{2, 2}, "hydra3"},
+	}
+	if len(tests) != numShards {
+		t.Fatalf("have %d test cases, want one per shard (%d)", len(tests), numShards)
+	}
+	for _, tt := range tests {
+		if loc := tt.shard.Location(); !loc.Equal(tt.loc) {
+			t.Errorf("%s: location mismatch: have %v, want %v", tt.name, []byte(loc), []byte(tt.loc))
+		}
+		if name := tt.shard.String(); name != tt.name {
+			t.Errorf("%v: name mismatch: have %s, want %s", []byte(tt.loc), name, tt.name)
+		}
+		if shard, ok := ShardFromLocation(tt.loc); !ok || shard != tt.shard {
+			t.Errorf("%s: shard mismatch: have %d (%v), want %d", tt.name, shard, ok, tt.shard)
+		}
+	}
+}
+
+func TestShardInvalid(t *testing.T) {
+	for _, loc := range []Location{{3}, {0, 3}, {0, 0, 0}} {
+		if shard, ok := ShardFromLocation(loc); ok {
+			t.Errorf("%v: unexpected shard %s", []byte(loc), shard)
+		}
+	}
+	// A nil location is prime, like an empty one
+	if shard, ok := ShardFromLocation(nil); !ok || shard != ShardPrime {
+		t.Errorf("nil location: have shard %s (%v), want %s", shard, ok, ShardPrime)
+	}
+	if loc := Shard(numShards).Location(); loc != nil {
+		t.Errorf("out of range shard: have location %v, want nil", []byte(loc))
+	}
+	if name := Shard(numShards).String(); name != "Shard(13)" {
+		t.Errorf("out of range shard: have name %q, want %q", name, "Shard(13)")
+	}
+}
+
+// TestShardsMatchHierarchy checks that the shards stay in sync with the chains
+// and address prefix ranges of the default hierarchy.
+func TestShardsMatchHierarchy(t *testing.T) {
+	h := newHierarchy(DefaultHierarchyParams)
+	if n := DefaultHierarchyParams.NumChains(); n != numShards || len(h.prefixRanges) != numShards {
+		t.Fatalf("shard count mismatch: have %d, hierarchy has %d chains and %d prefix ranges", numShards, n, len(h.prefixRanges))
+	}
+	for s := ShardPrime; s < numShards; s++ {
+		loc := s.Location()
+		if loc.ID() != int(s) {
+			t.Errorf("%s: id mismatch: have %d, want %d", s, loc.ID(), s)
+		}
+		if _, ok := h.prefixRanges[s.String()]; !ok {
+			t.Errorf("%s: no address prefix range", s)
+		}
+		if loc.Name() != s.String() {
+			t.Errorf("%s: location name mismatch: have %s", s, loc.Name())
+		}
+	}
+}
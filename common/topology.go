@@ -0,0 +1,92 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TestTopology describes a subset of the hierarchy of chains run by a test
+// cluster, with the dominant/subordinate links between them.
+type TestTopology struct {
+	// Locations of the chains in the topology, ordered by ID
+	Locations []Location
+
+	subs map[int][]Location
+}
+
+// BuildTestTopology validates that locs form a possible topology, i.e. that
+// every chain's dominant chains are part of it too, and links the chains. The
+// error for an incomplete set names all missing ancestors.
+func BuildTestTopology(locs []Location) (*TestTopology, error) {
+	included := make(map[int]bool)
+	for _, loc := range locs {
+		id := loc.ID()
+		if id < 0 {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidLocation, []byte(loc))
+		}
+		if included[id] {
+			return nil, fmt.Errorf("duplicate location %s", loc.Name())
+		}
+		included[id] = true
+	}
+	// Every dominant of an included chain must be included too
+	missing := make(map[int]bool)
+	for _, loc := range locs {
+		for id := 0; id < GetHierarchyParams().NumChains(); id++ {
+			if dom, _ := LocationFromID(id); dom.IsDominantOf(loc) && !included[id] {
+				missing[id] = true
+			}
+		}
+	}
+	if len(missing) > 0 {
+		ids := make([]int, 0, len(missing))
+		for id := range missing {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		names := make([]string, len(ids))
+		for i, id := range ids {
+			loc, _ := LocationFromID(id)
+			names[i] = loc.Name()
+		}
+		return nil, fmt.Errorf("topology missing ancestors: %s", strings.Join(names, ", "))
+	}
+	topology := &TestTopology{subs: make(map[int][]Location)}
+	for id := 0; id < GetHierarchyParams().NumChains(); id++ {
+		if !included[id] {
+			continue
+		}
+		loc, _ := LocationFromID(id)
+		topology.Locations = append(topology.Locations, loc)
+		if len(loc) > 0 {
+			dom := loc.DomLocation()
+			topology.subs[dom.ID()] = append(topology.subs[dom.ID()], loc)
+		}
+	}
+	return topology, nil
+}
+
+// Dom returns the dominant chain of loc, which is false for prime and chains
+// outside of the topology.
+func (t *TestTopology) Dom(loc Location) (Location, bool) {
+	if len(loc) == 0 || !t.Contains(loc) {
+		return nil, false
+	}
+	return loc.DomLocation(), true
+}
+
+// Subs returns the subordinate chains of loc which are part of the topology.
+func (t *TestTopology) Subs(loc Location) []Location {
+	return t.subs[loc.ID()]
+}
+
+// Contains reports whether loc is part of the topology.
+func (t *TestTopology) Contains(loc Location) bool {
+	for _, l := range t.Locations {
+		if l.Equal(loc) {
+			return true
+		}
+	}
+	return false
+}
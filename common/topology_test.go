@@ -0,0 +1,56 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestBuildTestTopology(t *testing.T) {
+	topology, err := BuildTestTopology([]Location{{0, 1}, {0}, {}, {0, 0}, {2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Location{{}, {0}, {0, 0}, {0, 1}, {2}}
+	if len(topology.Locations) != len(want) {
+		t.Fatalf("locations mismatch: have %v, want %v", topology.Locations, want)
+	}
+	for i := range want {
+		if !topology.Locations[i].Equal(want[i]) {
+			t.Errorf("location %d: have %v, want %v", i, topology.Locations[i], want[i])
+		}
+	}
+	if subs := topology.Subs(Location{}); len(subs) != 2 || !subs[0].Equal(Location{0}) || !subs[1].Equal(Location{2}) {
+		t.Errorf("prime subordinates: have %v", subs)
+	}
+	if subs := topology.Subs(Location{0}); len(subs) != 2 || !subs[0].Equal(Location{0, 0}) || !subs[1].Equal(Location{0, 1}) {
+		t.Errorf("cyprus subordinates: have %v", subs)
+	}
+	if subs := topology.Subs(Location{2}); len(subs) != 0 {
+		t.Errorf("hydra subordinates: have %v", subs)
+	}
+	if dom, ok := topology.Dom(Location{0, 1}); !ok || !dom.Equal(Location{0}) {
+		t.Errorf("cyprus2 dominant: have %v (%v)", dom, ok)
+	}
+	if _, ok := topology.Dom(Location{}); ok {
+		t.Error("prime has a dominant")
+	}
+	if _, ok := topology.Dom(Location{1, 1}); ok {
+		t.Error("chain outside the topology has a dominant")
+	}
+}
+
+func TestBuildTestTopologyErrors(t *testing.T) {
+	tests := []struct {
+		locs []Location
+		err  string
+	}{
+		{[]Location{{0, 1}}, "topology missing ancestors: prime, cyprus"},
+		{[]Location{{}, {1, 2}, {2}}, "topology missing ancestors: paxos"},
+		{[]Location{{}, {0}, {0}}, "duplicate location cyprus"},
+		{[]Location{{}, {4}}, "invalid location: [4]"},
+	}
+	for _, tt := range tests {
+		if _, err := BuildTestTopology(tt.locs); err == nil || err.Error() != tt.err {
+			t.Errorf("%v: error mismatch: have %v, want %q", tt.locs, err, tt.err)
+		}
+	}
+}
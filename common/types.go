@@ -18,19 +18,25 @@ package common
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"database/sql/driver"
+	"encoding/base32"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math/big"
 	"math/rand"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/dominant-strategies/go-quai/common/hexutil"
+	"github.com/dominant-strategies/go-quai/rlp"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -46,22 +52,48 @@ const (
 	REGION_CTX = 1
 	ZONE_CTX   = 2
 
-	// Depth of the hierarchy of chains
+	// Shape of the default hierarchy of chains, see HierarchyParams
 	NumRegionsInPrime = 3
 	NumZonesInRegion  = 3
 	HierarchyDepth    = 3
-)
 
-var (
-	// Default to prime node, but changed at startup by config.
-	NodeLocation = Location{}
+	// NumChains is the number of chains in the default hierarchy: prime, and
+	// each region followed by its zones
+	NumChains = 1 + NumRegionsInPrime*(1+NumZonesInRegion)
 )
 
+// nodeLocation holds the Location of the chain this node runs, prime unless
+// configured otherwise at startup.
+var nodeLocation atomic.Value
+
+func init() {
+	nodeLocation.Store(Location{})
+}
+
+// GetNodeLocation returns the location of the chain this node runs. It is safe
+// for concurrent use with SetNodeLocation. The returned location is shared and
+// must not be modified.
+func GetNodeLocation() Location {
+	return nodeLocation.Load().(Location)
+}
+
+// SetNodeLocation sets the location of the chain this node runs, exiting if
+// the location is invalid. A copy of loc is stored, with no spare capacity so
+// that appending to the shared location always reallocates.
+func SetNodeLocation(loc Location) {
+	loc.AssertValid()
+	stored := make(Location, len(loc))
+	copy(stored, loc)
+	nodeLocation.Store(stored)
+}
+
 var (
 	hashT    = reflect.TypeOf(Hash{})
 	addressT = reflect.TypeOf(Address{})
 	// The zero address (0x0)
 	ZeroAddr = BytesToAddress([]byte{0})
+	// The zero hash (0x0)
+	ZeroHash = Hash{}
 )
 
 // Hash represents the 32 byte Keccak256 hash of arbitrary data.
@@ -83,19 +115,157 @@ func BigToHash(b *big.Int) Hash { return BytesToHash(b.Bytes()) }
 // If b is larger than len(h), b will be cropped from the left.
 func HexToHash(s string) Hash { return BytesToHash(FromHex(s)) }
 
+// ErrInvalidHex is returned by the strict hex constructors for input which is
+// not a hex string of the exact expected length.
+var ErrInvalidHex = errors.New("invalid hex string")
+
+// fromHexStrict decodes s, optionally prefixed with "0x", requiring exactly
+// length bytes of valid hex.
+func fromHexStrict(s string, length int) ([]byte, error) {
+	raw := s
+	if has0xPrefix(raw) {
+		raw = raw[2:]
+	}
+	if len(raw) != 2*length {
+		return nil, fmt.Errorf("%w: %q has %d hex digits, want %d", ErrInvalidHex, s, len(raw), 2*length)
+	}
+	b, err := hex.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidHex, s, err)
+	}
+	return b, nil
+}
+
+// HexToHashStrict is HexToHash for input which must be exactly HashLength
+// bytes of valid hex. Unlike HexToHash, other input is rejected rather than
+// cropped or padded.
+func HexToHashStrict(s string) (Hash, error) {
+	b, err := fromHexStrict(s, HashLength)
+	if err != nil {
+		return Hash{}, err
+	}
+	return BytesToHash(b), nil
+}
+
 // Bytes gets the byte representation of the underlying hash.
 func (h Hash) Bytes() []byte { return h[:] }
 
 // Big converts a hash to a big integer.
 func (h Hash) Big() *big.Int { return new(big.Int).SetBytes(h[:]) }
 
+// IsZero reports whether h is the zero hash.
+func (h Hash) IsZero() bool { return h == ZeroHash }
+
+// hashWritePool holds the buffers Hash.WriteTo hands to writers, so that the
+// hash does not escape to the heap.
+var hashWritePool = sync.Pool{
+	New: func() interface{} { return new(Hash) },
+}
+
+// WriteTo implements io.WriterTo, writing the 32 bytes of the hash to w. Unlike
+// w.Write(h.Bytes()) it does not allocate.
+func (h Hash) WriteTo(w io.Writer) (int64, error) {
+	buf := hashWritePool.Get().(*Hash)
+	*buf = h
+	n, err := w.Write(buf[:])
+	hashWritePool.Put(buf)
+	return int64(n), err
+}
+
 // Hex converts a hash to a hex string.
 func (h Hash) Hex() string { return hexutil.Encode(h[:]) }
 
 // TerminalString implements log.TerminalStringer, formatting a string for console
 // output during logging.
 func (h Hash) TerminalString() string {
-	return fmt.Sprintf("%x..%x", h[:3], h[29:])
+	return h.ShortPrefixSuffix(6)
+}
+
+// Short returns the first n hex digits of the hash, without 0x prefix. A
+// negative n is treated as zero and an n beyond the hash length as the full
+// length.
+func (h Hash) Short(n int) string {
+	var buf [2 * HashLength]byte
+	hex.Encode(buf[:], h[:])
+	return string(buf[:clampHexDigits(n)])
+}
+
+// ShortPrefixSuffix returns the first and last n hex digits of the hash joined
+// by "..", e.g. "b26f2b..151000" for n = 6. If the two ends would overlap, the
+// full hash is returned without 0x prefix instead.
+func (h Hash) ShortPrefixSuffix(n int) string {
+	var buf [2 * HashLength]byte
+	hex.Encode(buf[:], h[:])
+	n = clampHexDigits(n)
+	if 2*n >= len(buf) {
+		return string(buf[:])
+	}
+	return string(buf[:n]) + ".." + string(buf[len(buf)-n:])
+}
+
+// clampHexDigits bounds a number of hex digits of a hash to [0, 2*HashLength].
+func clampHexDigits(n int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > 2*HashLength {
+		return 2 * HashLength
+	}
+	return n
+}
+
+// shortIDEncoding is Crockford's base32, which omits the easily confused
+// letters I, L, O and U.
+var shortIDEncoding = base32.NewEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ").WithPadding(base32.NoPadding)
+
+// Cmp compares two hashes as big-endian 256-bit integers, returning -1, 0 or 1
+// like bytes.Compare, so the ordering matches that of Hash.Big.
+func (h Hash) Cmp(other Hash) int {
+	return bytes.Compare(h[:], other[:])
+}
+
+// Less reports whether h orders before other, e.g. for sort.Slice.
+func (h Hash) Less(other Hash) bool {
+	return h.Cmp(other) < 0
+}
+
+// Xor returns the byte-wise exclusive or of h and other.
+func (h Hash) Xor(other Hash) Hash {
+	for i := range h {
+		h[i] ^= other[i]
+	}
+	return h
+}
+
+// And returns the byte-wise and of h and other.
+func (h Hash) And(other Hash) Hash {
+	for i := range h {
+		h[i] &= other[i]
+	}
+	return h
+}
+
+// Or returns the byte-wise or of h and other.
+func (h Hash) Or(other Hash) Hash {
+	for i := range h {
+		h[i] |= other[i]
+	}
+	return h
+}
+
+// DistanceTo returns the XOR distance between h and other as used for
+// Kademlia-style routing, interpreting the hashes as big endian integers.
+func (h Hash) DistanceTo(other Hash) *big.Int {
+	d := h.Xor(other)
+	return new(big.Int).SetBytes(d[:])
+}
+
+// ShortID returns a compact, copy-pasteable reference to the hash for display,
+// e.g. in dashboards: the first 8 bytes encoded as 13 characters of Crockford
+// base32. The truncation makes it irreversible, and while collisions are
+// unlikely (64 bits), it must not be used as a unique key.
+func (h Hash) ShortID() string {
+	return shortIDEncoding.EncodeToString(h[:8])
 }
 
 // String implements the stringer interface and is used also by the logger when
@@ -105,7 +275,10 @@ func (h Hash) String() string {
 }
 
 // Format implements fmt.Formatter.
-// Hash supports the %v, %s, %v, %x, %X and %d format verbs.
+// Hash supports the %v, %s, %q, %x, %X and %d format verbs. For all but %d, a
+// precision truncates the output to that many hex digits, not counting the
+// 0x prefix, and a width pads it with spaces, on the left unless the '-' flag
+// is given.
 func (h Hash) Format(s fmt.State, c rune) {
 	hexb := make([]byte, 2+len(h)*2)
 	copy(hexb, "0x")
@@ -119,14 +292,15 @@ func (h Hash) Format(s fmt.State, c rune) {
 		if c == 'X' {
 			hexb = bytes.ToUpper(hexb)
 		}
-		fallthrough
+		writePadded(s, truncateHex(s, hexb))
 	case 'v', 's':
-		s.Write(hexb)
+		writePadded(s, truncateHex(s, hexb))
 	case 'q':
-		q := []byte{'"'}
-		s.Write(q)
-		s.Write(hexb)
-		s.Write(q)
+		hexb = truncateHex(s, hexb)
+		q := make([]byte, 0, len(hexb)+2)
+		q = append(q, '"')
+		q = append(q, hexb...)
+		writePadded(s, append(q, '"'))
 	case 'd':
 		fmt.Fprint(s, ([len(h)]byte)(h))
 	default:
@@ -134,14 +308,113 @@ func (h Hash) Format(s fmt.State, c rune) {
 	}
 }
 
+// truncateHex cuts hexb, which may carry a 0x prefix, down to the number of
+// hex digits given by the precision of s, if any.
+func truncateHex(s fmt.State, hexb []byte) []byte {
+	prec, ok := s.Precision()
+	if !ok {
+		return hexb
+	}
+	prefix := 0
+	if len(hexb) >= 2 && hexb[0] == '0' && (hexb[1] == 'x' || hexb[1] == 'X') {
+		prefix = 2
+	}
+	if prefix+prec < len(hexb) {
+		hexb = hexb[:prefix+prec]
+	}
+	return hexb
+}
+
+// writePadded writes b to s, padded with spaces to the width of s, if any.
+func writePadded(s fmt.State, b []byte) {
+	width, ok := s.Width()
+	if !ok || width <= len(b) {
+		s.Write(b)
+		return
+	}
+	pad := bytes.Repeat([]byte{' '}, width-len(b))
+	if s.Flag('-') {
+		s.Write(b)
+		s.Write(pad)
+		return
+	}
+	s.Write(pad)
+	s.Write(b)
+}
+
 // UnmarshalText parses a hash in hex syntax.
 func (h *Hash) UnmarshalText(input []byte) error {
 	return hexutil.UnmarshalFixedText("Hash", input, h[:])
 }
 
-// UnmarshalJSON parses a hash in hex syntax.
+// UnmarshalJSON parses a hash in hex syntax. Following the encoding/json
+// convention for optional fields, a JSON null leaves the hash unchanged. An
+// empty string is rejected, as is any string which is not exactly the 0x
+// prefixed hex of a hash, see unmarshalStrictHexJSON.
 func (h *Hash) UnmarshalJSON(input []byte) error {
-	return hexutil.UnmarshalFixedJSON(hashT, input, h[:])
+	if isNullJSON(input) {
+		return nil
+	}
+	if isEmptyJSONString(input) {
+		return errEmptyJSONString(hashT)
+	}
+	return unmarshalStrictHexJSON(hashT, input, h[:])
+}
+
+// UnmarshalHashesInto decodes a JSON array of hex hashes into dst, returning
+// the number of hashes decoded. Unlike unmarshalling into a []Hash, it does
+// not allocate, which matters when decoding large hash lists during sync.
+// Arrays with more elements than dst can hold are rejected. Errors name the
+// index of the offending element.
+func UnmarshalHashesInto(data []byte, dst []Hash) (int, error) {
+	pos := skipJSONSpace(data, 0)
+	if pos >= len(data) || data[pos] != '[' {
+		return 0, errors.New("hash list is not a JSON array")
+	}
+	pos = skipJSONSpace(data, pos+1)
+	if pos < len(data) && data[pos] == ']' {
+		return 0, checkJSONEnd(data, pos+1)
+	}
+	for n := 0; ; n++ {
+		if pos >= len(data) || data[pos] != '"' {
+			return n, fmt.Errorf("hash %d: not a JSON string", n)
+		}
+		end := bytes.IndexByte(data[pos+1:], '"')
+		if end < 0 {
+			return n, fmt.Errorf("hash %d: unterminated string", n)
+		}
+		if n >= len(dst) {
+			return n, fmt.Errorf("hash %d: more than %d hashes", n, len(dst))
+		}
+		if err := hexutil.UnmarshalFixedText("Hash", data[pos+1:pos+1+end], dst[n][:]); err != nil {
+			return n, fmt.Errorf("hash %d: %v", n, err)
+		}
+		pos = skipJSONSpace(data, pos+end+2)
+		if pos < len(data) && data[pos] == ']' {
+			return n + 1, checkJSONEnd(data, pos+1)
+		}
+		if pos >= len(data) || data[pos] != ',' {
+			return n + 1, fmt.Errorf("hash %d: expected ',' or ']'", n)
+		}
+		pos = skipJSONSpace(data, pos+1)
+	}
+}
+
+// skipJSONSpace returns the position of the first non-whitespace byte of data
+// at or after pos.
+func skipJSONSpace(data []byte, pos int) int {
+	for pos < len(data) && (data[pos] == ' ' || data[pos] == '\t' || data[pos] == '\n' || data[pos] == '\r') {
+		pos++
+	}
+	return pos
+}
+
+// checkJSONEnd ensures nothing but whitespace follows pos.
+func checkJSONEnd(data []byte, pos int) error {
+	if pos = skipJSONSpace(data, pos); pos != len(data) {
+		return fmt.Errorf("unexpected data after hash list at offset %d", pos)
+	}
+	return nil
 }
 
 // MarshalText returns the hex representation of h.
@@ -186,6 +459,46 @@ func (h Hash) Value() (driver.Value, error) {
 	return h[:], nil
 }
 
+func isNullJSON(input []byte) bool {
+	return string(input) == "null"
+}
+
+func isEmptyJSONString(input []byte) bool {
+	return string(input) == `""`
+}
+
+func errEmptyJSONString(typ reflect.Type) error {
+	return &json.UnmarshalTypeError{Value: "empty string", Type: typ}
+}
+
+// ErrHexLength is returned when decoding a JSON hex string whose number of hex
+// digits does not match the size of the fixed-size type decoded into.
+var ErrHexLength = errors.New("wrong hex length")
+
+// unmarshalStrictHexJSON decodes a JSON hex string into the fixed-size out,
+// like hexutil.UnmarshalFixedJSON, but rejects strings containing whitespace,
+// control characters, escape sequences (e.g. an escaped NUL byte) or non-ASCII
+// bytes outright, and strings of any other length than out with an error
+// wrapping ErrHexLength, so that malformed input from untrusted sources can
+// never decode into a valid-looking value.
+func unmarshalStrictHexJSON(typ reflect.Type, input, out []byte) error {
+	if len(input) < 2 || input[0] != '"' || input[len(input)-1] != '"' {
+		return hexutil.UnmarshalFixedJSON(typ, input, out)
+	}
+	raw := input[1 : len(input)-1]
+	for i, c := range raw {
+		if c <= ' ' || c == '\\' || c >= 0x7f {
+			return &json.UnmarshalTypeError{Value: fmt.Sprintf("hex string with invalid character %q at offset %d", c, i), Type: typ}
+		}
+	}
+	if has0xPrefix(string(raw)) {
+		if digits := len(raw) - 2; digits != 2*len(out) {
+			return fmt.Errorf("%w for %v: have %d hex digits, want %d", ErrHexLength, typ, digits, 2*len(out))
+		}
+	}
+	return hexutil.UnmarshalFixedJSON(typ, input, out)
+}
+
 // UnprefixedHash allows marshaling a Hash without 0x prefix.
 type UnprefixedHash Hash
 
@@ -213,24 +526,192 @@ func NewRange(l, h uint8) addrPrefixRange {
 	}
 }
 
-var (
-	locationToPrefixRange = make(map[string]addrPrefixRange)
-)
+// prefixesPerChain is the number of leading address bytes owned by each chain.
+// Chains own consecutive ranges in ID order: prime 0 to 9, cyprus 10 to 19,
+// cyprus1 20 to 29 and so on.
+const prefixesPerChain = 10
+
+// maxHierarchyChains bounds the number of chains of a hierarchy, so that every
+// chain fits the 16 bit location bitmask.
+const maxHierarchyChains = 16
+
+// ErrInvalidHierarchy is returned for hierarchy parameters which do not
+// describe a supported hierarchy.
+var ErrInvalidHierarchy = errors.New("invalid hierarchy parameters")
+
+// HierarchyParams describes the shape of the hierarchy of chains. The live
+// networks use DefaultHierarchyParams, test networks may experiment with other
+// topologies through SetHierarchyParams.
+type HierarchyParams struct {
+	Depth          int // Number of contexts, at most HierarchyDepth as headers carry one slot per context
+	RegionsInPrime int // Number of regions below prime
+	ZonesInRegion  int // Number of zones below each region
+}
+
+// DefaultHierarchyParams is the hierarchy of the live networks.
+var DefaultHierarchyParams = HierarchyParams{
+	Depth:          HierarchyDepth,
+	RegionsInPrime: NumRegionsInPrime,
+	ZonesInRegion:  NumZonesInRegion,
+}
+
+// NumChains returns the number of chains in the hierarchy: prime, and each
+// region followed by its zones.
+func (p HierarchyParams) NumChains() int {
+	switch {
+	case p.Depth <= REGION_CTX:
+		return 1
+	case p.Depth == ZONE_CTX:
+		return 1 + p.RegionsInPrime
+	default:
+		return 1 + p.RegionsInPrime*(1+p.ZonesInRegion)
+	}
+}
+
+// Validate returns an error wrapping ErrInvalidHierarchy if the parameters do
+// not describe a supported hierarchy.
+func (p HierarchyParams) Validate() error {
+	if p.Depth < 1 || p.Depth > HierarchyDepth {
+		return fmt.Errorf("%w: depth %d outside [1, %d]", ErrInvalidHierarchy, p.Depth, HierarchyDepth)
+	}
+	if p.Depth > REGION_CTX && p.RegionsInPrime < 1 {
+		return fmt.Errorf("%w: %d regions in prime", ErrInvalidHierarchy, p.RegionsInPrime)
+	}
+	if p.Depth > ZONE_CTX && p.ZonesInRegion < 1 {
+		return fmt.Errorf("%w: %d zones in region", ErrInvalidHierarchy, p.ZonesInRegion)
+	}
+	if n := p.NumChains(); n > maxHierarchyChains {
+		return fmt.Errorf("%w: %d chains, max %d", ErrInvalidHierarchy, n, maxHierarchyChains)
+	}
+	return nil
+}
+
+// locationFromID returns the location with the given ID in the hierarchy, see
+// Location.ID.
+func (p HierarchyParams) locationFromID(id int) (Location, bool) {
+	if id < 0 || id >= p.NumChains() {
+		return nil, false
+	}
+	if id == 0 {
+		return Location{}, true
+	}
+	if p.Depth == ZONE_CTX {
+		return Location{byte(id - 1)}, true
+	}
+	region, zone := (id-1)/(1+p.ZonesInRegion), (id-1)%(1+p.ZonesInRegion)
+	if zone == 0 {
+		return Location{byte(region)}, true
+	}
+	return Location{byte(region), byte(zone - 1)}, true
+}
+
+// regionNames names the regions of the live networks. Further regions of test
+// hierarchies are named by index.
+var regionNames = []string{"cyprus", "paxos", "hydra"}
+
+// regionAbbrevs abbreviates regionNames. The zones of a region are
+// abbreviated by the first letter followed by the zone number. Further regions
+// of test hierarchies are abbreviated by index.
+var regionAbbrevs = []string{"cy", "pa", "hy"}
+
+// locationAbbrev returns the abbreviation of a location, which must be valid.
+func locationAbbrev(loc Location) string {
+	if len(loc) == 0 {
+		return "pr"
+	}
+	if loc.Region() >= len(regionAbbrevs) {
+		// Mark the zone number, as region indices may have several digits
+		abbrev := "r" + strconv.Itoa(loc.Region())
+		if len(loc) == 1 {
+			return abbrev
+		}
+		return abbrev + "z" + strconv.Itoa(loc.Zone()+1)
+	}
+	if len(loc) == 1 {
+		return regionAbbrevs[loc.Region()]
+	}
+	return regionAbbrevs[loc.Region()][:1] + strconv.Itoa(loc.Zone()+1)
+}
+
+// locationName returns the name of a location, which must be valid.
+func locationName(loc Location) string {
+	if len(loc) == 0 {
+		return "prime"
+	}
+	regionName := "region" + strconv.Itoa(loc.Region())
+	if loc.Region() < len(regionNames) {
+		regionName = regionNames[loc.Region()]
+	}
+	if len(loc) == 1 {
+		return regionName
+	}
+	return regionName + strconv.Itoa(loc.Zone()+1)
+}
+
+// hierarchy holds the parameters of the hierarchy in use together with the
+// address prefix tables generated from them.
+type hierarchy struct {
+	params HierarchyParams
+
+	// prefixRanges maps each location name to its address prefix range.
+	prefixRanges map[string]addrPrefixRange
+
+	// prefixLocations maps each leading address byte to the location owning
+	// it, up to the highest owned prefix.
+	prefixLocations []Location
+
+	// prefixNames holds the names of the locations in prefixLocations.
+	prefixNames []string
+}
+
+// newHierarchy generates the address prefix tables of a hierarchy.
+func newHierarchy(params HierarchyParams) *hierarchy {
+	h := &hierarchy{
+		params:       params,
+		prefixRanges: make(map[string]addrPrefixRange),
+	}
+	for id := 0; id < params.NumChains(); id++ {
+		loc, _ := params.locationFromID(id)
+		name := locationName(loc)
+		lo := id * prefixesPerChain
+		h.prefixRanges[name] = NewRange(uint8(lo), uint8(lo+prefixesPerChain-1))
+
+		// Index the owner of every prefix for direct lookups
+		for prefix := lo; prefix < lo+prefixesPerChain; prefix++ {
+			h.prefixLocations = append(h.prefixLocations, loc)
+			h.prefixNames = append(h.prefixNames, name)
+		}
+	}
+	return h
+}
+
+// currentHierarchy holds the *hierarchy in use.
+var currentHierarchy atomic.Value
 
 func init() {
-	locationToPrefixRange["prime"] = NewRange(0, 9)
-	locationToPrefixRange["cyprus"] = NewRange(10, 19)
-	locationToPrefixRange["cyprus1"] = NewRange(20, 29)
-	locationToPrefixRange["cyprus2"] = NewRange(30, 39)
-	locationToPrefixRange["cyprus3"] = NewRange(40, 49)
-	locationToPrefixRange["paxos"] = NewRange(50, 59)
-	locationToPrefixRange["paxos1"] = NewRange(60, 69)
-	locationToPrefixRange["paxos2"] = NewRange(70, 79)
-	locationToPrefixRange["paxos3"] = NewRange(80, 89)
-	locationToPrefixRange["hydra"] = NewRange(90, 99)
-	locationToPrefixRange["hydra1"] = NewRange(100, 109)
-	locationToPrefixRange["hydra2"] = NewRange(110, 119)
-	locationToPrefixRange["hydra3"] = NewRange(120, 129)
+	currentHierarchy.Store(newHierarchy(DefaultHierarchyParams))
+}
+
+// getHierarchy returns the hierarchy in use.
+func getHierarchy() *hierarchy {
+	return currentHierarchy.Load().(*hierarchy)
+}
+
+// GetHierarchyParams returns the parameters of the hierarchy in use.
+func GetHierarchyParams() HierarchyParams {
+	return getHierarchy().params
+}
+
+// SetHierarchyParams changes the shape of the hierarchy, regenerating the
+// address prefix ranges of the chains. It is meant for test networks and must
+// be called before any location is in use, as locations valid under the old
+// hierarchy may not be under the new one.
+func SetHierarchyParams(params HierarchyParams) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	currentHierarchy.Store(newHierarchy(params))
+	return nil
 }
 
 // Address represents the 20 byte address of an Ethereum account.
@@ -248,10 +729,35 @@ func BytesToAddress(b []byte) Address {
 // If b is larger than len(h), b will be cropped from the left.
 func BigToAddress(b *big.Int) Address { return BytesToAddress(b.Bytes()) }
 
+// ErrAddressRange is returned by BigToAddressChecked for integers which do not
+// fit an address.
+var ErrAddressRange = errors.New("integer outside of address range")
+
+// BigToAddressChecked is BigToAddress for integers which must be within
+// [0, 2^160-1]. Unlike BigToAddress, other integers are rejected rather than
+// cropped or stripped of their sign.
+func BigToAddressChecked(b *big.Int) (Address, error) {
+	if b.Sign() < 0 || b.BitLen() > 8*AddressLength {
+		return Address{}, fmt.Errorf("%w: %v", ErrAddressRange, b)
+	}
+	return BigToAddress(b), nil
+}
+
 // HexToAddress returns Address with byte values of s.
 // If s is larger than len(h), s will be cropped from the left.
 func HexToAddress(s string) Address { return BytesToAddress(FromHex(s)) }
 
+// HexToAddressStrict is HexToAddress for input which must be exactly
+// AddressLength bytes of valid hex. Unlike HexToAddress, other input is
+// rejected rather than cropped or padded.
+func HexToAddressStrict(s string) (Address, error) {
+	b, err := fromHexStrict(s, AddressLength)
+	if err != nil {
+		return Address{}, err
+	}
+	return BytesToAddress(b), nil
+}
+
 // IsHexAddress verifies whether a string can represent a valid hex-encoded
 // Ethereum address or not.
 func IsHexAddress(s string) bool {
@@ -264,6 +770,20 @@ func IsHexAddress(s string) bool {
 // Bytes gets the string representation of the underlying address.
 func (a Address) Bytes() []byte { return a[:] }
 
+// Big converts an address to a big integer.
+func (a Address) Big() *big.Int { return new(big.Int).SetBytes(a[:]) }
+
+// IsZero reports whether a is the zero address.
+func (a Address) IsZero() bool { return a == ZeroAddr }
+
+// EqualConstantTime reports whether a and b are equal in time independent of
+// their contents. The == operator is fine for comparing public addresses; this
+// is meant for comparisons whose outcome must not leak through timing, e.g.
+// checking a submitted address against a secret authorized one.
+func (a Address) EqualConstantTime(b Address) bool {
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
 // Hash converts an address to a hash by left-padding it with zeros.
 func (a Address) Hash() Hash { return BytesToHash(a[:]) }
 
@@ -347,14 +867,63 @@ func (a Address) MarshalText() ([]byte, error) {
 	return hexutil.Bytes(a[:]).MarshalText()
 }
 
+// addressJSONLength is the length of an address encoded as a JSON string.
+const addressJSONLength = 2*AddressLength + 4
+
+// addressJSONBatch is the number of addresses MarshalAddresses encodes before
+// writing them out.
+const addressJSONBatch = 64
+
+// MarshalAddresses writes addrs to w as a JSON array of hex addresses. The
+// output is identical to json.Marshal(addrs), including "null" for a nil
+// slice, but the array is streamed to w in small batches instead of being
+// built in memory first, which keeps large RPC responses cheap.
+func MarshalAddresses(w io.Writer, addrs []Address) error {
+	if addrs == nil {
+		_, err := io.WriteString(w, "null")
+		return err
+	}
+	buf := make([]byte, 0, 1+addressJSONBatch*(addressJSONLength+1)+1)
+	buf = append(buf, '[')
+	for i := range addrs {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, '"', '0', 'x')
+		n := len(buf)
+		buf = buf[:n+2*AddressLength]
+		hex.Encode(buf[n:], addrs[i][:])
+		buf = append(buf, '"')
+
+		if (i+1)%addressJSONBatch == 0 {
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	buf = append(buf, ']')
+	_, err := w.Write(buf)
+	return err
+}
+
 // UnmarshalText parses a hash in hex syntax.
 func (a *Address) UnmarshalText(input []byte) error {
 	return hexutil.UnmarshalFixedText("Address", input, a[:])
 }
 
-// UnmarshalJSON parses a hash in hex syntax.
+// UnmarshalJSON parses an address in hex syntax. Following the encoding/json
+// convention for optional fields, a JSON null leaves the address unchanged. An
+// empty string is rejected, as is any string which is not exactly the 0x
+// prefixed hex of an address, see unmarshalStrictHexJSON.
 func (a *Address) UnmarshalJSON(input []byte) error {
-	return hexutil.UnmarshalFixedJSON(addressT, input, a[:])
+	if isNullJSON(input) {
+		return nil
+	}
+	if isEmptyJSONString(input) {
+		return errEmptyJSONString(addressT)
+	}
+	return unmarshalStrictHexJSON(addressT, input, a[:])
 }
 
 // Scan implements Scanner for database/sql.
@@ -377,53 +946,119 @@ func (a Address) Value() (driver.Value, error) {
 
 // IsInChainScope checks if an address is a valid account in our node's sharded address space
 func (a Address) IsInChainScope() bool {
-	if a == ZeroAddr {
+	if a.IsZero() {
 		return true
 	}
-	return NodeLocation.ContainsAddress(a)
+	return GetNodeLocation().ContainsAddress(a)
 }
 
-// Location looks up the chain location which contains this address
+// isContractEncoding reports whether an address within a location's range
+// encodes a contract. Quai addresses currently only encode their location, in
+// the leading byte, and contracts and externally owned accounts share each
+// location's range, so no address is classified as a contract. A future
+// encoding rule, e.g. a reserved bit of the leading byte, plugs in here.
+var isContractEncoding = func(a Address) bool { return false }
+
+// IsContractAddress reports whether the address is classified as a contract
+// by its encoding alone. The zero address and addresses outside every
+// location's range are neither contracts nor externally owned accounts.
+func (a Address) IsContractAddress() bool {
+	return !a.IsZero() && a.Location() != nil && isContractEncoding(a)
+}
+
+// IsEOA reports whether the address is classified as an externally owned
+// account by its encoding alone. See IsContractAddress.
+func (a Address) IsEOA() bool {
+	return !a.IsZero() && a.Location() != nil && !isContractEncoding(a)
+}
+
+// Location looks up the chain location which contains this address. The
+// chain is determined by the leading byte alone, so the lookup is a single
+// table access. The returned location is a copy the caller may modify.
 func (a Address) Location() *Location {
-	R, Z, D := 0, 0, HierarchyDepth
-	if NodeLocation.HasRegion() {
-		R = NodeLocation.Region()
-	}
-	if NodeLocation.HasZone() {
-		Z = NodeLocation.Zone()
-	}
-
-	// Search zone->region->prime address spaces in-slice first, and then search
-	// zone->region out-of-slice address spaces next. This minimizes expected
-	// search time under the following assumptions:
-	// * a node is more likely to encounter a TX from its slice than from another
-	// * we expect `>= Z` `zone` TXs for every `region` TX
-	// * we expect `>= R` `region` TXs for every `prime` TX
-	// * (and by extension) we expect `>= R*Z` `zone` TXs for every `prime` TX
-	primeChecked := false
-	for r := 0; r < NumRegionsInPrime; r++ {
-		for z := 0; z < NumZonesInRegion; z++ {
-			l := Location{byte((r+R)%D), byte((z+Z)%D)}
-			if l.ContainsAddress(a) {
-				return &l
-			}
-		}
-		l := Location{byte((r+R)%D)}
-		if l.ContainsAddress(a) {
-			return &l
-		}
-		// Check prime on first pass through slice, but not again
-		if !primeChecked {
-			primeChecked = true
-			l := Location{}
-			if l.ContainsAddress(a) {
-				return &l
-			}
+	loc, ok := LocationForAddressPrefix(a[0])
+	if !ok {
+		return nil
+	}
+	return &loc
+}
+
+// UnknownLocationBucket is the BucketAddressesByLocation key of addresses
+// outside every location's address space.
+const UnknownLocationBucket = "unknown"
+
+// BucketAddressesByLocation partitions addrs by the location owning them, keyed
+// by location name. Addresses outside every location's address space are kept
+// under UnknownLocationBucket. The order of addrs is preserved within buckets.
+func BucketAddressesByLocation(addrs []Address) map[string][]Address {
+	buckets := make(map[string][]Address)
+	for _, a := range addrs {
+		name := UnknownLocationBucket
+		if prefixNames := getHierarchy().prefixNames; int(a[0]) < len(prefixNames) {
+			name = prefixNames[a[0]]
 		}
+		buckets[name] = append(buckets[name], a)
 	}
-	return nil
+	return buckets
 }
 
+// ErrUnroutableAddress is returned for addresses outside every location's
+// address space.
+var ErrUnroutableAddress = errors.New("address is not in any location")
+
+// TxPartitionKey returns the key of the slice a transaction to dest should be
+// queued under, i.e. the SliceKey of the location owning dest. Transactions to
+// addresses outside of every location's address space cannot be routed and
+// return ErrUnroutableAddress.
+func TxPartitionKey(dest Address) (string, error) {
+	loc := dest.Location()
+	if loc == nil {
+		return "", fmt.Errorf("%w: %x", ErrUnroutableAddress, dest)
+	}
+	return loc.SliceKey(), nil
+}
+
+// DebugString returns the checksummed address prefixed with the name of the
+// location owning it, e.g. "cyprus2:0x1E...", making state dumps
+// self-describing. Addresses outside every location are prefixed with
+// "unknown".
+func (a Address) DebugString() string {
+	name := "unknown"
+	if loc := a.Location(); loc != nil {
+		name = loc.Name()
+	}
+	return name + ":" + a.Hex()
+}
+
+// ParseDebugAddress parses an address in the format produced by DebugString,
+// verifying that the address actually belongs to the named location.
+func ParseDebugAddress(s string) (Address, error) {
+	colon := strings.IndexByte(s, ':')
+	if colon < 0 {
+		return Address{}, fmt.Errorf("debug address %q: missing location", s)
+	}
+	name, hex := s[:colon], s[colon+1:]
+	loc, err := ParseLocation(name)
+	if err != nil {
+		return Address{}, fmt.Errorf("debug address %q: %v", s, err)
+	}
+	if !IsHexAddress(hex) {
+		return Address{}, fmt.Errorf("debug address %q: invalid address", s)
+	}
+	addr := HexToAddress(hex)
+	if !loc.ContainsAddress(addr) {
+		return Address{}, fmt.Errorf("debug address %q: address not in %s", s, name)
+	}
+	return addr, nil
+}
+
+// Addresses is a slice of addresses which sorts in ascending byte order.
+type Addresses []Address
+
+func (a Addresses) Len() int           { return len(a) }
+func (a Addresses) Less(i, j int) bool { return bytes.Compare(a[i][:], a[j][:]) < 0 }
+func (a Addresses) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
 // UnprefixedAddress allows marshaling an Address without 0x prefix.
 type UnprefixedAddress Address
 
@@ -487,11 +1122,26 @@ func (ma *MixedcaseAddress) String() string {
 	return fmt.Sprintf("%s [chksum INVALID]", ma.original)
 }
 
-// ValidChecksum returns true if the address has valid checksum
+// ValidChecksum returns true if the address has valid checksum. All-lowercase
+// and all-uppercase addresses carry no checksum and are considered valid, use
+// HasChecksum to tell them apart from checksummed ones.
 func (ma *MixedcaseAddress) ValidChecksum() bool {
+	if !ma.HasChecksum() {
+		return true
+	}
 	return ma.original == ma.addr.Hex()
 }
 
+// HasChecksum returns true if the original address is mixed-case, i.e. if it
+// carries EIP-55 checksum information at all.
+func (ma *MixedcaseAddress) HasChecksum() bool {
+	hex := ma.original
+	if has0xPrefix(hex) {
+		hex = hex[2:]
+	}
+	return strings.ToLower(hex) != hex && strings.ToUpper(hex) != hex
+}
+
 // Original returns the mixed-case input string
 func (ma *MixedcaseAddress) Original() string {
 	return ma.original
@@ -529,20 +1179,69 @@ func (loc Location) HasZone() bool {
 	return loc.Zone() >= 0
 }
 
-func (loc Location) AssertValid() {
+// ErrInvalidLocation is returned for locations which do not name a chain in
+// the hierarchy.
+var ErrInvalidLocation = errors.New("invalid location")
+
+// NewLocation creates the location with the given region and zone indices,
+// returning an error wrapping ErrInvalidLocation if they do not name a chain
+// in the hierarchy.
+func NewLocation(indices ...byte) (Location, error) {
+	loc := Location(indices)
+	if err := loc.Validate(); err != nil {
+		return nil, err
+	}
+	return loc, nil
+}
+
+// Validate returns an error wrapping ErrInvalidLocation if the location does
+// not name an existing chain in the hierarchy. Locations received from peers
+// or RPC callers should be validated before use, since AssertValid exits.
+func (loc Location) Validate() error {
+	params := GetHierarchyParams()
+	if len(loc) >= params.Depth {
+		return fmt.Errorf("%w: %v is deeper than the hierarchy", ErrInvalidLocation, []byte(loc))
+	}
 	if !loc.HasRegion() && loc.HasZone() {
-		log.Fatal("cannot specify zone without also specifying region.")
+		return fmt.Errorf("%w: cannot specify zone without also specifying region", ErrInvalidLocation)
 	}
-	if loc.Region() >= NumRegionsInPrime {
-		log.Fatal("region index is not valid.")
+	if loc.Region() >= params.RegionsInPrime {
+		return fmt.Errorf("%w: region index %d is not valid", ErrInvalidLocation, loc.Region())
 	}
-	if loc.Zone() >= NumZonesInRegion {
-		log.Fatal("zone index is not valid.")
+	if loc.Zone() >= params.ZonesInRegion {
+		return fmt.Errorf("%w: zone index %d is not valid", ErrInvalidLocation, loc.Zone())
+	}
+	return nil
+}
+
+// valid reports whether the location names an existing chain in the hierarchy.
+func (loc Location) valid() bool {
+	return loc.Validate() == nil
+}
+
+// AssertValid exits if the location does not name a chain in the hierarchy.
+func (loc Location) AssertValid() {
+	if err := loc.Validate(); err != nil {
+		log.Fatal(err)
 	}
 }
 
 func (loc Location) Context() int {
 	loc.AssertValid()
+	return loc.context()
+}
+
+// ContextErr is Context for untrusted locations, returning an error instead of
+// exiting if the location is invalid.
+func (loc Location) ContextErr() (int, error) {
+	if err := loc.Validate(); err != nil {
+		return 0, err
+	}
+	return loc.context(), nil
+}
+
+// context returns the context of a valid location.
+func (loc Location) context() int {
 	if loc.Zone() >= 0 {
 		return ZONE_CTX
 	} else if loc.Region() >= 0 {
@@ -561,9 +1260,70 @@ func (loc Location) DomLocation() Location {
 	}
 }
 
+// Children returns the locations of the immediate subordinate chains, in index
+// order: the regions for prime, the zones for a region and none for a zone or
+// an invalid location.
+func (loc Location) Children() []Location {
+	params := GetHierarchyParams()
+	ctx, err := loc.ContextErr()
+	if err != nil || ctx+1 >= params.Depth {
+		return nil
+	}
+	count := params.RegionsInPrime
+	if ctx == REGION_CTX {
+		count = params.ZonesInRegion
+	}
+	children := make([]Location, count)
+	for i := range children {
+		child := make(Location, len(loc)+1)
+		copy(child, loc)
+		child[len(loc)] = byte(i)
+		children[i] = child
+	}
+	return children
+}
+
+// Siblings returns the locations of the other chains sharing loc's dominant
+// chain, in index order: the other regions for a region and the other zones of
+// its region for a zone. Prime and invalid locations have no siblings.
+func (loc Location) Siblings() []Location {
+	if !loc.valid() || len(loc) == 0 {
+		return nil
+	}
+	var siblings []Location
+	for _, sibling := range loc.DomLocation().Children() {
+		if !sibling.Equal(loc) {
+			siblings = append(siblings, sibling)
+		}
+	}
+	return siblings
+}
+
+// Descendants returns the locations of every chain below loc in the hierarchy,
+// each followed by its own descendants.
+func (loc Location) Descendants() []Location {
+	var descendants []Location
+	for _, child := range loc.Children() {
+		descendants = append(descendants, child)
+		descendants = append(descendants, child.Descendants()...)
+	}
+	return descendants
+}
+
+// WalkUp calls fn with the location itself and then with each of its dominant
+// locations in turn, ending with prime, until fn returns false.
+func (loc Location) WalkUp(fn func(Location) bool) {
+	for {
+		if !fn(loc) || len(loc) == 0 {
+			return
+		}
+		loc = loc.DomLocation()
+	}
+}
+
 // SubIndex returns the index of the subordinate chain for a given location
 func (loc Location) SubIndex() int {
-	switch NodeLocation.Context() {
+	switch GetNodeLocation().Context() {
 	case PRIME_CTX:
 		return loc.Region()
 	case REGION_CTX:
@@ -590,42 +1350,212 @@ func (loc Location) SubInSlice(slice Location) Location {
 	return subLoc
 }
 
+// InSameSliceAs reports whether loc and cmp lie within the same vertical
+// slice, i.e. whether the shallower of the two is a prefix of the deeper one.
+// It does not allocate.
 func (loc Location) InSameSliceAs(cmp Location) bool {
-	// Figure out which location is shorter
-	shorter := loc
-	longer := cmp
-	if len(loc) > len(cmp) {
-		longer = loc
-		shorter = cmp
+	n := len(loc)
+	if len(cmp) < n {
+		n = len(cmp)
 	}
 	// Compare bytes up to the shorter depth
-	return shorter.Equal(longer[:len(shorter)])
+	for i := 0; i < n; i++ {
+		if loc[i] != cmp[i] {
+			return false
+		}
+	}
+	return true
 }
 
-func (loc Location) Name() string {
-	regionName := ""
-	switch loc.Region() {
-	case 0:
-		regionName = "cyprus"
-	case 1:
-		regionName = "paxos"
-	case 2:
-		regionName = "hydra"
-	default:
-		regionName = "unknownregion"
+// GroupBySlice buckets locs by their top-level region, keyed by the region
+// index in decimal. Prime locations, which belong to no single region, are
+// kept under "prime". Any two locations in the same bucket are InSameSliceAs
+// each other's region. The order of locs is preserved within buckets.
+func GroupBySlice(locs []Location) map[string][]Location {
+	groups := make(map[string][]Location)
+	for _, loc := range locs {
+		key := "prime"
+		if len(loc) > 0 {
+			key = strconv.Itoa(int(loc[0]))
+		}
+		groups[key] = append(groups[key], loc)
 	}
-	zoneNum := strconv.Itoa(loc.Zone()+1)
-	switch loc.Context() {
-	case PRIME_CTX:
+	return groups
+}
+
+// IsDominantOf reports whether loc is a proper ancestor of cmp in the
+// hierarchy, i.e. cmp lies within loc's slice and deeper than loc. Prime is
+// dominant of every other location, and no location is dominant of itself.
+func (loc Location) IsDominantOf(cmp Location) bool {
+	return len(loc) < len(cmp) && loc.Equal(cmp[:len(loc)])
+}
+
+// IsSubordinateOf reports whether loc is a proper descendant of cmp in the
+// hierarchy. It is the mirror of IsDominantOf.
+func (loc Location) IsSubordinateOf(cmp Location) bool {
+	return cmp.IsDominantOf(loc)
+}
+
+// SliceKey returns a stable key identifying the vertical slice a location
+// belongs to, joining the location's indices with '-'. Every block originating
+// in the cyprus2 zone maps to "0-1", whichever context it is coincident with,
+// while a location naming only the cyprus region maps to "0". Prime, which
+// spans all slices, maps to "prime".
+func (loc Location) SliceKey() string {
+	if len(loc) == 0 {
 		return "prime"
-	case REGION_CTX:
-		return regionName
-	case ZONE_CTX:
-		return regionName + zoneNum
-	default:
-		log.Println("cannot name invalid location")
-		return "invalid-location"
 	}
+	indices := make([]string, len(loc))
+	for i, index := range loc {
+		indices[i] = strconv.Itoa(int(index))
+	}
+	return strings.Join(indices, "-")
+}
+
+// Path returns the location's indices joined with '/', e.g. "0/1" for the
+// cyprus2 zone, "0" for the cyprus region and "" for prime. Unlike Name, the
+// path is suited as a relative directory of per-chain data. ParsePath is its
+// inverse.
+func (loc Location) Path() string {
+	indices := make([]string, len(loc))
+	for i, index := range loc {
+		indices[i] = strconv.Itoa(int(index))
+	}
+	return strings.Join(indices, "/")
+}
+
+// ID returns a dense index of the location among all NumChains chains, or -1
+// if the location is invalid. Chains are numbered depth-first, like their
+// address prefix ranges: prime is 0, cyprus 1, cyprus1 to cyprus3 are 2 to 4,
+// paxos is 5, and so on up to hydra3 at 12.
+func (loc Location) ID() int {
+	if !loc.valid() {
+		return -1
+	}
+	id := 0
+	if loc.HasRegion() {
+		// Each region is followed by its zones, if the hierarchy has any
+		stride := 1
+		if params := GetHierarchyParams(); params.Depth > ZONE_CTX {
+			stride += params.ZonesInRegion
+		}
+		id += 1 + loc.Region()*stride
+	}
+	if loc.HasZone() {
+		id += 1 + loc.Zone()
+	}
+	return id
+}
+
+// LocationFromID returns the location with the given ID, if any.
+func LocationFromID(id int) (Location, bool) {
+	return GetHierarchyParams().locationFromID(id)
+}
+
+// AllLocations returns every chain of the hierarchy in depth-first order, i.e.
+// ordered by ID: prime, then each region followed by its zones.
+func AllLocations() []Location {
+	params := GetHierarchyParams()
+	locs := make([]Location, params.NumChains())
+	for id := range locs {
+		locs[id], _ = params.locationFromID(id)
+	}
+	return locs
+}
+
+// AllLocationNames returns the names of the chains returned by AllLocations,
+// in the same order.
+func AllLocationNames() []string {
+	locs := AllLocations()
+	names := make([]string, len(locs))
+	for i, loc := range locs {
+		names[i] = loc.Name()
+	}
+	return names
+}
+
+// LocationsToBitmask encodes a set of chains as a bitmask, with bit i set for
+// the chain with ID i. Invalid locations are ignored.
+func LocationsToBitmask(locs []Location) uint16 {
+	var mask uint16
+	for _, loc := range locs {
+		if id := loc.ID(); id >= 0 {
+			mask |= 1 << uint(id)
+		}
+	}
+	return mask
+}
+
+// BitmaskToLocations decodes a bitmask built by LocationsToBitmask into its
+// chains, ordered by ID. Bits not naming a chain are ignored.
+func BitmaskToLocations(mask uint16) []Location {
+	var locs []Location
+	for id := 0; id < GetHierarchyParams().NumChains(); id++ {
+		if mask&(1<<uint(id)) != 0 {
+			loc, _ := LocationFromID(id)
+			locs = append(locs, loc)
+		}
+	}
+	return locs
+}
+
+// eventSequenceBits is the number of low bits of an event sequence number
+// holding the per-chain sequence.
+const eventSequenceBits = 48
+
+// EventSequenceBase returns the offset to add to the chain's own event
+// sequence numbers when merging event streams of several chains.
+//
+// A merged sequence number holds the chain's ID in its upper 16 bits and the
+// chain-local sequence in the lower 48 bits. Sequence numbers of different
+// chains never collide as long as no chain exceeds 2^48 events, and within a
+// chain they keep their order. An error is returned if the location does not
+// name a chain in the hierarchy.
+func (loc Location) EventSequenceBase() (uint64, error) {
+	if err := loc.Validate(); err != nil {
+		return 0, fmt.Errorf("event sequence base: %w", err)
+	}
+	return uint64(loc.ID()) << eventSequenceBits, nil
+}
+
+// DecodeEventSequence splits a merged event sequence number, as built from
+// EventSequenceBase, into the location of the chain and its local sequence.
+func DecodeEventSequence(seq uint64) (Location, uint64, error) {
+	loc, ok := LocationFromID(int(seq >> eventSequenceBits))
+	if !ok {
+		return nil, 0, fmt.Errorf("event sequence %#x: unknown chain id %d", seq, seq>>eventSequenceBits)
+	}
+	return loc, seq & (1<<eventSequenceBits - 1), nil
+}
+
+// Name returns the name of the location, e.g. "prime", "cyprus" or "cyprus2".
+// It exits if the location does not name a chain in the hierarchy.
+func (loc Location) Name() string {
+	loc.AssertValid()
+	return locationName(loc)
+}
+
+// Abbrev returns a short label for the location, e.g. "pr" for prime, "cy"
+// for cyprus and "c2" for cyprus2, for use where labels must be terse, like
+// metrics. It exits if the location does not name a chain in the hierarchy.
+func (loc Location) Abbrev() string {
+	loc.AssertValid()
+	return locationAbbrev(loc)
+}
+
+// gossipTopicPrefix leads every gossip topic, see Location.GossipTopic.
+const gossipTopicPrefix = "quai"
+
+// ErrInvalidGossipTopic is returned by ParseGossipTopic for topics which were
+// not produced by Location.GossipTopic.
+var ErrInvalidGossipTopic = errors.New("invalid gossip topic")
+
+// GossipTopic returns the name of the pubsub topic the chain at loc gossips
+// messages of the given kind on, e.g. "quai/cyprus2/blocks". The kind must be
+// non-empty and must not contain '/' for ParseGossipTopic to recover it. It
+// exits if the location does not name a chain in the hierarchy.
+func (loc Location) GossipTopic(kind string) string {
+	return gossipTopicPrefix + "/" + loc.Name() + "/" + kind
 }
 
 func (loc Location) Equal(cmp Location) bool {
@@ -651,14 +1581,215 @@ func (loc Location) CommonDom(cmp Location) Location {
 	return common
 }
 
+// RouteTo returns the chains a message from loc to dst traverses, in order:
+// up from loc to the CommonDom of both locations, then down to dst. Both ends
+// are included, so a route within a single chain holds just that chain.
+// * zone-0-0 to zone-0-1 routes via region-0
+// * zone-0-0 to zone-1-0 routes via region-0, prime and region-1
+func (loc Location) RouteTo(dst Location) []Location {
+	dom := loc.CommonDom(dst)
+	route := make([]Location, 0, len(loc)+len(dst)-2*len(dom)+1)
+	for depth := len(loc); depth > len(dom); depth-- {
+		route = append(route, append(Location{}, loc[:depth]...))
+	}
+	for depth := len(dom); depth <= len(dst); depth++ {
+		route = append(route, append(Location{}, dst[:depth]...))
+	}
+	return route
+}
+
+// PrefixRangeForLocation returns the inclusive range of leading address bytes
+// owned by the location, if the location is valid.
+func PrefixRangeForLocation(loc Location) (lo, hi uint8, ok bool) {
+	if !loc.valid() {
+		return 0, 0, false
+	}
+	prefixRange, ok := getHierarchy().prefixRanges[loc.Name()]
+	return prefixRange.lo, prefixRange.hi, ok
+}
+
+// LocationForAddressPrefix returns the location owning addresses starting
+// with the given byte, if any. The location is a copy of the lookup table's
+// entry, so modifying it does not affect other lookups.
+func LocationForAddressPrefix(prefix uint8) (Location, bool) {
+	prefixLocations := getHierarchy().prefixLocations
+	if int(prefix) >= len(prefixLocations) {
+		return nil, false
+	}
+	return append(Location{}, prefixLocations[prefix]...), true
+}
+
+// InLocation returns the address with the same body as a in the location loc,
+// i.e. with bytes 1 to 19 kept and the leading byte rewritten to the low end of
+// loc's prefix range. An error wrapping ErrInvalidLocation is returned if loc
+// does not name a chain.
+func (a Address) InLocation(loc Location) (Address, error) {
+	lo, _, ok := PrefixRangeForLocation(loc)
+	if !ok {
+		return Address{}, fmt.Errorf("%w: no address prefix range for %v", ErrInvalidLocation, []byte(loc))
+	}
+	a[0] = lo
+	return a, nil
+}
+
+// RandomAddressInLocation returns a random address owned by loc, drawing its
+// leading byte from the location's prefix range and the remaining bytes from
+// rng. The same rng seed always yields the same address, which makes it suited
+// for reproducible test fixtures. An error wrapping ErrInvalidLocation is
+// returned if loc does not name a chain.
+func RandomAddressInLocation(loc Location, rng *rand.Rand) (Address, error) {
+	lo, hi, ok := PrefixRangeForLocation(loc)
+	if !ok {
+		return Address{}, fmt.Errorf("%w: no address prefix range for %v", ErrInvalidLocation, []byte(loc))
+	}
+	var a Address
+	rng.Read(a[1:])
+	a[0] = lo + byte(rng.Intn(int(hi-lo)+1))
+	return a, nil
+}
+
+// DeterministicAddress returns the address named by seed: the low 20 bytes of
+// the Keccak-256 hash of the seed. The same seed always yields the same
+// address, which makes it suited for reproducible test fixtures keyed by a
+// logical name.
+func DeterministicAddress(seed string) Address {
+	sha := sha3.NewLegacyKeccak256()
+	sha.Write([]byte(seed))
+	return BytesToAddress(sha.Sum(nil))
+}
+
+// DeterministicAddressInLocation is DeterministicAddress constrained to loc.
+// Bytes 1 to 19 are those of DeterministicAddress(seed) and the leading byte
+// is mapped into loc's prefix range, so the same seed and hierarchy always
+// yield the same address. An error wrapping ErrInvalidLocation is returned if
+// loc does not name a chain.
+func DeterministicAddressInLocation(seed string, loc Location) (Address, error) {
+	lo, hi, ok := PrefixRangeForLocation(loc)
+	if !ok {
+		return Address{}, fmt.Errorf("%w: no address prefix range for %v", ErrInvalidLocation, []byte(loc))
+	}
+	a := DeterministicAddress(seed)
+	a[0] = lo + a[0]%(hi-lo+1)
+	return a, nil
+}
+
 func (l Location) ContainsAddress(a Address) bool {
+	contains, err := l.ContainsAddressErr(a)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return contains
+}
+
+// ContainsAddressErr is ContainsAddress for untrusted locations, returning an
+// error instead of exiting if the location has no address range.
+func (l Location) ContainsAddressErr(a Address) (bool, error) {
+	if err := l.Validate(); err != nil {
+		return false, err
+	}
 	prefix := a[0]
-	prefixRange, ok := locationToPrefixRange[l.Name()]
+	prefixRange, ok := getHierarchy().prefixRanges[l.Name()]
 	if !ok {
-		log.Fatal("unable to get address prefix range for location")
+		return false, fmt.Errorf("%w: no address prefix range for %s", ErrInvalidLocation, l.Name())
 	}
 	// Ranges are fully inclusive
-	return uint8(prefix) >= prefixRange.lo && uint8(prefix) <= prefixRange.hi
+	return uint8(prefix) >= prefixRange.lo && uint8(prefix) <= prefixRange.hi, nil
+}
+
+// ParseLocation returns the location with the given name, as produced by
+// Location.Name, e.g. "prime", "cyprus" or "cyprus2". Surrounding whitespace
+// and case are ignored.
+func ParseLocation(name string) (Location, error) {
+	normalized := strings.ToLower(strings.TrimSpace(name))
+	for _, loc := range AllLocations() {
+		if loc.Name() == normalized {
+			return loc, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown location name %q", name)
+}
+
+// ParseAbbrev returns the location with the given abbreviation, as produced by
+// Location.Abbrev. Surrounding whitespace and case are ignored. Unknown
+// abbreviations yield an error wrapping ErrInvalidLocation.
+func ParseAbbrev(s string) (Location, error) {
+	normalized := strings.ToLower(strings.TrimSpace(s))
+	for _, loc := range AllLocations() {
+		if locationAbbrev(loc) == normalized {
+			return loc, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown abbreviation %q", ErrInvalidLocation, s)
+}
+
+// ParseGossipTopic returns the location and message kind of a topic produced
+// by Location.GossipTopic. Topics are matched exactly, so any other prefix, an
+// unknown or non-canonical location name, or an empty kind yields an error
+// wrapping ErrInvalidGossipTopic.
+func ParseGossipTopic(t string) (Location, string, error) {
+	parts := strings.Split(t, "/")
+	if len(parts) != 3 || parts[0] != gossipTopicPrefix {
+		return nil, "", fmt.Errorf("%w: %q is not of the form %s/<location>/<kind>", ErrInvalidGossipTopic, t, gossipTopicPrefix)
+	}
+	if parts[2] == "" {
+		return nil, "", fmt.Errorf("%w: %q has no kind", ErrInvalidGossipTopic, t)
+	}
+	for _, loc := range AllLocations() {
+		if locationName(loc) == parts[1] {
+			return loc, parts[2], nil
+		}
+	}
+	return nil, "", fmt.Errorf("%w: unknown location %q in %q", ErrInvalidGossipTopic, parts[1], t)
+}
+
+// ParsePath parses a location from its Path form. Components must be decimal
+// indices without leading zeros, and the resulting location must name a chain
+// in the hierarchy, otherwise an error wrapping ErrInvalidLocation is returned.
+func ParsePath(s string) (Location, error) {
+	if s == "" {
+		return Location{}, nil
+	}
+	components := strings.Split(s, "/")
+	indices := make([]byte, len(components))
+	for i, c := range components {
+		index, err := strconv.ParseUint(c, 10, 8)
+		if err != nil || strconv.FormatUint(index, 10) != c {
+			return nil, fmt.Errorf("%w: invalid path component %q in %q", ErrInvalidLocation, c, s)
+		}
+		indices[i] = byte(index)
+	}
+	return NewLocation(indices...)
+}
+
+// MarshalLocations encodes a list of locations as a JSON array of their names,
+// so that prime is rendered as "prime" rather than an ambiguous empty path.
+func MarshalLocations(locs []Location) ([]byte, error) {
+	names := make([]string, len(locs))
+	for i, loc := range locs {
+		if !loc.valid() {
+			return nil, fmt.Errorf("location %d: invalid location %v", i, []byte(loc))
+		}
+		names[i] = loc.Name()
+	}
+	return json.Marshal(names)
+}
+
+// UnmarshalLocations decodes a JSON array of location names, as produced by
+// MarshalLocations.
+func UnmarshalLocations(input []byte) ([]Location, error) {
+	var names []string
+	if err := json.Unmarshal(input, &names); err != nil {
+		return nil, err
+	}
+	locs := make([]Location, len(names))
+	for i, name := range names {
+		loc, err := ParseLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("location %d: %v", i, err)
+		}
+		locs[i] = loc
+	}
+	return locs, nil
 }
 
 func (l Location) RPCMarshal() []hexutil.Uint64 {
@@ -669,3 +1800,232 @@ func (l Location) RPCMarshal() []hexutil.Uint64 {
 
 	return res
 }
+
+// EncodeRLP implements rlp.Encoder, encoding the location as the raw byte
+// string of its path.
+func (l Location) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, []byte(l))
+}
+
+// DecodeRLP implements rlp.Decoder. Paths which do not name a chain in the
+// hierarchy are rejected with an error wrapping ErrInvalidLocation.
+func (l *Location) DecodeRLP(s *rlp.Stream) error {
+	b, err := s.Bytes()
+	if err != nil {
+		return err
+	}
+	loc := Location(b)
+	if err := loc.Validate(); err != nil {
+		return err
+	}
+	*l = loc
+	return nil
+}
+
+// KeyBytes returns the location as a compact storage key: its depth followed
+// by its indices. Keys order by depth first, so prime sorts before every
+// region and regions before every zone, and then by index, keeping siblings
+// adjacent. It is also the encoding of MarshalBinary.
+func (l Location) KeyBytes() []byte {
+	key := make([]byte, 1+len(l))
+	key[0] = byte(len(l))
+	copy(key[1:], l)
+	return key
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, see KeyBytes.
+func (l Location) MarshalBinary() ([]byte, error) {
+	return l.KeyBytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, decoding the form
+// produced by KeyBytes. Keys not naming a chain in the hierarchy are rejected
+// with an error wrapping ErrInvalidLocation.
+func (l *Location) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || int(data[0]) != len(data)-1 {
+		return fmt.Errorf("%w: malformed binary location %x", ErrInvalidLocation, data)
+	}
+	loc := append(Location{}, data[1:]...)
+	if err := loc.Validate(); err != nil {
+		return err
+	}
+	*l = loc
+	return nil
+}
+
+// NamedLocation is a Location encoded in JSON as an object holding both its
+// name and its path, e.g. {"name":"cyprus2","path":[0,1]}, for tooling, logs
+// and debug dumps. Location itself keeps its JSON wire format, a base64 string,
+// which headers and other consensus messages are exchanged in.
+type NamedLocation Location
+
+// namedLocationJSON is the JSON object encoding of a NamedLocation.
+type namedLocationJSON struct {
+	Name string `json:"name,omitempty"`
+	Path []int  `json:"path"`
+}
+
+// MarshalJSON encodes the location as an object holding both its name and its
+// path. Invalid locations have no name.
+func (l NamedLocation) MarshalJSON() ([]byte, error) {
+	loc := Location(l)
+	enc := namedLocationJSON{Path: make([]int, len(loc))}
+	for i, index := range loc {
+		enc.Path[i] = int(index)
+	}
+	if loc.valid() {
+		enc.Name = loc.Name()
+	}
+	return json.Marshal(enc)
+}
+
+// UnmarshalJSON decodes a location from the object encoding of MarshalJSON, a
+// bare array of indices as either integers or hex quantities as produced by
+// RPCMarshal, or the base64 string encoding of Location. If an object gives
+// both name and path they must agree. Locations not naming a chain in the
+// hierarchy are rejected with an error wrapping ErrInvalidLocation.
+func (l *NamedLocation) UnmarshalJSON(input []byte) error {
+	input = bytes.TrimSpace(input)
+	if len(input) == 0 || bytes.Equal(input, []byte("null")) {
+		return nil
+	}
+	var loc Location
+	switch input[0] {
+	case '{':
+		var dec struct {
+			Name *string          `json:"name"`
+			Path *json.RawMessage `json:"path"`
+		}
+		if err := json.Unmarshal(input, &dec); err != nil {
+			return err
+		}
+		if dec.Path == nil && dec.Name == nil {
+			return errors.New("location object requires a name or a path")
+		}
+		if dec.Path != nil {
+			path, err := unmarshalJSONPath(*dec.Path)
+			if err != nil {
+				return err
+			}
+			loc = path
+		}
+		if dec.Name != nil {
+			named, err := ParseLocation(*dec.Name)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidLocation, err)
+			}
+			if dec.Path != nil && !named.Equal(loc) {
+				return fmt.Errorf("%w: name %q does not match path %v", ErrInvalidLocation, *dec.Name, []byte(loc))
+			}
+			loc = named
+		}
+	case '[':
+		path, err := unmarshalJSONPath(input)
+		if err != nil {
+			return err
+		}
+		loc = path
+	default:
+		var raw []byte
+		if err := json.Unmarshal(input, &raw); err != nil {
+			return err
+		}
+		loc = raw
+	}
+	if err := loc.Validate(); err != nil {
+		return err
+	}
+	*l = NamedLocation(loc)
+	return nil
+}
+
+// unmarshalJSONPath decodes a JSON array of location indices, each given as
+// either an integer or a hex quantity string. The result is not validated.
+func unmarshalJSONPath(input []byte) (Location, error) {
+	var indices []json.RawMessage
+	if err := json.Unmarshal(input, &indices); err != nil {
+		return nil, err
+	}
+	loc := make(Location, len(indices))
+	for i, raw := range indices {
+		var index uint64
+		if len(raw) > 0 && raw[0] == '"' {
+			var hex hexutil.Uint64
+			if err := hex.UnmarshalJSON(raw); err != nil {
+				return nil, fmt.Errorf("location index %d: %v", i, err)
+			}
+			index = uint64(hex)
+		} else if err := json.Unmarshal(raw, &index); err != nil {
+			return nil, fmt.Errorf("location index %d: %v", i, err)
+		}
+		if index > 0xff {
+			return nil, fmt.Errorf("%w: index %d: %d out of range", ErrInvalidLocation, i, index)
+		}
+		loc[i] = byte(index)
+	}
+	return loc, nil
+}
+
+// LocationJSONSchema returns a JSON Schema (draft-07) describing the accepted
+// RPC encodings of a location, as decoded by NamedLocation. The first is the
+// form produced by RPCMarshal: an array of at most HierarchyDepth-1 indices,
+// the first naming the region and the second the zone, each given as a JSON
+// integer or as a hex quantity string. The second is the object form produced
+// by NamedLocation, holding the name and the path of the location, and the
+// third the base64 string Location is encoded in. The bounds and names are
+// derived from the hierarchy in use.
+func LocationJSONSchema() string {
+	params := GetHierarchyParams()
+	index := func(description string, bound int) map[string]interface{} {
+		hexes := make([]string, bound)
+		for i := range hexes {
+			hexes[i] = hexutil.EncodeUint64(uint64(i))
+		}
+		return map[string]interface{}{
+			"description": description,
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "integer", "minimum": 0, "maximum": bound - 1},
+				map[string]interface{}{"type": "string", "enum": hexes},
+			},
+		}
+	}
+	path := map[string]interface{}{
+		"description":     "Path of a chain in the hierarchy: [] is prime, [region] a region and [region, zone] a zone",
+		"type":            "array",
+		"minItems":        0,
+		"maxItems":        params.Depth - 1,
+		"additionalItems": false,
+		"items": []interface{}{
+			index("region index", params.RegionsInPrime),
+			index("zone index within the region", params.ZonesInRegion),
+		}[:params.Depth-1],
+	}
+	named := map[string]interface{}{
+		"description": "Name and path of a chain in the hierarchy, which must agree if both are given",
+		"type":        "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{"type": "string", "enum": AllLocationNames()},
+			"path": path,
+		},
+		"anyOf": []interface{}{
+			map[string]interface{}{"required": []string{"name"}},
+			map[string]interface{}{"required": []string{"path"}},
+		},
+		"additionalProperties": false,
+	}
+	raw := map[string]interface{}{
+		"description":     "Base64 encoding of the path indices, one byte each",
+		"type":            "string",
+		"contentEncoding": "base64",
+	}
+	schema := map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"title":   "Location",
+		"oneOf":   []interface{}{path, named, raw},
+	}
+	enc, err := json.Marshal(schema)
+	if err != nil {
+		panic(err) // the schema is built from plain values only
+	}
+	return string(enc)
+}
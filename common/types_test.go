@@ -20,11 +20,19 @@ import (
 	"bytes"
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/big"
+	"math/rand"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+
+	"github.com/dominant-strategies/go-quai/rlp"
 )
 
 func TestBytesConversion(t *testing.T) {
@@ -63,6 +71,30 @@ func TestIsHexAddress(t *testing.T) {
 	}
 }
 
+func TestHexToStrict(t *testing.T) {
+	addr := "0x14a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3"
+	if have, err := HexToAddressStrict(addr); err != nil || have != HexToAddress(addr) {
+		t.Errorf("%s: have %x (%v), want %x", addr, have, err, HexToAddress(addr))
+	}
+	if have, err := HexToAddressStrict(strings.ToUpper(addr[2:])); err != nil || have != HexToAddress(addr) {
+		t.Errorf("%s: unprefixed uppercase: have %x (%v)", addr, have, err)
+	}
+	for _, bad := range []string{"", "0x", addr + "d4", addr[:len(addr)-2], addr[:len(addr)-1], "0x" + strings.Repeat("g", 40), addr[2:] + "0x"} {
+		if have, err := HexToAddressStrict(bad); !errors.Is(err, ErrInvalidHex) {
+			t.Errorf("address %q: have %x (%v), want %v", bad, have, err, ErrInvalidHex)
+		}
+	}
+	hash := "0x" + strings.Repeat("a1", HashLength)
+	if have, err := HexToHashStrict(hash); err != nil || have != HexToHash(hash) {
+		t.Errorf("%s: have %x (%v), want %x", hash, have, err, HexToHash(hash))
+	}
+	for _, bad := range []string{"", "0x01", hash + "00", hash[:len(hash)-2], "0x" + strings.Repeat("zz", HashLength)} {
+		if have, err := HexToHashStrict(bad); !errors.Is(err, ErrInvalidHex) {
+			t.Errorf("hash %q: have %x (%v), want %v", bad, have, err, ErrInvalidHex)
+		}
+	}
+}
+
 func TestHashJsonValidation(t *testing.T) {
 	var tests = []struct {
 		Prefix string
@@ -70,9 +102,9 @@ func TestHashJsonValidation(t *testing.T) {
 		Error  string
 	}{
 		{"", 62, "json: cannot unmarshal hex string without 0x prefix into Go value of type common.Hash"},
-		{"0x", 66, "hex string has length 66, want 64 for common.Hash"},
-		{"0x", 63, "json: cannot unmarshal hex string of odd length into Go value of type common.Hash"},
-		{"0x", 0, "hex string has length 0, want 64 for common.Hash"},
+		{"0x", 66, "wrong hex length for common.Hash: have 66 hex digits, want 64"},
+		{"0x", 63, "wrong hex length for common.Hash: have 63 hex digits, want 64"},
+		{"0x", 0, "wrong hex length for common.Hash: have 0 hex digits, want 64"},
 		{"0x", 64, ""},
 		{"0X", 64, ""},
 	}
@@ -123,6 +155,90 @@ func TestAddressUnmarshalJSON(t *testing.T) {
 	}
 }
 
+func TestStrictHexJSON(t *testing.T) {
+	hash := strings.Repeat("ab", HashLength)
+	addr := strings.Repeat("cd", AddressLength)
+	tests := []struct {
+		name    string
+		input   string
+		wantErr error // ErrHexLength, or nil for type errors
+	}{
+		{"short", `"0x` + hash[:62] + `"`, ErrHexLength},
+		{"odd", `"0x` + hash[:63] + `"`, ErrHexLength},
+		{"long", `"0x` + hash + `00"`, ErrHexLength},
+		{"address as hash", `"0x` + addr + `"`, ErrHexLength},
+		{"leading space", `" 0x` + hash + `"`, nil},
+		{"trailing space", `"0x` + hash + ` "`, nil},
+		{"trailing newline", `"0x` + hash + `\n"`, nil},
+		{"escaped tab", `"0x` + hash[:32] + `\t` + hash[32:] + `"`, nil},
+		{"escaped null", `"0x` + hash[:62] + `\u0000"`, nil},
+		{"non-ascii", `"0x` + hash[:62] + `é"`, nil},
+	}
+	for _, tt := range tests {
+		var h Hash
+		err := json.Unmarshal([]byte(tt.input), &h)
+		if err == nil {
+			t.Errorf("%s: no error, decoded %x", tt.name, h)
+			continue
+		}
+		if h != (Hash{}) {
+			t.Errorf("%s: hash modified to %x", tt.name, h)
+		}
+		var typeErr *json.UnmarshalTypeError
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: have %v, want %v", tt.name, err, tt.wantErr)
+		} else if tt.wantErr == nil && (!errors.As(err, &typeErr) || typeErr.Type != hashT) {
+			t.Errorf("%s: have %v, want type error for %v", tt.name, err, hashT)
+		}
+		if !strings.Contains(err.Error(), "common.Hash") {
+			t.Errorf("%s: error %q does not name the type", tt.name, err)
+		}
+	}
+	// Addresses are held to the same standard
+	for _, input := range []string{`"0x` + addr[:38] + `"`, `"0x` + addr + `00"`, `"0x` + hash + `"`, `"0x` + addr[:38] + `\u0000"`, `" 0x` + addr + `"`, `"0x` + addr + ` "`} {
+		var a Address
+		if err := json.Unmarshal([]byte(input), &a); err == nil || !strings.Contains(err.Error(), "common.Address") {
+			t.Errorf("%s: have %v, want error naming common.Address", input, err)
+		}
+	}
+	var a Address
+	if err := json.Unmarshal([]byte(`"0x`+addr+`"`), &a); err != nil || a != HexToAddress(addr) {
+		t.Errorf("valid address: have %x, %v", a, err)
+	}
+}
+
+func TestNullAndEmptyJSON(t *testing.T) {
+	var h Hash
+	if err := json.Unmarshal([]byte("null"), &h); err != nil || h != (Hash{}) {
+		t.Errorf("null hash: have %x, %v, want zero hash, nil", h, err)
+	}
+	var a Address
+	if err := json.Unmarshal([]byte("null"), &a); err != nil || a != (Address{}) {
+		t.Errorf("null address: have %x, %v, want zero address, nil", a, err)
+	}
+
+	wantHashErr := "json: cannot unmarshal empty string into Go value of type common.Hash"
+	if err := json.Unmarshal([]byte(`""`), &h); err == nil || err.Error() != wantHashErr {
+		t.Errorf("empty hash: error mismatch: have %v, want %q", err, wantHashErr)
+	}
+	wantAddrErr := "json: cannot unmarshal empty string into Go value of type common.Address"
+	if err := json.Unmarshal([]byte(`""`), &a); err == nil || err.Error() != wantAddrErr {
+		t.Errorf("empty address: error mismatch: have %v, want %q", err, wantAddrErr)
+	}
+
+	// Optional fields decode to the zero value when null or absent
+	var opt struct {
+		Hash *Hash `json:"hash"`
+		Addr Address
+	}
+	if err := json.Unmarshal([]byte(`{"hash":null,"Addr":null}`), &opt); err != nil {
+		t.Fatalf("optional fields: unexpected error: %v", err)
+	}
+	if opt.Hash != nil || opt.Addr != (Address{}) {
+		t.Errorf("optional fields: have %v/%x, want nil/zero", opt.Hash, opt.Addr)
+	}
+}
+
 func TestAddressHexChecksum(t *testing.T) {
 	var tests = []struct {
 		Input  string
@@ -197,6 +313,34 @@ func TestMixedcaseAccount_Address(t *testing.T) {
 
 }
 
+func TestMixedcaseAddressChecksum(t *testing.T) {
+	tests := []struct {
+		input    string
+		valid    bool
+		checksum bool
+	}{
+		{"0xae967917c465db8578ca9024c205720b1a3651a9", true, false}, // lowercase
+		{"0xAE967917C465DB8578CA9024C205720B1A3651A9", true, false}, // uppercase
+		{"ae967917c465db8578ca9024c205720b1a3651a9", true, false},   // lowercase, no prefix
+		{"0xAe967917c465db8578ca9024c205720b1a3651A9", true, true},  // valid mixed
+		{"0xae967917c465db8578ca9024c205720b1a3651A9", false, true}, // invalid mixed
+		{"0xAE967917c465db8578ca9024c205720b1a3651a9", false, true}, // invalid mixed
+		{"0x1111111111111111111112222222222223333323", true, false}, // no letters
+	}
+	for _, tt := range tests {
+		ma, err := NewMixedcaseAddressFromString(tt.input)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.input, err)
+		}
+		if have := ma.ValidChecksum(); have != tt.valid {
+			t.Errorf("%s: valid checksum %v, want %v", tt.input, have, tt.valid)
+		}
+		if have := ma.HasChecksum(); have != tt.checksum {
+			t.Errorf("%s: has checksum %v, want %v", tt.input, have, tt.checksum)
+		}
+	}
+}
+
 func TestHash_Scan(t *testing.T) {
 	type args struct {
 		src interface{}
@@ -374,6 +518,62 @@ func TestAddress_Value(t *testing.T) {
 	}
 }
 
+func TestMarshalAddresses(t *testing.T) {
+	for _, n := range []int{-1, 0, 1, 63, 64, 65, 1000} {
+		var addrs []Address
+		if n >= 0 {
+			addrs = make([]Address, n)
+		}
+		rng := rand.New(rand.NewSource(int64(n)))
+		for i := range addrs {
+			rng.Read(addrs[i][:])
+		}
+		want, err := json.Marshal(addrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		if err := MarshalAddresses(buf, addrs); err != nil {
+			t.Fatalf("%d addresses: %v", n, err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%d addresses: output mismatch:\nhave %s\nwant %s", n, buf.Bytes(), want)
+		}
+	}
+	// Write errors are passed on
+	errWrite := errors.New("write failed")
+	if err := MarshalAddresses(failingWriter{errWrite}, make([]Address, 100)); err != errWrite {
+		t.Errorf("have error %v, want %v", err, errWrite)
+	}
+}
+
+// failingWriter is an io.Writer failing every write with err.
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write([]byte) (int, error) { return 0, w.err }
+
+func BenchmarkMarshalAddresses(b *testing.B) {
+	addrs := make([]Address, 10000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := MarshalAddresses(ioutil.Discard, addrs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalAddressesJSON(b *testing.B) {
+	addrs := make([]Address, 10000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, err := json.Marshal(addrs)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ioutil.Discard.Write(data)
+	}
+}
+
 func TestAddress_Format(t *testing.T) {
 	b := []byte{
 		0xb2, 0x6f, 0x2b, 0x34, 0x2a, 0xab, 0x24, 0xbc, 0xf6, 0x3e,
@@ -528,6 +728,67 @@ func TestHash_Format(t *testing.T) {
 			out:  fmt.Sprintf("%t", hash),
 			want: "%!t(hash=b26f2b342aab24bcf63ea218c6a9274d30ab9a15a218c6a9274d30ab9a151000)",
 		},
+		// Precision truncates the hex digits, width pads the result
+		{
+			name: "printf-.6x",
+			out:  fmt.Sprintf("%.6x", hash),
+			want: "b26f2b",
+		},
+		{
+			name: "printf-.8X",
+			out:  fmt.Sprintf("%.8X", hash),
+			want: "B26F2B34",
+		},
+		{
+			name: "printf-#.6x",
+			out:  fmt.Sprintf("%#.6x", hash),
+			want: "0xb26f2b",
+		},
+		{
+			name: "printf-#.6X",
+			out:  fmt.Sprintf("%#.6X", hash),
+			want: "0XB26F2B",
+		},
+		{
+			name: "printf-.8s",
+			out:  fmt.Sprintf("%.8s", hash),
+			want: "0xb26f2b34",
+		},
+		{
+			name: "printf-.4q",
+			out:  fmt.Sprintf("%.4q", hash),
+			want: `"0xb26f"`,
+		},
+		{
+			name: "printf-.100x",
+			out:  fmt.Sprintf("%.100x", hash),
+			want: "b26f2b342aab24bcf63ea218c6a9274d30ab9a15a218c6a9274d30ab9a151000",
+		},
+		{
+			name: "printf-12.6x",
+			out:  fmt.Sprintf("%12.6x", hash),
+			want: "      b26f2b",
+		},
+		{
+			name: "printf--12.6x",
+			out:  fmt.Sprintf("%-12.6x|", hash),
+			want: "b26f2b      |",
+		},
+		{
+			name: "printf-#12.6x",
+			out:  fmt.Sprintf("%#12.6x", hash),
+			want: "    0xb26f2b",
+		},
+		{
+			name: "printf-70s",
+			out:  fmt.Sprintf("%70s", hash),
+			want: "    0xb26f2b342aab24bcf63ea218c6a9274d30ab9a15a218c6a9274d30ab9a151000",
+		},
+		{
+			name: "printf-10v",
+			out:  fmt.Sprintf("%10v", hash),
+			want: "0xb26f2b342aab24bcf63ea218c6a9274d30ab9a15a218c6a9274d30ab9a151000",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -537,3 +798,1604 @@ func TestHash_Format(t *testing.T) {
 		})
 	}
 }
+
+func TestHashShort(t *testing.T) {
+	hash := HexToHash("0xb26f2b342aab24bcf63ea218c6a9274d30ab9a15a218c6a9274d30ab9a151000")
+	full := "b26f2b342aab24bcf63ea218c6a9274d30ab9a15a218c6a9274d30ab9a151000"
+	tests := []struct {
+		n            int
+		short        string
+		prefixSuffix string
+	}{
+		{-1, "", ".."},
+		{0, "", ".."},
+		{1, "b", "b..0"},
+		{6, "b26f2b", "b26f2b..151000"},
+		{8, "b26f2b34", "b26f2b34..9a151000"},
+		{31, full[:31], full[:31] + ".." + full[33:]},
+		{32, full[:32], full},
+		{64, full, full},
+		{100, full, full},
+	}
+	for _, tt := range tests {
+		if have := hash.Short(tt.n); have != tt.short {
+			t.Errorf("Short(%d): have %q, want %q", tt.n, have, tt.short)
+		}
+		if have := hash.ShortPrefixSuffix(tt.n); have != tt.prefixSuffix {
+			t.Errorf("ShortPrefixSuffix(%d): have %q, want %q", tt.n, have, tt.prefixSuffix)
+		}
+	}
+	if have, want := hash.TerminalString(), fmt.Sprintf("%x..%x", hash[:3], hash[29:]); have != want {
+		t.Errorf("TerminalString: have %q, want %q", have, want)
+	}
+}
+
+func TestMarshalLocations(t *testing.T) {
+	locs := []Location{{0, 1}, {}, {2}, {1, 0}, {2, 2}}
+	enc, err := MarshalLocations(locs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `["cyprus2","prime","hydra","paxos1","hydra3"]`
+	if string(enc) != want {
+		t.Errorf("encoding mismatch: have %s, want %s", enc, want)
+	}
+	dec, err := UnmarshalLocations(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dec, locs) {
+		t.Errorf("round trip mismatch: have %v, want %v", dec, locs)
+	}
+	if _, err := UnmarshalLocations([]byte(`["prime","cyprus4"]`)); err == nil {
+		t.Errorf("expected error for unknown location name")
+	}
+	if _, err := MarshalLocations([]Location{{5}}); err == nil {
+		t.Errorf("expected error for invalid location")
+	}
+}
+
+func TestLocationSliceKey(t *testing.T) {
+	tests := []struct {
+		loc  Location
+		want string
+	}{
+		{Location{}, "prime"},
+		{Location{0}, "0"},
+		{Location{0, 1}, "0-1"},
+		{Location{2, 2}, "2-2"},
+	}
+	for _, tt := range tests {
+		if have := tt.loc.SliceKey(); have != tt.want {
+			t.Errorf("%v: slice key mismatch: have %q, want %q", tt.loc, have, tt.want)
+		}
+	}
+}
+
+func TestLocationJSONSchema(t *testing.T) {
+	type indexSchema struct {
+		OneOf []struct {
+			Type    string   `json:"type"`
+			Maximum *int     `json:"maximum"`
+			Enum    []string `json:"enum"`
+		} `json:"oneOf"`
+	}
+	type pathSchema struct {
+		Type     string        `json:"type"`
+		MaxItems int           `json:"maxItems"`
+		Items    []indexSchema `json:"items"`
+	}
+	var schema struct {
+		OneOf []struct {
+			pathSchema
+			Properties struct {
+				Name struct {
+					Enum []string `json:"enum"`
+				} `json:"name"`
+				Path pathSchema `json:"path"`
+			} `json:"properties"`
+			ContentEncoding string `json:"contentEncoding"`
+		} `json:"oneOf"`
+	}
+	if err := json.Unmarshal([]byte(LocationJSONSchema()), &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	if len(schema.OneOf) != 3 || schema.OneOf[0].Type != "array" || schema.OneOf[1].Type != "object" || schema.OneOf[2].Type != "string" || schema.OneOf[2].ContentEncoding != "base64" {
+		t.Fatalf("unexpected schema shape: %+v", schema)
+	}
+	// The path form stands alone and within the object form
+	bounds := []int{NumRegionsInPrime, NumZonesInRegion}
+	for _, path := range []pathSchema{schema.OneOf[0].pathSchema, schema.OneOf[1].Properties.Path} {
+		if path.Type != "array" || path.MaxItems != HierarchyDepth-1 || len(path.Items) != HierarchyDepth-1 {
+			t.Fatalf("unexpected path schema shape: %+v", path)
+		}
+		for i, item := range path.Items {
+			if len(item.OneOf) != 2 || *item.OneOf[0].Maximum != bounds[i]-1 || len(item.OneOf[1].Enum) != bounds[i] {
+				t.Errorf("item %d: unexpected bounds: %+v", i, item)
+			}
+		}
+	}
+	if names := schema.OneOf[1].Properties.Name.Enum; fmt.Sprint(names) != fmt.Sprint(AllLocationNames()) {
+		t.Errorf("name enum mismatch: have %v, want %v", names, AllLocationNames())
+	}
+	// Every valid location, as encoded by RPCMarshal, must be accepted
+	for _, loc := range []Location{{}, {0}, {2}, {0, 0}, {1, 2}, {2, 2}} {
+		for i, index := range loc.RPCMarshal() {
+			hex := index.String()
+			found := false
+			for _, allowed := range schema.OneOf[0].Items[i].OneOf[1].Enum {
+				found = found || allowed == hex
+			}
+			if !found {
+				t.Errorf("%v: index %d (%s) rejected by schema", loc, i, hex)
+			}
+		}
+	}
+}
+
+func TestTxPartitionKey(t *testing.T) {
+	tests := []struct {
+		prefix byte
+		want   string
+	}{
+		{0x00, "prime"},
+		{0x09, "prime"},
+		{0x0a, "0"},
+		{0x14, "0-0"},
+		{0x3c, "1-0"},
+		{0x81, "2-2"},
+	}
+	for _, tt := range tests {
+		dest := Address{tt.prefix, 0x01}
+		have, err := TxPartitionKey(dest)
+		if err != nil {
+			t.Errorf("%x: unexpected error: %v", dest, err)
+		} else if have != tt.want {
+			t.Errorf("%x: partition key mismatch: have %q, want %q", dest, have, tt.want)
+		}
+	}
+	if _, err := TxPartitionKey(Address{0x82}); !errors.Is(err, ErrUnroutableAddress) {
+		t.Errorf("unroutable address: have %v, want %v", err, ErrUnroutableAddress)
+	}
+}
+
+func TestHashShortID(t *testing.T) {
+	tests := []struct {
+		hash Hash
+		want string
+	}{
+		{Hash{}, "0000000000000"},
+		{HexToHash("0xffffffffffffffff000000000000000000000000000000000000000000000000"), "ZZZZZZZZZZZZY"},
+		{HexToHash("0x0123456789abcdefffffffffffffffffffffffffffffffffffffffffffffffff"), "04HMASW9NF6YY"},
+	}
+	for _, tt := range tests {
+		if have := tt.hash.ShortID(); have != tt.want {
+			t.Errorf("%x: short id mismatch: have %s, want %s", tt.hash, have, tt.want)
+		}
+	}
+	// Only the leading 8 bytes are encoded
+	a, b := HexToHash("0x01"), HexToHash("0x02")
+	if a.ShortID() != b.ShortID() {
+		t.Errorf("short id depends on truncated bytes")
+	}
+}
+
+func TestLocationID(t *testing.T) {
+	seen := make(map[int]bool)
+	for name, prefixes := range getHierarchy().prefixRanges {
+		loc, err := ParseLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := loc.ID()
+		// IDs follow the order of the address prefix ranges
+		if want := int(prefixes.lo) / 10; id != want {
+			t.Errorf("%s: id mismatch: have %d, want %d", name, id, want)
+		}
+		if seen[id] {
+			t.Errorf("%s: duplicate id %d", name, id)
+		}
+		seen[id] = true
+
+		back, ok := LocationFromID(id)
+		if !ok || !back.Equal(loc) {
+			t.Errorf("%s: id %d decoded to %v", name, id, back)
+		}
+	}
+	if len(seen) != NumChains {
+		t.Errorf("chain count mismatch: have %d, want %d", len(seen), NumChains)
+	}
+	if id := (Location{3}).ID(); id != -1 {
+		t.Errorf("invalid location has id %d", id)
+	}
+	for _, id := range []int{-1, NumChains} {
+		if loc, ok := LocationFromID(id); ok {
+			t.Errorf("id %d decoded to %v", id, loc)
+		}
+	}
+}
+
+func TestEventSequence(t *testing.T) {
+	for id := 0; id < NumChains; id++ {
+		loc, _ := LocationFromID(id)
+		base, err := loc.EventSequenceBase()
+		if err != nil {
+			t.Fatalf("%v: %v", loc, err)
+		}
+		for _, local := range []uint64{0, 1, 1<<48 - 1} {
+			seq := base + local
+			haveLoc, haveLocal, err := DecodeEventSequence(seq)
+			if err != nil {
+				t.Fatalf("%v/%d: decode failed: %v", loc, local, err)
+			}
+			if !haveLoc.Equal(loc) || haveLocal != local {
+				t.Errorf("%v/%d: decoded to %v/%d", loc, local, haveLoc, haveLocal)
+			}
+		}
+	}
+	if _, _, err := DecodeEventSequence(uint64(NumChains) << 48); err == nil {
+		t.Error("sequence of unknown chain decoded")
+	}
+	for _, loc := range []Location{{3}, {0, 3}, {0, 0, 0}} {
+		if base, err := loc.EventSequenceBase(); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("invalid location %v: have base %#x, error %v", loc, base, err)
+		}
+	}
+}
+
+func TestLocationBitmask(t *testing.T) {
+	var all []Location
+	for id := 0; id < NumChains; id++ {
+		loc, _ := LocationFromID(id)
+		all = append(all, loc)
+	}
+	tests := []struct {
+		locs []Location
+		mask uint16
+	}{
+		{nil, 0},
+		{[]Location{{}}, 0x1},
+		{[]Location{{0}, {0, 1}}, 0x2 | 0x8},
+		{[]Location{{2, 2}}, 0x1000},
+		{all, 1<<NumChains - 1},
+	}
+	for _, tt := range tests {
+		mask := LocationsToBitmask(tt.locs)
+		if mask != tt.mask {
+			t.Errorf("%v: mask mismatch: have %#x, want %#x", tt.locs, mask, tt.mask)
+		}
+		locs := BitmaskToLocations(mask)
+		if len(locs) != len(tt.locs) {
+			t.Errorf("%#x: decoded %v, want %v", mask, locs, tt.locs)
+			continue
+		}
+		for i := range locs {
+			if !locs[i].Equal(tt.locs[i]) {
+				t.Errorf("%#x: location %d: have %v, want %v", mask, i, locs[i], tt.locs[i])
+			}
+		}
+	}
+	// Duplicates collapse, invalid locations and unused bits are ignored
+	if mask := LocationsToBitmask([]Location{{1}, {1}, {3}}); mask != 0x20 {
+		t.Errorf("mask mismatch: have %#x, want %#x", mask, 0x20)
+	}
+	if locs := BitmaskToLocations(0xe000); len(locs) != 0 {
+		t.Errorf("unused bits decoded to %v", locs)
+	}
+}
+
+func TestAddressDebugString(t *testing.T) {
+	tests := []struct {
+		addr Address
+		want string
+	}{
+		{HexToAddress("0x0000000000000000000000000000000000000001"), "prime:0x0000000000000000000000000000000000000001"},
+		{HexToAddress("0x1e00000000000000000000000000000000000abc"), "cyprus2:0x1e00000000000000000000000000000000000Abc"},
+		{HexToAddress("0xff00000000000000000000000000000000000001"), "unknown:0xff00000000000000000000000000000000000001"},
+	}
+	for _, tt := range tests {
+		have := tt.addr.DebugString()
+		if have != tt.want {
+			t.Errorf("%x: debug string mismatch: have %s, want %s", tt.addr, have, tt.want)
+			continue
+		}
+		if strings.HasPrefix(have, "unknown:") {
+			continue
+		}
+		addr, err := ParseDebugAddress(have)
+		if err != nil || addr != tt.addr {
+			t.Errorf("%s: parsed to %x (%v)", have, addr, err)
+		}
+	}
+	for _, s := range []string{
+		"cyprus1:0x1E00000000000000000000000000000000000abc", // address belongs to cyprus2
+		"unknown:0xff00000000000000000000000000000000000001",
+		"0x1E00000000000000000000000000000000000abc",
+		"cyprus2:0x1E00",
+	} {
+		if _, err := ParseDebugAddress(s); err == nil {
+			t.Errorf("%s: expected parse error", s)
+		}
+	}
+}
+
+func TestLocationAbbrev(t *testing.T) {
+	want := []string{
+		"pr",
+		"cy", "c1", "c2", "c3",
+		"pa", "p1", "p2", "p3",
+		"hy", "h1", "h2", "h3",
+	}
+	locs := AllLocations()
+	if len(locs) != len(want) {
+		t.Fatalf("chain count mismatch: have %d, want %d", len(locs), len(want))
+	}
+	for i, loc := range locs {
+		abbrev := loc.Abbrev()
+		if abbrev != want[i] {
+			t.Errorf("%s: have abbreviation %q, want %q", loc.Name(), abbrev, want[i])
+		}
+		for _, s := range []string{abbrev, strings.ToUpper(abbrev), " " + abbrev + " "} {
+			if have, err := ParseAbbrev(s); err != nil || !have.Equal(loc) {
+				t.Errorf("%q: have %v (%v), want %s", s, have, err, loc.Name())
+			}
+		}
+	}
+	for _, s := range []string{"", "p", "c0", "c4", "cyprus", "x1", "prime", "pr1"} {
+		if loc, err := ParseAbbrev(s); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("%q: have %v (%v), want %v", s, loc, err, ErrInvalidLocation)
+		}
+	}
+	// Unnamed regions of test hierarchies are abbreviated by index
+	t.Cleanup(func() { SetHierarchyParams(DefaultHierarchyParams) })
+	if err := SetHierarchyParams(HierarchyParams{Depth: 3, RegionsInPrime: 4, ZonesInRegion: 2}); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, loc := range AllLocations() {
+		abbrev := loc.Abbrev()
+		if seen[abbrev] {
+			t.Errorf("%s: duplicate abbreviation %q", loc.Name(), abbrev)
+		}
+		seen[abbrev] = true
+		if have, err := ParseAbbrev(abbrev); err != nil || !have.Equal(loc) {
+			t.Errorf("%q: have %v (%v), want %s", abbrev, have, err, loc.Name())
+		}
+	}
+	if abbrev := (Location{3, 1}).Abbrev(); abbrev != "r3z2" {
+		t.Errorf("unnamed region zone: have %q, want %q", abbrev, "r3z2")
+	}
+}
+
+func TestGossipTopic(t *testing.T) {
+	if topic := (Location{0, 1}).GossipTopic("blocks"); topic != "quai/cyprus2/blocks" {
+		t.Errorf("cyprus2 blocks: have %q, want %q", topic, "quai/cyprus2/blocks")
+	}
+	if topic := (Location{}).GossipTopic("headers"); topic != "quai/prime/headers" {
+		t.Errorf("prime headers: have %q, want %q", topic, "quai/prime/headers")
+	}
+	topics := make(map[string]bool)
+	for _, loc := range AllLocations() {
+		for _, kind := range []string{"blocks", "transactions", "headers"} {
+			topic := loc.GossipTopic(kind)
+			if topics[topic] {
+				t.Errorf("%s %s: duplicate topic %q", loc.Name(), kind, topic)
+			}
+			topics[topic] = true
+			haveLoc, haveKind, err := ParseGossipTopic(topic)
+			if err != nil || !haveLoc.Equal(loc) || haveKind != kind {
+				t.Errorf("%q: have %v %q (%v), want %s %q", topic, haveLoc, haveKind, err, loc.Name(), kind)
+			}
+		}
+	}
+	for _, topic := range []string{
+		"",
+		"quai",
+		"quai/cyprus2",
+		"quai/cyprus2/",
+		"quai//blocks",
+		"eth/cyprus2/blocks",
+		"/quai/cyprus2/blocks",
+		"quai/cyprus2/blocks/extra",
+		"quai/Cyprus2/blocks",
+		"quai/cyprus4/blocks",
+		"quai/c2/blocks",
+	} {
+		if loc, kind, err := ParseGossipTopic(topic); !errors.Is(err, ErrInvalidGossipTopic) {
+			t.Errorf("%q: have %v %q (%v), want %v", topic, loc, kind, err, ErrInvalidGossipTopic)
+		}
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	// Every name the package produces must parse back to its location
+	for id := 0; id < NumChains; id++ {
+		loc, _ := LocationFromID(id)
+		parsed, err := ParseLocation(loc.Name())
+		if err != nil {
+			t.Errorf("%s: %v", loc.Name(), err)
+		} else if !parsed.Equal(loc) {
+			t.Errorf("%s: parsed to %v, want %v", loc.Name(), parsed, loc)
+		}
+	}
+	tests := []struct {
+		name string
+		want Location
+	}{
+		{"  Cyprus2\n", Location{0, 1}},
+		{"PRIME", Location{}},
+		{"\tHydra ", Location{2}},
+	}
+	for _, tt := range tests {
+		if loc, err := ParseLocation(tt.name); err != nil || !loc.Equal(tt.want) {
+			t.Errorf("%q: parsed to %v (%v), want %v", tt.name, loc, err, tt.want)
+		}
+	}
+	for _, name := range []string{"", "cyprus0", "cyprus4", "hydra 1", "unknownregion", "invalid-location"} {
+		if loc, err := ParseLocation(name); err == nil {
+			t.Errorf("%q: parsed to %v, want error", name, loc)
+		}
+	}
+}
+
+func TestLocationPath(t *testing.T) {
+	// Every location's path must parse back to it
+	for id := 0; id < NumChains; id++ {
+		loc, _ := LocationFromID(id)
+		parsed, err := ParsePath(loc.Path())
+		if err != nil {
+			t.Errorf("%s: %v", loc.Name(), err)
+		} else if !parsed.Equal(loc) {
+			t.Errorf("%s: path %q parsed to %v, want %v", loc.Name(), loc.Path(), parsed, loc)
+		}
+	}
+	for loc, want := range map[string]string{"prime": "", "cyprus": "0", "cyprus2": "0/1", "hydra3": "2/2"} {
+		parsed, _ := ParseLocation(loc)
+		if have := parsed.Path(); have != want {
+			t.Errorf("%s: path %q, want %q", loc, have, want)
+		}
+	}
+	for _, path := range []string{"3", "0/3", "0/1/2", "/", "0/", "/0", "00", "0/01", "+1", "-1", "256", "a", " 0"} {
+		if loc, err := ParsePath(path); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("%q: parsed to %v (%v), want %v", path, loc, err, ErrInvalidLocation)
+		}
+	}
+}
+
+func TestLocationErrVariants(t *testing.T) {
+	for _, loc := range []Location{{3}, {0, 3}, {5, 9}, {0, 0, 0}} {
+		if _, err := loc.ContextErr(); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("%v: context error mismatch: have %v, want %v", []byte(loc), err, ErrInvalidLocation)
+		}
+		if _, err := loc.ContainsAddressErr(Address{}); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("%v: contains error mismatch: have %v, want %v", []byte(loc), err, ErrInvalidLocation)
+		}
+	}
+	tests := []struct {
+		loc      Location
+		ctx      int
+		addr     Address
+		contains bool
+	}{
+		{Location{}, PRIME_CTX, Address{0x09}, true},
+		{Location{1}, REGION_CTX, Address{0x32}, true},
+		{Location{0, 1}, ZONE_CTX, Address{0x1e}, true},
+		{Location{0, 1}, ZONE_CTX, Address{0x28}, false},
+	}
+	for _, tt := range tests {
+		ctx, err := tt.loc.ContextErr()
+		if err != nil || ctx != tt.ctx {
+			t.Errorf("%v: context %d (%v), want %d", tt.loc, ctx, err, tt.ctx)
+		}
+		contains, err := tt.loc.ContainsAddressErr(tt.addr)
+		if err != nil || contains != tt.contains {
+			t.Errorf("%v: contains %x: %v (%v), want %v", tt.loc, tt.addr, contains, err, tt.contains)
+		}
+	}
+}
+
+func TestUnmarshalHashesInto(t *testing.T) {
+	a, b := HexToHash("0x01"), HexToHash("0xff")
+	tests := []struct {
+		input string
+		size  int
+		want  []Hash
+		err   string
+	}{
+		{`[]`, 2, nil, ""},
+		{` [ ] `, 0, nil, ""},
+		{`["` + a.Hex() + `"]`, 2, []Hash{a}, ""},
+		{"[\n\"" + a.Hex() + "\" ,\t\"" + b.Hex() + "\"\n]", 2, []Hash{a, b}, ""},
+		{`["` + a.Hex() + `","` + b.Hex() + `"]`, 1, []Hash{a}, "hash 1: more than 1 hashes"},
+		{`["` + a.Hex() + `","0x01"]`, 2, []Hash{a}, "hash 1: hex string has length 2, want 64 for Hash"},
+		{`["` + a.Hex() + `",1]`, 2, []Hash{a}, "hash 1: not a JSON string"},
+		{`["` + a.Hex()[2:] + `"]`, 2, nil, "hash 0: hex string without 0x prefix"},
+		{`["` + a.Hex() + `"`, 2, []Hash{a}, "hash 0: expected ',' or ']'"},
+		{`["` + a.Hex() + `"] x`, 2, []Hash{a}, "unexpected data after hash list at offset 71"},
+		{`{}`, 2, nil, "hash list is not a JSON array"},
+	}
+	for _, tt := range tests {
+		dst := make([]Hash, tt.size)
+		n, err := UnmarshalHashesInto([]byte(tt.input), dst)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: error mismatch: have %v, want %q", tt.input, err, tt.err)
+		}
+		if n != len(tt.want) {
+			t.Errorf("%s: count mismatch: have %d, want %d", tt.input, n, len(tt.want))
+			continue
+		}
+		for i := range tt.want {
+			if dst[i] != tt.want[i] {
+				t.Errorf("%s: hash %d mismatch: have %x, want %x", tt.input, i, dst[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func benchmarkHashList(n int) []byte {
+	hashes := make([]Hash, n)
+	for i := range hashes {
+		hashes[i] = BigToHash(big.NewInt(int64(i)))
+	}
+	data, _ := json.Marshal(hashes)
+	return data
+}
+
+func BenchmarkUnmarshalHashesInto(b *testing.B) {
+	data := benchmarkHashList(1024)
+	dst := make([]Hash, 1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := UnmarshalHashesInto(data, dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalHashesJSON(b *testing.B) {
+	data := benchmarkHashList(1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var dst []Hash
+		if err := json.Unmarshal(data, &dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestAddressPrefixRanges(t *testing.T) {
+	if len(getHierarchy().prefixRanges) != NumChains {
+		t.Fatalf("prefix table has %d ranges, want %d", len(getHierarchy().prefixRanges), NumChains)
+	}
+	for name, want := range getHierarchy().prefixRanges {
+		loc, err := ParseLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lo, hi, ok := PrefixRangeForLocation(loc)
+		if !ok || lo != want.lo || hi != want.hi {
+			t.Errorf("%s: range mismatch: have [%d, %d] (%v), want [%d, %d]", name, lo, hi, ok, want.lo, want.hi)
+		}
+		for prefix := int(lo); prefix <= int(hi); prefix++ {
+			if have, ok := LocationForAddressPrefix(uint8(prefix)); !ok || !have.Equal(loc) {
+				t.Errorf("prefix %d: location mismatch: have %v (%v), want %s", prefix, have, ok, name)
+			}
+		}
+	}
+	for prefix := 130; prefix < 256; prefix++ {
+		if loc, ok := LocationForAddressPrefix(uint8(prefix)); ok {
+			t.Errorf("prefix %d: unexpected location %v", prefix, loc)
+		}
+	}
+	if _, _, ok := PrefixRangeForLocation(Location{3}); ok {
+		t.Error("invalid location has a prefix range")
+	}
+}
+
+// searchAddressLocation is the search based implementation Address.Location
+// replaced, kept as a reference for equivalence tests and benchmarks.
+func searchAddressLocation(a Address) *Location {
+	R, Z, D := 0, 0, HierarchyDepth
+	if nodeLoc := GetNodeLocation(); nodeLoc.HasRegion() {
+		R = nodeLoc.Region()
+		if nodeLoc.HasZone() {
+			Z = nodeLoc.Zone()
+		}
+	}
+	primeChecked := false
+	for r := 0; r < NumRegionsInPrime; r++ {
+		for z := 0; z < NumZonesInRegion; z++ {
+			l := Location{byte((r + R) % D), byte((z + Z) % D)}
+			if l.ContainsAddress(a) {
+				return &l
+			}
+		}
+		l := Location{byte((r + R) % D)}
+		if l.ContainsAddress(a) {
+			return &l
+		}
+		if !primeChecked {
+			primeChecked = true
+			l := Location{}
+			if l.ContainsAddress(a) {
+				return &l
+			}
+		}
+	}
+	return nil
+}
+
+func TestAddressLocationTable(t *testing.T) {
+	defer SetNodeLocation(GetNodeLocation())
+
+	for _, node := range []Location{{}, {0}, {2}, {0, 0}, {1, 2}, {2, 1}} {
+		SetNodeLocation(node)
+		for prefix := 0; prefix < 256; prefix++ {
+			addr := Address{byte(prefix), 0x01}
+			have, want := addr.Location(), searchAddressLocation(addr)
+			if (have == nil) != (want == nil) || have != nil && !have.Equal(*want) {
+				t.Errorf("node %v, prefix %d: location mismatch: have %v, want %v", node, prefix, have, want)
+			}
+		}
+		if loc := ZeroAddr.Location(); loc == nil || !loc.Equal(Location{}) {
+			t.Errorf("node %v: zero address location %v, want prime", node, loc)
+		}
+	}
+	// Modifying a returned location must not corrupt the table
+	addr := HexToAddress("0x1400000000000000000000000000000000000001")
+	loc := addr.Location()
+	(*loc)[1] = 2
+	*loc = append(*loc, 7)
+	prefixLoc, _ := LocationForAddressPrefix(addr[0])
+	prefixLoc[0] = 2
+	if have := addr.Location(); have == nil || !have.Equal(Location{0, 0}) {
+		t.Errorf("lookup corrupted by caller: have %v, want %v", have, Location{0, 0})
+	}
+}
+
+// locationSink keeps the compiler from eliding benchmarked lookups.
+var locationSink *Location
+
+func benchmarkAddresses() []Address {
+	addrs := make([]Address, 256)
+	for i := range addrs {
+		addrs[i] = Address{byte(i)}
+	}
+	return addrs
+}
+
+func BenchmarkAddressLocation(b *testing.B) {
+	addrs := benchmarkAddresses()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		locationSink = addrs[i%len(addrs)].Location()
+	}
+}
+
+func BenchmarkAddressLocationSearch(b *testing.B) {
+	addrs := benchmarkAddresses()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		locationSink = searchAddressLocation(addrs[i%len(addrs)])
+	}
+}
+
+func TestHashCmp(t *testing.T) {
+	max := HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+	hashes := []Hash{{}, HexToHash("0x01"), HexToHash("0x0100"), HexToHash("0x8000000000000000000000000000000000000000000000000000000000000000"), max}
+	for i := 0; i < 32; i++ {
+		var h Hash
+		rand.Read(h[:])
+		hashes = append(hashes, h)
+	}
+	for _, a := range hashes {
+		for _, b := range hashes {
+			if have, want := a.Cmp(b), a.Big().Cmp(b.Big()); have != want {
+				t.Errorf("%x <=> %x: have %d, want %d", a, b, have, want)
+			}
+			if have, want := a.Less(b), a.Big().Cmp(b.Big()) < 0; have != want {
+				t.Errorf("%x < %x: have %v, want %v", a, b, have, want)
+			}
+		}
+	}
+}
+
+func TestAddressEqualConstantTime(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		var a, b Address
+		rand.Read(a[:])
+		switch i % 3 {
+		case 0:
+			rand.Read(b[:])
+		case 1:
+			b = a
+		case 2:
+			// Differ in a single bit only
+			b = a
+			b[rand.Intn(AddressLength)] ^= 1 << uint(rand.Intn(8))
+		}
+		if have, want := a.EqualConstantTime(b), a == b; have != want {
+			t.Errorf("%x vs %x: have %v, want %v", a, b, have, want)
+		}
+	}
+}
+
+func TestLocationDominance(t *testing.T) {
+	tests := []struct {
+		loc, cmp    Location
+		dominant    bool
+		subordinate bool
+	}{
+		{Location{}, Location{}, false, false},
+		{Location{0}, Location{0}, false, false},
+		{Location{0, 1}, Location{0, 1}, false, false},
+		{Location{}, Location{1}, true, false},
+		{Location{}, Location{2, 2}, true, false},
+		{Location{2, 2}, Location{}, false, true},
+		{Location{0}, Location{0, 1}, true, false},
+		{Location{0, 1}, Location{0}, false, true},
+		{Location{0, 0}, Location{0, 1}, false, false},
+		{Location{0}, Location{1}, false, false},
+		{Location{0, 0}, Location{1}, false, false},
+		{Location{1}, Location{0, 0}, false, false},
+		{Location{1}, Location{0, 1}, false, false},
+	}
+	for _, tt := range tests {
+		if have := tt.loc.IsDominantOf(tt.cmp); have != tt.dominant {
+			t.Errorf("%v dominant of %v: have %v, want %v", tt.loc, tt.cmp, have, tt.dominant)
+		}
+		if have := tt.loc.IsSubordinateOf(tt.cmp); have != tt.subordinate {
+			t.Errorf("%v subordinate of %v: have %v, want %v", tt.loc, tt.cmp, have, tt.subordinate)
+		}
+	}
+}
+
+func TestNewLocation(t *testing.T) {
+	valid := [][]byte{nil, {0}, {2}, {0, 0}, {2, 2}}
+	for _, indices := range valid {
+		loc, err := NewLocation(indices...)
+		if err != nil {
+			t.Errorf("%v: %v", indices, err)
+			continue
+		}
+		if !loc.Equal(Location(indices)) {
+			t.Errorf("%v: have %v", indices, loc)
+		}
+		if err := loc.Validate(); err != nil {
+			t.Errorf("%v: validation failed: %v", indices, err)
+		}
+	}
+	invalid := [][]byte{{3}, {5, 9}, {0, 3}, {255}, {0, 0, 0}}
+	for _, indices := range invalid {
+		if loc, err := NewLocation(indices...); !errors.Is(err, ErrInvalidLocation) || loc != nil {
+			t.Errorf("%v: have %v (%v), want %v", indices, loc, err, ErrInvalidLocation)
+		}
+		if err := Location(indices).Validate(); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("%v: validation error mismatch: have %v, want %v", indices, err, ErrInvalidLocation)
+		}
+	}
+}
+
+func TestBucketAddressesByLocation(t *testing.T) {
+	addrs := []Address{{0x00}, {0x14, 0x01}, {0x82}, {0xff}, {0x14, 0x02}, {0x09}, {0x5a}}
+	buckets := BucketAddressesByLocation(addrs)
+	want := map[string][]Address{
+		"prime":               {{0x00}, {0x09}},
+		"cyprus1":             {{0x14, 0x01}, {0x14, 0x02}},
+		UnknownLocationBucket: {{0x82}, {0xff}},
+		"hydra":               {{0x5a}},
+	}
+	if len(buckets) != len(want) {
+		t.Fatalf("bucket count mismatch: have %d, want %d", len(buckets), len(want))
+	}
+	for name, addrs := range want {
+		have := buckets[name]
+		if len(have) != len(addrs) {
+			t.Errorf("%s: have %x, want %x", name, have, addrs)
+			continue
+		}
+		for i := range addrs {
+			if have[i] != addrs[i] {
+				t.Errorf("%s: address %d: have %x, want %x", name, i, have[i], addrs[i])
+			}
+		}
+	}
+	// Buckets agree with the per-address lookup
+	for i := 0; i < 256; i++ {
+		a := Address{byte(i)}
+		name := UnknownLocationBucket
+		if loc := a.Location(); loc != nil {
+			name = loc.Name()
+		}
+		if buckets := BucketAddressesByLocation([]Address{a}); len(buckets[name]) != 1 {
+			t.Errorf("prefix %d: not bucketed under %s: %v", i, name, buckets)
+		}
+	}
+}
+
+func BenchmarkBucketAddressesByLocation(b *testing.B) {
+	addrs := benchmarkAddresses()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BucketAddressesByLocation(addrs)
+	}
+}
+
+func BenchmarkBucketAddressesByLocationLookup(b *testing.B) {
+	addrs := benchmarkAddresses()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buckets := make(map[string][]Address)
+		for _, a := range addrs {
+			name := UnknownLocationBucket
+			if loc := a.Location(); loc != nil {
+				name = loc.Name()
+			}
+			buckets[name] = append(buckets[name], a)
+		}
+	}
+}
+
+func TestLocationJSON(t *testing.T) {
+	// Location keeps the base64 wire format of byte slices
+	for _, loc := range []Location{{}, {2}, {0, 1}} {
+		want, _ := json.Marshal([]byte(loc))
+		enc, err := json.Marshal(loc)
+		if err != nil || string(enc) != string(want) {
+			t.Errorf("%v: have %s (%v), want %s", loc, enc, err, want)
+		}
+		var dec Location
+		if err := json.Unmarshal(enc, &dec); err != nil || !dec.Equal(loc) {
+			t.Errorf("%v: round trip mismatch: have %v (%v)", loc, dec, err)
+		}
+	}
+}
+
+func TestNamedLocationJSON(t *testing.T) {
+	tests := []struct {
+		loc  Location
+		want string
+	}{
+		{Location{}, `{"name":"prime","path":[]}`},
+		{Location{2}, `{"name":"hydra","path":[2]}`},
+		{Location{0, 1}, `{"name":"cyprus2","path":[0,1]}`},
+	}
+	for _, tt := range tests {
+		enc, err := json.Marshal(NamedLocation(tt.loc))
+		if err != nil {
+			t.Fatalf("%v: %v", tt.loc, err)
+		}
+		if string(enc) != tt.want {
+			t.Errorf("%v: encoding mismatch: have %s, want %s", tt.loc, enc, tt.want)
+		}
+		var dec NamedLocation
+		if err := json.Unmarshal(enc, &dec); err != nil {
+			t.Errorf("%v: %v", tt.loc, err)
+		} else if !Location(dec).Equal(tt.loc) {
+			t.Errorf("%v: round trip mismatch: have %v", tt.loc, dec)
+		}
+	}
+	// Invalid locations have no name
+	if enc, err := json.Marshal(NamedLocation{5, 9}); err != nil || string(enc) != `{"path":[5,9]}` {
+		t.Errorf("invalid location: have %s (%v)", enc, err)
+	}
+	// Alternative encodings, including the RPC and wire formats of Location
+	decodes := []struct {
+		input string
+		want  Location
+	}{
+		{`[0,1]`, Location{0, 1}},
+		{`["0x1","0x2"]`, Location{1, 2}},
+		{`[]`, Location{}},
+		{`{"name":"paxos3"}`, Location{1, 2}},
+		{`{"path":[1]}`, Location{1}},
+		{`"AAE="`, Location{0, 1}},
+	}
+	for _, tt := range decodes {
+		var dec NamedLocation
+		if err := json.Unmarshal([]byte(tt.input), &dec); err != nil {
+			t.Errorf("%s: %v", tt.input, err)
+		} else if !Location(dec).Equal(tt.want) {
+			t.Errorf("%s: have %v, want %v", tt.input, dec, tt.want)
+		}
+	}
+	// Locations not naming a chain are rejected in every form
+	for _, input := range []string{
+		`{"name":"cyprus1","path":[0,1]}`,
+		`{"name":"atlantis"}`,
+		`{"path":[5,9]}`,
+		`{"path":[0,0,0]}`,
+		`[3]`,
+		`[0,3]`,
+		`[0,0,0]`,
+		`[256]`,
+		`"AwA="`,
+		`"AAAA"`,
+	} {
+		var dec NamedLocation
+		if err := json.Unmarshal([]byte(input), &dec); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("%s: have %v (%v), want %v", input, dec, err, ErrInvalidLocation)
+		}
+	}
+	for _, input := range []string{`{}`, `["zz"]`, `[-1]`, `true`} {
+		var dec NamedLocation
+		if err := json.Unmarshal([]byte(input), &dec); err == nil {
+			t.Errorf("%s: invalid encoding accepted as %v", input, dec)
+		}
+	}
+	// Locations embedded in structs use the same encoding
+	var relay struct{ Location NamedLocation }
+	if err := json.Unmarshal([]byte(`{"Location":{"name":"hydra1","path":[2,0]}}`), &relay); err != nil || !Location(relay.Location).Equal(Location{2, 0}) {
+		t.Errorf("embedded location: have %v (%v)", relay.Location, err)
+	}
+}
+
+func TestHashBitwise(t *testing.T) {
+	max := HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+	hashes := []Hash{{}, max, HexToHash("0x01"), HexToHash("0x8000000000000000000000000000000000000000000000000000000000000000")}
+	for i := 0; i < 16; i++ {
+		var h Hash
+		rand.Read(h[:])
+		hashes = append(hashes, h)
+	}
+	for _, a := range hashes {
+		for _, b := range hashes {
+			x, y := a.Big(), b.Big()
+			ops := []struct {
+				name string
+				have Hash
+				want *big.Int
+			}{
+				{"xor", a.Xor(b), new(big.Int).Xor(x, y)},
+				{"and", a.And(b), new(big.Int).And(x, y)},
+				{"or", a.Or(b), new(big.Int).Or(x, y)},
+			}
+			for _, op := range ops {
+				if op.have != BigToHash(op.want) {
+					t.Errorf("%x %s %x: have %x, want %x", a, op.name, b, op.have, op.want)
+				}
+			}
+			if have, want := a.DistanceTo(b), new(big.Int).Xor(x, y); have.Cmp(want) != 0 {
+				t.Errorf("%x distance to %x: have %v, want %v", a, b, have, want)
+			}
+		}
+		if a.DistanceTo(a).Sign() != 0 {
+			t.Errorf("%x: non-zero distance to itself", a)
+		}
+	}
+}
+
+func TestNodeLocationConcurrentAccess(t *testing.T) {
+	defer SetNodeLocation(GetNodeLocation())
+
+	locs := []Location{{}, {0}, {1, 2}, {2, 0}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				loc := GetNodeLocation()
+				if err := loc.Validate(); err != nil {
+					t.Errorf("torn node location: %v", err)
+					return
+				}
+				Address{0x14}.IsInChainScope()
+				Location{1, 1}.SubIndex()
+
+				// Appending to the shared location must not write into it
+				extended := append(GetNodeLocation(), byte(i))
+				if extended[len(extended)-1] != byte(i) {
+					t.Errorf("appended index overwritten: have %d, want %d", extended[len(extended)-1], i)
+					return
+				}
+				if sub := GetNodeLocation().SubInSlice(Location{byte(i % 3), byte(i % 3), byte(i % 3)}); sub[len(sub)-1] != byte(i%3) {
+					t.Errorf("sub location overwritten: have %v", sub)
+					return
+				}
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+loop:
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+			break loop
+		default:
+			SetNodeLocation(locs[i%len(locs)])
+		}
+	}
+	if loc := GetNodeLocation(); cap(loc) != len(loc) {
+		t.Errorf("node location has spare capacity: len %d, cap %d", len(loc), cap(loc))
+	}
+
+	// The stored location must not alias the caller's
+	loc := Location{0, 1}
+	SetNodeLocation(loc)
+	loc[1] = 2
+	if have := GetNodeLocation(); !have.Equal(Location{0, 1}) {
+		t.Errorf("node location changed with the caller's copy: %v", have)
+	}
+}
+
+func TestAddressClassification(t *testing.T) {
+	classify := func(a Address) string {
+		switch {
+		case a.IsContractAddress() && a.IsEOA():
+			return "both"
+		case a.IsContractAddress():
+			return "contract"
+		case a.IsEOA():
+			return "eoa"
+		}
+		return "neither"
+	}
+	check := func(rule string, wantInRange func(a Address) string) {
+		for prefix := 0; prefix < 256; prefix++ {
+			for _, low := range []byte{0x00, 0x01, 0x80} {
+				a := Address{byte(prefix), 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, low}
+				want := "neither"
+				if a.Location() != nil {
+					want = wantInRange(a)
+				}
+				if have := classify(a); have != want {
+					t.Errorf("%s: %x: have %s, want %s", rule, a, have, want)
+				}
+			}
+		}
+		if have := classify(ZeroAddr); have != "neither" {
+			t.Errorf("%s: zero address classified as %s", rule, have)
+		}
+	}
+	// Without an encoding rule every in-range address is externally owned
+	check("default", func(Address) string { return "eoa" })
+
+	// A rule plugged in splits every range into both classes
+	defer func(rule func(Address) bool) { isContractEncoding = rule }(isContractEncoding)
+	isContractEncoding = func(a Address) bool { return a[AddressLength-1]&0x80 != 0 }
+	check("high bit", func(a Address) string {
+		if a[AddressLength-1]&0x80 != 0 {
+			return "contract"
+		}
+		return "eoa"
+	})
+}
+
+func TestLocationChildren(t *testing.T) {
+	tests := []struct {
+		loc         Location
+		children    []Location
+		descendants []Location
+	}{
+		{Location{}, []Location{{0}, {1}, {2}}, []Location{{0}, {0, 0}, {0, 1}, {0, 2}, {1}, {1, 0}, {1, 1}, {1, 2}, {2}, {2, 0}, {2, 1}, {2, 2}}},
+		{Location{1}, []Location{{1, 0}, {1, 1}, {1, 2}}, []Location{{1, 0}, {1, 1}, {1, 2}}},
+		{Location{2, 1}, nil, nil},
+		{Location{5}, nil, nil},
+	}
+	equal := func(a, b []Location) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !a[i].Equal(b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, tt := range tests {
+		if have := tt.loc.Children(); !equal(have, tt.children) {
+			t.Errorf("%v children: have %v, want %v", tt.loc, have, tt.children)
+		}
+		if have := tt.loc.Descendants(); !equal(have, tt.descendants) {
+			t.Errorf("%v descendants: have %v, want %v", tt.loc, have, tt.descendants)
+		}
+		for _, child := range tt.loc.Children() {
+			if !child.DomLocation().Equal(tt.loc) {
+				t.Errorf("%v: child %v has dominant %v", tt.loc, child, child.DomLocation())
+			}
+		}
+	}
+	if n := len(Location{}.Descendants()); n != NumChains-1 {
+		t.Errorf("prime has %d descendants, want %d", n, NumChains-1)
+	}
+}
+
+func TestHashWriteTo(t *testing.T) {
+	h := HexToHash("0x000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	var buf bytes.Buffer
+	n, err := h.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != HashLength || !bytes.Equal(buf.Bytes(), h[:]) {
+		t.Errorf("wrote %d bytes %x, want %x", n, buf.Bytes(), h[:])
+	}
+	if allocs := testing.AllocsPerRun(100, func() { h.WriteTo(ioutil.Discard) }); allocs != 0 {
+		t.Errorf("WriteTo allocated %v times", allocs)
+	}
+}
+
+func BenchmarkHashWriteTo(b *testing.B) {
+	h := HexToHash("0x01")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.WriteTo(ioutil.Discard)
+	}
+}
+
+func BenchmarkHashWriteBytes(b *testing.B) {
+	h := HexToHash("0x01")
+	var w io.Writer = ioutil.Discard
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Write(h.Bytes())
+	}
+}
+
+func TestGroupBySlice(t *testing.T) {
+	locs := []Location{{0, 1}, {}, {2}, {0}, {1, 2}, {2, 0}, {}, {0, 0}}
+	groups := GroupBySlice(locs)
+
+	want := map[string][]Location{
+		"prime": {{}, {}},
+		"0":     {{0, 1}, {0}, {0, 0}},
+		"1":     {{1, 2}},
+		"2":     {{2}, {2, 0}},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("group count mismatch: have %d, want %d", len(groups), len(want))
+	}
+	for key, wantLocs := range want {
+		have := groups[key]
+		if len(have) != len(wantLocs) {
+			t.Errorf("group %q: have %v, want %v", key, have, wantLocs)
+			continue
+		}
+		for i := range have {
+			if !have[i].Equal(wantLocs[i]) {
+				t.Errorf("group %q item %d: have %v, want %v", key, i, have[i], wantLocs[i])
+			}
+		}
+	}
+	// Locations grouped together share a slice, across groups they do not
+	for key, group := range groups {
+		if key == "prime" {
+			continue
+		}
+		for _, loc := range group {
+			if !loc.InSameSliceAs(group[0][:1]) {
+				t.Errorf("group %q: %v not in slice of %v", key, loc, group[0][:1])
+			}
+			for other, others := range groups {
+				if other == key || other == "prime" {
+					continue
+				}
+				if loc.InSameSliceAs(others[0]) {
+					t.Errorf("%v in group %q shares a slice with %v in group %q", loc, key, others[0], other)
+				}
+			}
+		}
+	}
+	if n := testing.AllocsPerRun(100, func() { Location{0, 1}.InSameSliceAs(Location{0}) }); n != 0 {
+		t.Errorf("InSameSliceAs allocated %v times", n)
+	}
+}
+
+func TestRandomAddressInLocation(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for id := 0; id < NumChains; id++ {
+		loc, _ := LocationFromID(id)
+		for i := 0; i < 50; i++ {
+			addr, err := RandomAddressInLocation(loc, rng)
+			if err != nil {
+				t.Fatalf("%s: %v", loc.Name(), err)
+			}
+			if have := addr.Location(); have == nil || !have.Equal(loc) {
+				t.Fatalf("%s: address %x located in %v", loc.Name(), addr, have)
+			}
+		}
+	}
+	// The same seed yields the same addresses
+	a, _ := RandomAddressInLocation(Location{1, 2}, rand.New(rand.NewSource(7)))
+	b, _ := RandomAddressInLocation(Location{1, 2}, rand.New(rand.NewSource(7)))
+	if a != b {
+		t.Errorf("same seed yielded %x and %x", a, b)
+	}
+	for _, loc := range []Location{{3}, {0, 3}, {0, 0, 0}} {
+		if _, err := RandomAddressInLocation(loc, rng); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("%v: have %v, want %v", loc, err, ErrInvalidLocation)
+		}
+	}
+}
+
+func TestDeterministicAddress(t *testing.T) {
+	// Low 20 bytes of the Keccak-256 hashes of the seeds
+	tests := []struct {
+		seed string
+		want Address
+	}{
+		{"", HexToAddress("0xdcc703c0e500b653ca82273b7bfad8045d85a470")},
+		{"abc", HexToAddress("0x26c8d667c0d1e6e33a64a036ec44f58fa12d6c45")},
+	}
+	for _, tt := range tests {
+		if have := DeterministicAddress(tt.seed); have != tt.want {
+			t.Errorf("seed %q: have %x, want %x", tt.seed, have, tt.want)
+		}
+	}
+	if DeterministicAddress("alice") == DeterministicAddress("bob") {
+		t.Errorf("distinct seeds yielded the same address")
+	}
+}
+
+func TestDeterministicAddressInLocation(t *testing.T) {
+	for _, loc := range AllLocations() {
+		for _, seed := range []string{"", "alice", "bob", "carol"} {
+			addr, err := DeterministicAddressInLocation(seed, loc)
+			if err != nil {
+				t.Fatalf("%s: %v", loc.Name(), err)
+			}
+			if have := addr.Location(); have == nil || !have.Equal(loc) {
+				t.Fatalf("%s: address %x located in %v", loc.Name(), addr, have)
+			}
+			again, _ := DeterministicAddressInLocation(seed, loc)
+			if again != addr {
+				t.Errorf("%s: seed %q yielded %x and %x", loc.Name(), seed, addr, again)
+			}
+			if body := DeterministicAddress(seed); !bytes.Equal(addr[1:], body[1:]) {
+				t.Errorf("%s: seed %q body %x, want %x", loc.Name(), seed, addr[1:], body[1:])
+			}
+		}
+	}
+	for _, loc := range []Location{{3}, {0, 3}, {0, 0, 0}} {
+		if _, err := DeterministicAddressInLocation("alice", loc); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("%v: have %v, want %v", loc, err, ErrInvalidLocation)
+		}
+	}
+}
+
+func TestIsZero(t *testing.T) {
+	if !ZeroHash.IsZero() || !(Hash{}).IsZero() {
+		t.Error("zero hash not reported as zero")
+	}
+	if (Hash{31: 1}).IsZero() || HexToHash("0x01").IsZero() {
+		t.Error("non-zero hash reported as zero")
+	}
+	if !ZeroAddr.IsZero() || !(Address{}).IsZero() {
+		t.Error("zero address not reported as zero")
+	}
+	if (Address{19: 1}).IsZero() {
+		t.Error("non-zero address reported as zero")
+	}
+	if !ZeroAddr.IsInChainScope() {
+		t.Error("zero address not in chain scope")
+	}
+}
+
+func TestLocationRLP(t *testing.T) {
+	for id := 0; id < NumChains; id++ {
+		loc, _ := LocationFromID(id)
+		enc, err := rlp.EncodeToBytes(loc)
+		if err != nil {
+			t.Fatalf("%s: %v", loc.Name(), err)
+		}
+		// The encoding must stay that of the raw path bytes
+		if want, _ := rlp.EncodeToBytes([]byte(loc)); !bytes.Equal(enc, want) {
+			t.Errorf("%s: encoding %x, want %x", loc.Name(), enc, want)
+		}
+		var dec Location
+		if err := rlp.DecodeBytes(enc, &dec); err != nil {
+			t.Errorf("%s: %v", loc.Name(), err)
+		} else if !dec.Equal(loc) {
+			t.Errorf("%s: decoded to %v", loc.Name(), dec)
+		}
+	}
+	// Locations embedded in other values are validated as well
+	var embedded struct{ Loc Location }
+	if enc, _ := rlp.EncodeToBytes(struct{ Loc []byte }{[]byte{0, 1, 2, 0}}); !errors.Is(rlp.DecodeBytes(enc, &embedded), ErrInvalidLocation) {
+		t.Errorf("4 byte path decoded to %v", embedded.Loc)
+	}
+	for _, path := range [][]byte{{0, 1, 2, 0}, {0, 0, 0}, {3}, {1, 3}} {
+		enc, _ := rlp.EncodeToBytes(path)
+		var dec Location
+		if err := rlp.DecodeBytes(enc, &dec); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("%v: have %v, want %v", path, err, ErrInvalidLocation)
+		}
+	}
+}
+
+func TestAddressInLocation(t *testing.T) {
+	addr := HexToAddress("0x14a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3")
+	for id := 0; id < NumChains; id++ {
+		loc, _ := LocationFromID(id)
+		moved, err := addr.InLocation(loc)
+		if err != nil {
+			t.Fatalf("%s: %v", loc.Name(), err)
+		}
+		if have := moved.Location(); have == nil || !have.Equal(loc) {
+			t.Errorf("%s: address %x located in %v", loc.Name(), moved, have)
+		}
+		if !bytes.Equal(moved[1:], addr[1:]) {
+			t.Errorf("%s: body changed from %x to %x", loc.Name(), addr[1:], moved[1:])
+		}
+	}
+	if _, err := addr.InLocation(Location{0, 5}); !errors.Is(err, ErrInvalidLocation) {
+		t.Errorf("invalid location: have %v, want %v", err, ErrInvalidLocation)
+	}
+}
+
+func TestLocationBinary(t *testing.T) {
+	var locs []Location
+	for id := 0; id < NumChains; id++ {
+		loc, _ := LocationFromID(id)
+		locs = append(locs, loc)
+
+		enc, err := loc.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: %v", loc.Name(), err)
+		}
+		var dec Location
+		if err := dec.UnmarshalBinary(enc); err != nil {
+			t.Errorf("%s: %v", loc.Name(), err)
+		} else if !dec.Equal(loc) {
+			t.Errorf("%s: decoded to %v", loc.Name(), dec)
+		}
+	}
+	// Sorting by key must order by depth, then by index
+	sort.Slice(locs, func(i, j int) bool { return bytes.Compare(locs[i].KeyBytes(), locs[j].KeyBytes()) < 0 })
+	want := []Location{{}, {0}, {1}, {2}, {0, 0}, {0, 1}, {0, 2}, {1, 0}, {1, 1}, {1, 2}, {2, 0}, {2, 1}, {2, 2}}
+	for i := range want {
+		if !locs[i].Equal(want[i]) {
+			t.Fatalf("key order mismatch at %d: have %v, want %v", i, locs, want)
+		}
+	}
+	for _, data := range [][]byte{nil, {1}, {0, 0}, {2, 0}, {1, 3}, {2, 0, 3}, {3, 0, 0, 0}} {
+		var dec Location
+		if err := dec.UnmarshalBinary(data); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("%x: have %v, want %v", data, err, ErrInvalidLocation)
+		}
+	}
+}
+
+func TestLocationSiblings(t *testing.T) {
+	tests := []struct {
+		loc  Location
+		want []Location
+	}{
+		{Location{}, nil},
+		{Location{0}, []Location{{1}, {2}}},
+		{Location{1}, []Location{{0}, {2}}},
+		{Location{0, 1}, []Location{{0, 0}, {0, 2}}},
+		{Location{2, 2}, []Location{{2, 0}, {2, 1}}},
+		{Location{3}, nil},
+		{Location{0, 3}, nil},
+	}
+	for _, tt := range tests {
+		have := tt.loc.Siblings()
+		if len(have) != len(tt.want) {
+			t.Errorf("%v: have %v, want %v", tt.loc, have, tt.want)
+			continue
+		}
+		for i := range have {
+			if !have[i].Equal(tt.want[i]) {
+				t.Errorf("%v: have %v, want %v", tt.loc, have, tt.want)
+				break
+			}
+		}
+	}
+	// Siblings must not alias each other or the location
+	loc := Location{1, 0}
+	siblings := loc.Siblings()
+	siblings[0][1] = 9
+	if !loc.Equal(Location{1, 0}) || !siblings[1].Equal(Location{1, 2}) {
+		t.Errorf("siblings alias: location %v, siblings %v", loc, siblings)
+	}
+}
+
+func TestLocationRouteTo(t *testing.T) {
+	tests := []struct {
+		src, dst Location
+		want     []Location
+	}{
+		{Location{0, 0}, Location{0, 0}, []Location{{0, 0}}},
+		{Location{}, Location{}, []Location{{}}},
+		{Location{0, 0}, Location{0, 1}, []Location{{0, 0}, {0}, {0, 1}}},
+		{Location{0, 0}, Location{1, 0}, []Location{{0, 0}, {0}, {}, {1}, {1, 0}}},
+		{Location{0, 0}, Location{0}, []Location{{0, 0}, {0}}},
+		{Location{0}, Location{0, 2}, []Location{{0}, {0, 2}}},
+		{Location{}, Location{2, 1}, []Location{{}, {2}, {2, 1}}},
+		{Location{2, 1}, Location{}, []Location{{2, 1}, {2}, {}}},
+		{Location{1}, Location{2}, []Location{{1}, {}, {2}}},
+	}
+	for _, tt := range tests {
+		have := tt.src.RouteTo(tt.dst)
+		if len(have) != len(tt.want) {
+			t.Errorf("%v to %v: have %v, want %v", tt.src, tt.dst, have, tt.want)
+			continue
+		}
+		for i := range have {
+			if !have[i].Equal(tt.want[i]) {
+				t.Errorf("%v to %v: have %v, want %v", tt.src, tt.dst, have, tt.want)
+				break
+			}
+		}
+	}
+	// Every route between any two chains must consist of adjacent hops
+	for i := 0; i < NumChains; i++ {
+		src, _ := LocationFromID(i)
+		for j := 0; j < NumChains; j++ {
+			dst, _ := LocationFromID(j)
+			route := src.RouteTo(dst)
+			if !route[0].Equal(src) || !route[len(route)-1].Equal(dst) {
+				t.Errorf("%s to %s: route %v has wrong ends", src.Name(), dst.Name(), route)
+			}
+			for k := 1; k < len(route); k++ {
+				prev, next := route[k-1], route[k]
+				if !prev.DomLocation().Equal(next) && !next.DomLocation().Equal(prev) {
+					t.Errorf("%s to %s: hop from %v to %v", src.Name(), dst.Name(), prev, next)
+				}
+			}
+		}
+	}
+}
+
+func TestDefaultHierarchy(t *testing.T) {
+	if have := GetHierarchyParams(); have != DefaultHierarchyParams {
+		t.Fatalf("hierarchy in use %+v, want %+v", have, DefaultHierarchyParams)
+	}
+	if n := DefaultHierarchyParams.NumChains(); n != NumChains {
+		t.Errorf("default hierarchy has %d chains, want %d", n, NumChains)
+	}
+	// The generated prefix ranges must match the historical table
+	want := map[string][2]uint8{
+		"prime": {0, 9}, "cyprus": {10, 19}, "cyprus1": {20, 29}, "cyprus2": {30, 39}, "cyprus3": {40, 49},
+		"paxos": {50, 59}, "paxos1": {60, 69}, "paxos2": {70, 79}, "paxos3": {80, 89},
+		"hydra": {90, 99}, "hydra1": {100, 109}, "hydra2": {110, 119}, "hydra3": {120, 129},
+	}
+	ranges := getHierarchy().prefixRanges
+	if len(ranges) != len(want) {
+		t.Fatalf("prefix table has %d ranges, want %d", len(ranges), len(want))
+	}
+	for name, bounds := range want {
+		if have, ok := ranges[name]; !ok || have.lo != bounds[0] || have.hi != bounds[1] {
+			t.Errorf("%s: range mismatch: have [%d, %d] (%v), want %v", name, have.lo, have.hi, ok, bounds)
+		}
+	}
+}
+
+func TestAllLocations(t *testing.T) {
+	want := []string{
+		"prime",
+		"cyprus", "cyprus1", "cyprus2", "cyprus3",
+		"paxos", "paxos1", "paxos2", "paxos3",
+		"hydra", "hydra1", "hydra2", "hydra3",
+	}
+	locs, names := AllLocations(), AllLocationNames()
+	if len(locs) != len(want) || len(names) != len(want) {
+		t.Fatalf("chain count mismatch: have %d locations and %d names, want %d", len(locs), len(names), len(want))
+	}
+	for i, loc := range locs {
+		if names[i] != want[i] || loc.Name() != want[i] {
+			t.Errorf("chain %d: have %s (%s), want %s", i, names[i], loc.Name(), want[i])
+		}
+		if loc.ID() != i {
+			t.Errorf("%s: id mismatch: have %d, want %d", want[i], loc.ID(), i)
+		}
+		if _, ok := getHierarchy().prefixRanges[names[i]]; !ok {
+			t.Errorf("%s: no address prefix range", names[i])
+		}
+	}
+	// The chains follow the hierarchy in use
+	t.Cleanup(func() { SetHierarchyParams(DefaultHierarchyParams) })
+	if err := SetHierarchyParams(HierarchyParams{Depth: 3, RegionsInPrime: 2, ZonesInRegion: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := strings.Join(AllLocationNames(), ","), "prime,cyprus,cyprus1,paxos,paxos1"; have != want {
+		t.Errorf("custom hierarchy: have %s, want %s", have, want)
+	}
+}
+
+func TestSetHierarchyParams(t *testing.T) {
+	t.Cleanup(func() {
+		if err := SetHierarchyParams(DefaultHierarchyParams); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := SetHierarchyParams(HierarchyParams{Depth: 3, RegionsInPrime: 2, ZonesInRegion: 4}); err != nil {
+		t.Fatal(err)
+	}
+	params := GetHierarchyParams()
+	if n := params.NumChains(); n != 11 {
+		t.Fatalf("chain count mismatch: have %d, want 11", n)
+	}
+	if n := len(Location{}.Descendants()); n != params.NumChains()-1 {
+		t.Errorf("prime has %d descendants, want %d", n, params.NumChains()-1)
+	}
+	if children := (Location{1}).Children(); len(children) != 4 || !children[3].Equal(Location{1, 3}) {
+		t.Errorf("region children mismatch: have %v", children)
+	}
+	if name := (Location{1, 3}).Name(); name != "paxos4" {
+		t.Errorf("zone name mismatch: have %s, want paxos4", name)
+	}
+	for _, loc := range []Location{{2}, {0, 4}, {0, 0, 0}} {
+		if err := loc.Validate(); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("%v: have %v, want %v", loc, err, ErrInvalidLocation)
+		}
+	}
+	for id := 0; id < params.NumChains(); id++ {
+		loc, _ := LocationFromID(id)
+		if loc.ID() != id {
+			t.Errorf("%s: id mismatch: have %d, want %d", loc.Name(), loc.ID(), id)
+		}
+		lo, hi, ok := PrefixRangeForLocation(loc)
+		if !ok || int(lo) != id*10 || int(hi) != id*10+9 {
+			t.Errorf("%s: range mismatch: have [%d, %d] (%v)", loc.Name(), lo, hi, ok)
+		}
+		if have, _ := LocationForAddressPrefix(lo); !have.Equal(loc) {
+			t.Errorf("%s: prefix %d owned by %v", loc.Name(), lo, have)
+		}
+	}
+	if loc, ok := LocationForAddressPrefix(110); ok {
+		t.Errorf("prefix 110 owned by %v", loc)
+	}
+	// A hierarchy without zones
+	if err := SetHierarchyParams(HierarchyParams{Depth: 2, RegionsInPrime: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Location{0, 0}).Validate(); !errors.Is(err, ErrInvalidLocation) {
+		t.Errorf("zone valid without zones: %v", err)
+	}
+	if children := (Location{4}).Children(); children != nil {
+		t.Errorf("region has children %v", children)
+	}
+	if id := (Location{4}).ID(); id != 5 {
+		t.Errorf("region id mismatch: have %d, want 5", id)
+	}
+	for _, bad := range []HierarchyParams{
+		{Depth: 0, RegionsInPrime: 3, ZonesInRegion: 3},
+		{Depth: 4, RegionsInPrime: 3, ZonesInRegion: 3},
+		{Depth: 3, RegionsInPrime: 0, ZonesInRegion: 3},
+		{Depth: 3, RegionsInPrime: 3, ZonesInRegion: 0},
+		{Depth: 3, RegionsInPrime: 4, ZonesInRegion: 3},
+	} {
+		if err := SetHierarchyParams(bad); !errors.Is(err, ErrInvalidHierarchy) {
+			t.Errorf("%+v: have %v, want %v", bad, err, ErrInvalidHierarchy)
+		}
+	}
+	if have := GetHierarchyParams(); have.Depth != 2 {
+		t.Errorf("rejected parameters were applied: %+v", have)
+	}
+}
+
+func TestAddressBig(t *testing.T) {
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 160), big.NewInt(1))
+	for _, n := range []*big.Int{new(big.Int), big.NewInt(1), big.NewInt(0x14ff), max} {
+		addr, err := BigToAddressChecked(n)
+		if err != nil {
+			t.Errorf("%v: %v", n, err)
+			continue
+		}
+		if addr != BigToAddress(n) {
+			t.Errorf("%v: checked %x, unchecked %x", n, addr, BigToAddress(n))
+		}
+		if back := addr.Big(); back.Cmp(n) != 0 {
+			t.Errorf("%v: round trip to %v", n, back)
+		}
+	}
+	addr := HexToAddress("0x14a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3")
+	if back := BigToAddress(addr.Big()); back != addr {
+		t.Errorf("round trip of %x: have %x", addr, back)
+	}
+	for _, n := range []*big.Int{new(big.Int).Add(max, big.NewInt(1)), new(big.Int).Lsh(max, 8), big.NewInt(-1)} {
+		if addr, err := BigToAddressChecked(n); !errors.Is(err, ErrAddressRange) {
+			t.Errorf("%v: have %x (%v), want %v", n, addr, err, ErrAddressRange)
+		}
+	}
+}
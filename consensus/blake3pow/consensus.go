@@ -21,7 +21,6 @@ import (
 
 // Blake3pow proof-of-work protocol constants.
 var (
-	maxUncles                     = 2         // Maximum number of uncles allowed in a single block
 	allowedFutureBlockTimeSeconds = int64(15) // Max seconds from current time allowed for blocks, before they're considered future blocks
 
 	ContextTimeFactor = big10
@@ -170,8 +169,8 @@ func (blake3pow *Blake3pow) VerifyUncles(chain consensus.ChainReader, block *typ
 	if blake3pow.config.PowMode == ModeFullFake {
 		return nil
 	}
-	// Verify that there are at most 2 uncles included in this block
-	if len(block.Uncles()) > maxUncles {
+	// Verify that there are at most params.MaxUnclesPerBlock uncles included in this block
+	if len(block.Uncles()) > params.MaxUnclesPerBlock {
 		return errTooManyUncles
 	}
 	if len(block.Uncles()) == 0 {
@@ -181,7 +180,9 @@ func (blake3pow *Blake3pow) VerifyUncles(chain consensus.ChainReader, block *typ
 	uncles, ancestors := mapset.NewSet(), make(map[common.Hash]*types.Header)
 
 	number, parent := block.NumberU64()-1, block.ParentHash()
-	for i := 0; i < 7; i++ {
+	// Uncles may be up to params.MaxUncleDepth generations below the block, so
+	// their parents are among its params.MaxUncleDepth+1 nearest ancestors
+	for i := uint64(0); i <= params.MaxUncleDepth; i++ {
 		ancestorHeader := chain.GetHeader(parent, number)
 		if ancestorHeader == nil {
 			break
@@ -295,7 +296,7 @@ func (blake3pow *Blake3pow) verifyHeader(chain consensus.ChainHeaderReader, head
 // the difficulty that a new block should have when created at time
 // given the parent block's time and difficulty.
 func (blake3pow *Blake3pow) CalcDifficulty(chain consensus.ChainHeaderReader, parent *types.Header) *big.Int {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 
 	// https://github.com/ethereum/EIPs/issues/100.
 	// algorithm:
@@ -344,7 +345,7 @@ func (blake3pow *Blake3pow) CalcDifficulty(chain consensus.ChainHeaderReader, pa
 }
 
 func (blake3pow *Blake3pow) IsDomCoincident(header *types.Header) bool {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 
 	// Since the Prime chain is the highest order, it cannot have coincident blocks
 	if nodeCtx > common.PRIME_CTX {
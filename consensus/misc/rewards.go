@@ -18,7 +18,7 @@ func CalculateReward() *big.Int {
 	timeFactor := big.NewInt(10)
 	regions := big.NewInt(3)
 	zones := big.NewInt(3)
-	context := common.NodeLocation.Context()
+	context := common.GetNodeLocation().Context()
 	if context == common.PRIME_CTX {
 		primeReward := big.NewInt(3)
 		primeReward.Div(reward, primeReward)
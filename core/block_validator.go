@@ -17,104 +17,781 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
+	"sync/atomic"
 
 	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/common/math"
 	"github.com/dominant-strategies/go-quai/consensus"
+	"github.com/dominant-strategies/go-quai/core/rawdb"
 	"github.com/dominant-strategies/go-quai/core/state"
 	"github.com/dominant-strategies/go-quai/core/types"
+	"github.com/dominant-strategies/go-quai/log"
+	"github.com/dominant-strategies/go-quai/metrics"
 	"github.com/dominant-strategies/go-quai/params"
 	"github.com/dominant-strategies/go-quai/trie"
 )
 
+// ValidationConfig contains the policy knobs of the BlockValidator which are
+// not part of the consensus-critical chain configuration.
+type ValidationConfig struct {
+	// ManifestContexts lists the contexts whose manifest hashes are checked by
+	// ValidateAllManifestHashes. Empty means every context the node can derive
+	// a manifest for, i.e. its own context and its subordinate's.
+	ManifestContexts []int
+
+	// GenesisOffsets maps a chain's location name (see common.Location.Name) to
+	// the block number of that chain's genesis. Every chain numbers its blocks
+	// independently in its own context slot of the header, so a zone block
+	// carries a prime, a region and a zone number. Chains missing from the map
+	// start numbering at zero.
+	GenesisOffsets map[string]uint64
+
+	// MaxDifficulty is the highest difficulty ValidateBody accepts before
+	// handing the block to the engine. Nil selects 2^256-1.
+	MaxDifficulty *big.Int
+
+	// MaxReorgDepth is the deepest reorganization, counted in blocks removed
+	// from the current head's chain, CheckReorgDepth allows. Zero disables the
+	// limit.
+	MaxReorgDepth uint64
+
+	// AllowZeroCoinbase disables the rejection of blocks paying their reward
+	// to the zero address, for chains which burn rewards on purpose.
+	AllowZeroCoinbase bool
+
+	// StreamingRootThreshold is the transaction count above which ValidateBody
+	// derives the transaction and external transaction roots with
+	// types.DeriveShaStream, encoding one transaction at a time. Zero always
+	// uses types.DeriveSha.
+	StreamingRootThreshold int
+
+	// LogEtxRollupEntries makes ValidateState log the hash of every entry of
+	// the collected ETX rollup at debug level when the rollup hash mismatches,
+	// to diagnose disputes over cross-chain ETXs.
+	LogEtxRollupEntries bool
+
+	// StrictEtxDestinations makes ValidateBody check that every external
+	// transaction of a block is destined outside of the node's slice, which
+	// costs a pass over the ETXs.
+	StrictEtxDestinations bool
+
+	// DiagnoseBloom makes ValidateState report how many log addresses and
+	// topics of the receipts are missing from a mismatching header bloom, see
+	// DiffBloom.
+	DiagnoseBloom bool
+
+	// SkipReceiptGasChecks disables the cross-checks of ValidateState that the
+	// cumulative gas used of the receipts never decreases and ends at the gas
+	// used by the block, for trusted local execution producing the receipts.
+	SkipReceiptGasChecks bool
+
+	// StrictTxScope makes ValidateBody check in zones that every transaction
+	// with a recipient, other than those emitting an ETX, is sent to an address
+	// within the zone, which costs a pass over the transactions.
+	StrictTxScope bool
+
+	// RecentKnownBlocks is the number of blocks found to be known, i.e. to
+	// have their block and state stored, that ValidateBody remembers, so that
+	// blocks seen again are rejected as known without a database lookup. All
+	// blocks are forgotten whenever the canonical chain is rolled back, since
+	// the rollback may delete their state. Zero disables the cache.
+	RecentKnownBlocks int
+
+	// BodyHooks are deployment specific checks run by ValidateBody and
+	// ValidateBodies, in order, once a block passed every built-in check. The
+	// first hook failing rejects the block with its error and the remaining
+	// hooks are skipped.
+	BodyHooks []BodyValidatorFunc
+
+	// StateHooks are deployment specific checks run by ValidateState, in
+	// order, once a block passed every built-in check including the state
+	// root. The first hook failing rejects the block, with its error reported
+	// at StateStageHooks, and the remaining hooks are skipped.
+	// ValidateStateShallow does not run them.
+	StateHooks []StateValidatorFunc
+}
+
+// BodyValidatorFunc is a custom check of a block body, see
+// ValidationConfig.BodyHooks.
+type BodyValidatorFunc func(block *types.Block) error
+
+// StateValidatorFunc is a custom check of a block's processed state and
+// receipts, see ValidationConfig.StateHooks. The state must not be modified.
+type StateValidatorFunc func(block *types.Block, statedb *state.StateDB, receipts types.Receipts) error
+
+// DefaultValidationConfig is the validation policy used when none is given.
+var DefaultValidationConfig = ValidationConfig{}
+
+// Checkpoint pins the hash, and optionally the state root, of the block at a
+// given height of the node's chain.
+type Checkpoint struct {
+	Number uint64
+	Hash   common.Hash
+	Root   common.Hash // Zero if the state root is not pinned
+}
+
+// Names of the per-chain counters a BlockValidator reports to its metrics sink.
+const (
+	blocksValidatedCounter    = "blocks_validated"
+	validationFailuresCounter = "validation_failures"
+)
+
+// stateFailureCounters names the counters of ValidateState failures, by the
+// stage that failed. They are reported in addition to validationFailuresCounter.
+var stateFailureCounters = map[string]string{
+	StateStageGas:       "state_failures/gas_used",
+	StateStageBloom:     "state_failures/bloom",
+	StateStageReceipts:  "state_failures/receipt_root",
+	StateStageEtxs:      "state_failures/etx_hash",
+	StateStageEtxRollup: "state_failures/etx_rollup_hash",
+	StateStageRoot:      "state_failures/state_root",
+	StateStageHooks:     "state_failures/hooks",
+}
+
+// ValidationMetricsSink receives the validation outcome counters of a
+// BlockValidator, broken down by the chain the validator validates for.
+type ValidationMetricsSink interface {
+	IncCounter(name string, loc common.Location)
+}
+
+// registryValidationMetrics is a ValidationMetricsSink reporting to a metrics
+// registry, as chain/validation/<location name>/<counter name>.
+type registryValidationMetrics struct {
+	registry metrics.Registry
+}
+
+// NewRegistryValidationMetrics returns a ValidationMetricsSink reporting to the
+// given registry. A nil registry selects metrics.DefaultRegistry.
+func NewRegistryValidationMetrics(registry metrics.Registry) ValidationMetricsSink {
+	return &registryValidationMetrics{registry: registry}
+}
+
+func (m *registryValidationMetrics) IncCounter(name string, loc common.Location) {
+	metrics.GetOrRegisterCounter("chain/validation/"+loc.Name()+"/"+name, m.registry).Inc(1)
+}
+
 // BlockValidator is responsible for validating block headers, uncles and
 // processed state.
 //
 // BlockValidator implements Validator.
 type BlockValidator struct {
-	config *params.ChainConfig // Chain configuration options
-	hc     *HeaderChain        // HeaderChain
-	engine consensus.Engine    // Consensus engine used for validating
+	config  *params.ChainConfig // Chain configuration options
+	vconfig *ValidationConfig   // Validation policy options
+	hc      *HeaderChain        // HeaderChain
+	engines EngineSelector      // Consensus engines used for validating, by context
+
+	location common.Location       // Chain the validation outcomes are reported for
+	metrics  ValidationMetricsSink // Sink for validation outcomes, nil if unreported
+
+	recentKnown    *common.RecentHashes // Blocks recently found known, nil if not remembered
+	knownRollbacks uint64               // Chain rollbacks recentKnown is up to date with (atomic)
+}
+
+// EngineSelector returns the consensus engine verifying blocks in the given
+// context. It must return an engine for every context of the hierarchy.
+type EngineSelector func(ctx int) consensus.Engine
+
+// ContextEngine is implemented by consensus engines which delegate the
+// verification of each context to a different engine, e.g. a lighter verifier
+// for regions than for zones. A BlockValidator constructed with a
+// ContextEngine selects engines through EngineForContext.
+type ContextEngine interface {
+	consensus.Engine
+	EngineForContext(ctx int) consensus.Engine
+}
+
+// engineSelector returns the selector of a ContextEngine, or a selector always
+// returning engine for any other engine.
+func engineSelector(engine consensus.Engine) EngineSelector {
+	if engine, ok := engine.(ContextEngine); ok {
+		return engine.EngineForContext
+	}
+	return func(int) consensus.Engine { return engine }
 }
 
 // NewBlockValidator returns a new block validator which is safe for re-use
 func NewBlockValidator(config *params.ChainConfig, headerChain *HeaderChain, engine consensus.Engine) *BlockValidator {
+	return NewBlockValidatorWithConfig(config, headerChain, engine, nil)
+}
+
+// NewBlockValidatorWithConfig returns a new block validator using the given
+// validation policy. A nil vconfig selects DefaultValidationConfig.
+func NewBlockValidatorWithConfig(config *params.ChainConfig, headerChain *HeaderChain, engine consensus.Engine, vconfig *ValidationConfig) *BlockValidator {
+	return NewBlockValidatorWithSelector(config, headerChain, engineSelector(engine), vconfig)
+}
+
+// NewBlockValidatorWithSelector returns a new block validator verifying the
+// blocks of each context with the engine engines selects for it when the
+// block is validated. A nil vconfig selects DefaultValidationConfig.
+func NewBlockValidatorWithSelector(config *params.ChainConfig, headerChain *HeaderChain, engines EngineSelector, vconfig *ValidationConfig) *BlockValidator {
+	if vconfig == nil {
+		defaults := DefaultValidationConfig
+		vconfig = &defaults
+	}
 	validator := &BlockValidator{
-		config: config,
-		engine: engine,
-		hc:     headerChain,
+		config:  config,
+		vconfig: vconfig,
+		engines: engines,
+		hc:      headerChain,
+	}
+	if vconfig.RecentKnownBlocks > 0 {
+		validator.recentKnown = common.NewRecentHashes(vconfig.RecentKnownBlocks)
 	}
 	return validator
 }
 
+// NewBlockValidatorForLocation returns a new block validator which reports the
+// outcome of validating blocks of the chain at loc to sink: every block failing
+// ValidateBody or ValidateState counts as a validation failure, and every block
+// passing ValidateState as validated. A nil sink disables reporting.
+func NewBlockValidatorForLocation(config *params.ChainConfig, headerChain *HeaderChain, engine consensus.Engine, vconfig *ValidationConfig, loc common.Location, sink ValidationMetricsSink) *BlockValidator {
+	validator := NewBlockValidatorWithConfig(config, headerChain, engine, vconfig)
+	validator.location = loc
+	validator.metrics = sink
+	return validator
+}
+
+// recordOutcome reports the outcome of a validation step to the metrics sink.
+// Only the final step reports successes, and already known blocks are not
+// reported at all. State validation failures are also counted by stage.
+func (v *BlockValidator) recordOutcome(err error, final bool) {
+	if v.metrics == nil {
+		return
+	}
+	switch {
+	case err == ErrKnownBlock:
+	case err != nil:
+		v.metrics.IncCounter(validationFailuresCounter, v.location)
+
+		var stageErr *StateCheckError
+		if errors.As(err, &stageErr) {
+			if name, ok := stateFailureCounters[stageErr.Stage]; ok {
+				v.metrics.IncCounter(name, v.location)
+			}
+		}
+	case final:
+		v.metrics.IncCounter(blocksValidatedCounter, v.location)
+	}
+}
+
 // ValidateBody validates the given block's uncles and verifies the block
 // header's transaction and uncle roots. The headers are assumed to be already
-// validated at this point.
+// validated at this point. The configured body hooks run last, only for blocks
+// passing every built-in check.
 func (v *BlockValidator) ValidateBody(block *types.Block) error {
-	nodeCtx := common.NodeLocation.Context()
+	err := v.validateBody(block, newBodyBatch())
+	v.recordOutcome(err, false)
+	return err
+}
+
+// ValidateBodies validates a sequence of blocks, e.g. consecutive blocks
+// downloaded during sync, and returns the ValidateBody result of every block
+// at its index. Blocks are processed in order and share a trie hasher, and
+// the lookups of whether a block is already known are reused when its child
+// later checks its ancestor. Validation does not import blocks, so a child of
+// an unknown block in the batch fails with its ancestor unknown, exactly like
+// it would individually.
+func (v *BlockValidator) ValidateBodies(blocks []*types.Block) []error {
+	batch := newBodyBatch()
+	errs := make([]error, len(blocks))
+	for i, block := range blocks {
+		errs[i] = v.validateBody(block, batch)
+		v.recordOutcome(errs[i], false)
+	}
+	return errs
+}
+
+// bodyBatch holds the state shared by the body validations of a batch.
+type bodyBatch struct {
+	hasher *trie.StackTrie
+	known  map[common.Hash]bool // Memoized HasBlockAndState lookups
+}
+
+func newBodyBatch() *bodyBatch {
+	return &bodyBatch{
+		hasher: trie.NewStackTrie(nil),
+		known:  make(map[common.Hash]bool),
+	}
+}
+
+// hasBlockAndState is the processor's HasBlockAndState, memoized for the
+// duration of the batch. Known blocks are also remembered across batches if
+// the validator keeps track of recently known blocks, until the canonical
+// chain is rolled back.
+func (v *BlockValidator) hasBlockAndState(batch *bodyBatch, hash common.Hash, number uint64) bool {
+	if known, ok := batch.known[hash]; ok {
+		return known
+	}
+	if v.recentKnown != nil {
+		// Blocks rolled back may have lost their state, forget them all
+		if rollbacks := v.hc.Rollbacks(); atomic.SwapUint64(&v.knownRollbacks, rollbacks) != rollbacks {
+			v.recentKnown.Reset()
+		}
+		if v.recentKnown.Seen(hash) {
+			batch.known[hash] = true
+			return true
+		}
+	}
+	known := v.hc.bc.processor.HasBlockAndState(hash, number)
+	batch.known[hash] = known
+	if known && v.recentKnown != nil {
+		v.recentKnown.Add(hash)
+	}
+	return known
+}
+
+func (v *BlockValidator) validateBody(block *types.Block, batch *bodyBatch) error {
+	nodeCtx := common.GetNodeLocation().Context()
+	// Reject malformed locations before anything relies on them
+	if _, err := block.Location().ContextErr(); err != nil {
+		return err
+	}
 	// Check whether the block's known, and if not, that it's linkable
-	if v.hc.bc.processor.HasBlockAndState(block.Hash(), block.NumberU64()) {
+	if v.hasBlockAndState(batch, block.Hash(), block.NumberU64()) {
 		return ErrKnownBlock
 	}
-	// Header validity is known at this point, check the uncles and transactions
+	// Every block but genesis carries a reward, which must not be burnt
+	if !v.vconfig.AllowZeroCoinbase && block.NumberU64() > 0 && block.Coinbase().IsZero() {
+		return ErrZeroCoinbase
+	}
+	// Fail fast on obviously malformed difficulties
+	if err := v.validateDifficultyBounds(block.Difficulty()); err != nil {
+		return err
+	}
+	// Header validity is known at this point, check the transactions first, as
+	// their roots are cheap to derive compared to verifying the uncles
 	header := block.Header()
-	if err := v.engine.VerifyUncles(v.hc, block); err != nil {
+	if hash := v.deriveBodyRoot(block.Transactions(), batch.hasher); hash != header.TxHash() {
+		return &HashMismatchError{Err: ErrTxRootMismatch, Have: hash, Want: header.TxHash()}
+	}
+	if hash := v.deriveBodyRoot(block.ExtTransactions(), batch.hasher); hash != header.EtxHash() {
+		return &HashMismatchError{Err: ErrEtxRootMismatch, Have: hash, Want: header.EtxHash()}
+	}
+	if err := validateUncleUniqueness(block); err != nil {
+		return err
+	}
+	if err := v.engines(nodeCtx).VerifyUncles(v.hc, block); err != nil {
+		return err
+	}
+	if err := v.ValidateUncleSlices(block); err != nil {
+		return err
+	}
+	if err := validateUncleBounds(block); err != nil {
 		return err
 	}
 	if hash := types.CalcUncleHash(block.Uncles()); hash != header.UncleHash() {
-		return fmt.Errorf("uncle root hash mismatch: have %x, want %x", hash, header.UncleHash())
+		return &HashMismatchError{Err: ErrUncleRootMismatch, Have: hash, Want: header.UncleHash()}
 	}
-	if hash := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); hash != header.TxHash() {
-		return fmt.Errorf("transaction root hash mismatch: have %x, want %x", hash, header.TxHash())
+	if v.vconfig.StrictTxScope && nodeCtx == common.ZONE_CTX {
+		if err := validateTxScope(block.Transactions()); err != nil {
+			return err
+		}
 	}
-	if hash := types.DeriveSha(block.ExtTransactions(), trie.NewStackTrie(nil)); hash != header.EtxHash() {
-		return fmt.Errorf("external transaction root hash mismatch: have %x, want %x", hash, header.EtxHash())
+	if v.vconfig.StrictEtxDestinations {
+		if err := validateEtxDestinations(block.ExtTransactions(), common.GetNodeLocation()); err != nil {
+			return err
+		}
 	}
 	// Subordinate manifest must match ManifestHash in subordinate context, _iff_
 	// we have a subordinate (i.e. if we are not a zone)
-	if nodeCtx < common.ZONE_CTX {
-		subManifestHash := types.DeriveSha(block.SubManifest(), trie.NewStackTrie(nil))
-		if subManifestHash == types.EmptyRootHash || subManifestHash != header.ManifestHash(nodeCtx+1) {
+	if want, ok := SubManifestHash(header, nodeCtx); ok {
+		// The subordinate blocks are mostly not stored locally, so only what
+		// the manifest itself tells is checked, not the order of the blocks
+		if err := validateSubManifestUnique(block.SubManifest()); err != nil {
+			return err
+		}
+		subManifestHash := types.DeriveSha(block.SubManifest(), batch.hasher)
+		if subManifestHash == types.EmptyRootHash || subManifestHash != want {
 			// If we have a subordinate chain, it is impossible for the subordinate manifest to be empty
-			return ErrBadSubManifest
+			return &HashMismatchError{Err: ErrBadSubManifest, Have: subManifestHash, Want: want}
+		}
+	}
+	if parent := v.hc.GetHeader(block.ParentHash(), block.NumberU64()-1); parent != nil {
+		if err := validateParentLocation(parent, block.Header(), nodeCtx); err != nil {
+			return err
 		}
 	}
-	if !v.hc.bc.processor.HasBlockAndState(block.ParentHash(), block.NumberU64()-1) {
+	if !v.hasBlockAndState(batch, block.ParentHash(), block.NumberU64()-1) {
 		if !v.hc.bc.HasBlock(block.ParentHash(), block.NumberU64()-1) {
 			return consensus.ErrUnknownAncestor
 		}
 		return consensus.ErrPrunedAncestor
 	}
+	if err := v.ValidateBlockNumbering(block); err != nil {
+		return err
+	}
+	// Custom checks only ever see blocks passing the built-in ones
+	for _, hook := range v.vconfig.BodyHooks {
+		if err := hook(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SubManifestHash returns the manifest hash header commits to for the
+// subordinate chain of a node in context nodeCtx, i.e. the hash of the block's
+// subordinate manifest. Zones, and contexts at or below the depth of the
+// hierarchy, have no subordinate and report false.
+func SubManifestHash(header *types.Header, nodeCtx int) (common.Hash, bool) {
+	if nodeCtx < common.PRIME_CTX || nodeCtx+1 >= common.GetHierarchyParams().Depth {
+		return common.Hash{}, false
+	}
+	return header.ManifestHash(nodeCtx + 1), true
+}
+
+// validateSubManifestUnique checks that a subordinate manifest lists no block
+// twice.
+func validateSubManifestUnique(manifest types.BlockManifest) error {
+	seen := common.NewHashSet()
+	for i, hash := range manifest {
+		if seen.Contains(hash) {
+			return fmt.Errorf("%w: entry %d is %x", ErrManifestDuplicate, i, hash)
+		}
+		seen.Add(hash)
+	}
+	return nil
+}
+
+// deriveBodyRoot computes the root of a transaction list of a block body,
+// streaming the list into the trie if it exceeds the configured threshold.
+func (v *BlockValidator) deriveBodyRoot(txs types.Transactions, hasher types.TrieHasher) common.Hash {
+	if threshold := v.vconfig.StreamingRootThreshold; threshold > 0 && len(txs) > threshold {
+		return types.DeriveShaStream(types.EncodedItems(txs), hasher)
+	}
+	return types.DeriveSha(txs, hasher)
+}
+
+// validateEtxDestinations checks that every external transaction is destined
+// to an address outside of the slice of loc, since transfers within the slice
+// have no business crossing chains.
+func validateEtxDestinations(etxs types.Transactions, loc common.Location) error {
+	for i, etx := range etxs {
+		to := etx.To()
+		if to == nil {
+			return fmt.Errorf("%w: etx %d %x has no recipient", ErrEtxNotCrossChain, i, etx.Hash())
+		}
+		dest := to.Location()
+		if dest == nil {
+			return fmt.Errorf("%w: etx %d %x to unroutable address %x", ErrEtxNotCrossChain, i, etx.Hash(), *to)
+		}
+		if dest.InSameSliceAs(loc) {
+			return fmt.Errorf("%w: etx %d %x to %s, node at %s", ErrEtxNotCrossChain, i, etx.Hash(), dest.Name(), loc.Name())
+		}
+	}
+	return nil
+}
+
+// validateTxScope checks that every transaction with a recipient is sent to an
+// address in the node's chain scope. Transactions emitting an ETX are sent
+// across chains by design and are skipped.
+func validateTxScope(txs types.Transactions) error {
+	for i, tx := range txs {
+		if tx.Type() == types.InternalToExternalTxType {
+			continue
+		}
+		if to := tx.To(); to != nil && !to.IsInChainScope() {
+			return fmt.Errorf("%w: tx %d %x to %x", ErrTxOutOfScope, i, tx.Hash(), *to)
+		}
+	}
+	return nil
+}
+
+// validateDifficultyBounds checks that difficulty is positive and does not
+// exceed the configured maximum. It is a cheap pre-filter only, the engine
+// verifies the difficulty itself.
+func (v *BlockValidator) validateDifficultyBounds(difficulty *big.Int) error {
+	if difficulty == nil || difficulty.Sign() <= 0 {
+		return ErrZeroDifficulty
+	}
+	max := v.vconfig.MaxDifficulty
+	if max == nil {
+		max = math.MaxBig256
+	}
+	if difficulty.Cmp(max) > 0 {
+		return fmt.Errorf("%w: have %v, max %v", ErrDifficultyTooHigh, difficulty, max)
+	}
+	return nil
+}
+
+// validateParentLocation checks that parent belongs to the same chain as
+// header in the given context. Blocks of a dominant chain originate in any of
+// its subordinates, so only the location prefix naming the chain has to match,
+// which for a zone is the whole location. Genesis is shared by every chain and
+// carries no location, so any block may descend from it.
+func validateParentLocation(parent, header *types.Header, ctx int) error {
+	if parent.NumberU64(ctx) == 0 {
+		return nil
+	}
+	parentLoc, loc := parent.Location(), header.Location()
+	if len(parentLoc) < ctx || len(loc) < ctx || !parentLoc[:ctx].Equal(loc[:ctx]) {
+		return fmt.Errorf("%w: parent %x at %v, block at %v", ErrParentWrongLocation, parent.Hash(), parentLoc, loc)
+	}
+	return nil
+}
+
+// CheckEquivocation reports whether a different block with the same parent and
+// the same timestamp as the given block is already known, which indicates the
+// producer equivocated. Siblings with different timestamps are ordinary forks
+// and not reported. The returned ErrEquivocation names both blocks.
+func (v *BlockValidator) CheckEquivocation(block *types.Block) error {
+	number := block.NumberU64()
+	for _, hash := range rawdb.ReadAllHashes(v.hc.headerDb, number) {
+		if hash == block.Hash() {
+			continue
+		}
+		sibling := v.hc.GetHeader(hash, number)
+		if sibling == nil {
+			continue
+		}
+		if sibling.ParentHash() == block.ParentHash() && sibling.Time() == block.Time() {
+			return fmt.Errorf("%w: %x and %x at number %d", ErrEquivocation, hash, block.Hash(), number)
+		}
+	}
+	return nil
+}
+
+// CheckReorgDepth checks that switching from currentHead to the chain ending in
+// block would not remove more than MaxReorgDepth blocks from the current chain.
+// The common ancestor of both chains is found by walking back the header chain
+// from the block's parent, which must be known.
+func (v *BlockValidator) CheckReorgDepth(block *types.Block, currentHead *types.Block) error {
+	limit := v.vconfig.MaxReorgDepth
+	if limit == 0 || block.NumberU64() == 0 {
+		return nil
+	}
+	head := currentHead.Header()
+	side := v.hc.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if side == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	// Bring both chains to the same height, then walk back in lockstep
+	for head != nil && head.NumberU64() > side.NumberU64() {
+		head = v.hc.GetHeader(head.ParentHash(), head.NumberU64()-1)
+	}
+	for side != nil && side.NumberU64() > head.NumberU64() {
+		side = v.hc.GetHeader(side.ParentHash(), side.NumberU64()-1)
+	}
+	for head != nil && side != nil && head.Hash() != side.Hash() {
+		if head.NumberU64() == 0 {
+			return fmt.Errorf("%w: no common ancestor with head %x", ErrReorgTooDeep, currentHead.Hash())
+		}
+		head = v.hc.GetHeader(head.ParentHash(), head.NumberU64()-1)
+		side = v.hc.GetHeader(side.ParentHash(), side.NumberU64()-1)
+	}
+	if head == nil || side == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	if depth := currentHead.NumberU64() - head.NumberU64(); depth > limit {
+		return fmt.Errorf("%w: depth %d, limit %d, ancestor %x", ErrReorgTooDeep, depth, limit, head.Hash())
+	}
+	return nil
+}
+
+// ValidateUncleSlices checks that every uncle of the block originates from
+// the same vertical slice as the block itself.
+func (v *BlockValidator) ValidateUncleSlices(block *types.Block) error {
+	for _, uncle := range block.Uncles() {
+		if _, err := uncle.Location().ContextErr(); err != nil {
+			return fmt.Errorf("uncle %x: %w", uncle.Hash(), err)
+		}
+		if !uncle.Location().InSameSliceAs(block.Location()) {
+			return fmt.Errorf("%w: uncle %x at %s, block at %s", ErrUncleWrongSlice, uncle.Hash(), uncle.Location().Name(), block.Location().Name())
+		}
+	}
+	return nil
+}
+
+// validateUncleUniqueness checks that no uncle is listed twice and that the
+// block does not include itself as an uncle, independently of the engine.
+func validateUncleUniqueness(block *types.Block) error {
+	seen := common.NewHashSet(block.Hash())
+	for _, uncle := range block.Uncles() {
+		hash := uncle.Hash()
+		if seen.Contains(hash) {
+			return fmt.Errorf("%w: %x", ErrDuplicateUncle, hash)
+		}
+		seen.Add(hash)
+	}
+	return nil
+}
+
+// validateUncleBounds checks the uncle count and depth limits independently of
+// the engine's uncle verification. Uncles must be between 1 and
+// params.MaxUncleDepth generations below the block.
+func validateUncleBounds(block *types.Block) error {
+	uncles := block.Uncles()
+	if len(uncles) > params.MaxUnclesPerBlock {
+		return fmt.Errorf("%w: have %d, max %d", ErrTooManyUncles, len(uncles), params.MaxUnclesPerBlock)
+	}
+	number := block.NumberU64()
+	for _, uncle := range uncles {
+		if uncle.NumberU64() >= number || number-uncle.NumberU64() > params.MaxUncleDepth {
+			return fmt.Errorf("%w: uncle %x at %d, block at %d, max depth %d", ErrUncleDepth, uncle.Hash(), uncle.NumberU64(), number, params.MaxUncleDepth)
+		}
+	}
+	return nil
+}
+
+// ValidateAllManifestHashes checks the manifest hashes of every tracked
+// context in a single pass. The manifest committed in the node's own context
+// is recollected from the header chain, and the one committed in the
+// subordinate context is derived from the block's sub manifest. Contexts the
+// node cannot derive a manifest for are skipped. The first mismatch is
+// returned, naming the context it was found in.
+func (v *BlockValidator) ValidateAllManifestHashes(block *types.Block) error {
+	nodeCtx := common.GetNodeLocation().Context()
+	header := block.Header()
+
+	contexts := v.vconfig.ManifestContexts
+	if len(contexts) == 0 {
+		contexts = []int{nodeCtx, nodeCtx + 1}
+	}
+	subManifestHash, hasSub := SubManifestHash(header, nodeCtx)
+	for _, ctx := range contexts {
+		var (
+			manifest types.BlockManifest
+			want     common.Hash
+			errBad   error
+		)
+		switch {
+		case ctx == nodeCtx && ctx > common.PRIME_CTX:
+			collected, err := v.hc.CollectBlockManifest(header)
+			if err != nil {
+				return err
+			}
+			manifest, want, errBad = collected, header.ManifestHash(ctx), ErrBadManifest
+		case ctx == nodeCtx+1 && hasSub:
+			manifest, want, errBad = block.SubManifest(), subManifestHash, ErrBadSubManifest
+		default:
+			continue
+		}
+		if hash := types.DeriveSha(manifest, trie.NewStackTrie(nil)); hash != want {
+			return fmt.Errorf("%w: context %d: have %x, want %x", errBad, ctx, hash, want)
+		}
+	}
+	return nil
+}
+
+// ValidateAgainstCheckpoint rejects a block at the checkpoint's height whose
+// hash, or state root if pinned, differs from the checkpoint. Blocks at any
+// other height are not affected by the checkpoint.
+func (v *BlockValidator) ValidateAgainstCheckpoint(block *types.Block, checkpoint Checkpoint) error {
+	if block.NumberU64() != checkpoint.Number {
+		return nil
+	}
+	if hash := block.Hash(); hash != checkpoint.Hash {
+		return fmt.Errorf("%w: block %d hash: have %x, want %x", ErrCheckpointMismatch, checkpoint.Number, hash, checkpoint.Hash)
+	}
+	if !checkpoint.Root.IsZero() && block.Root() != checkpoint.Root {
+		return fmt.Errorf("%w: block %d state root: have %x, want %x", ErrCheckpointMismatch, checkpoint.Number, block.Root(), checkpoint.Root)
+	}
+	return nil
+}
+
+// ValidateBlockNumbering checks that the block's number in every context its
+// location participates in is not below the genesis offset of the chain it is
+// numbered against. For a zone block that means the prime number is checked
+// against prime's offset, the region number against its region's offset and
+// the zone number against the zone's own offset. ValidateBody runs it once the
+// block's ancestor is known.
+func (v *BlockValidator) ValidateBlockNumbering(block *types.Block) error {
+	location := block.Location()
+	locationCtx, err := location.ContextErr()
+	if err != nil {
+		return err
+	}
+	for ctx := common.PRIME_CTX; ctx <= locationCtx; ctx++ {
+		chain := location[:ctx]
+		offset := v.vconfig.GenesisOffsets[chain.Name()]
+		if number := block.Number(ctx); number == nil || number.Uint64() < offset {
+			return fmt.Errorf("%w: %s number %v below genesis offset %d", ErrBadBlockNumber, chain.Name(), number, offset)
+		}
+	}
 	return nil
 }
 
 // ValidateState validates the various changes that happen after a state
 // transition, such as amount of used gas, the receipt roots and the state root
-// itself. ValidateState returns a database batch if the validation was a success
-// otherwise nil and an error is returned.
+// itself. The checks run cheapest first and stop at the first failure, which is
+// returned as a *StateCheckError naming the failed stage. The state root, by
+// far the most expensive check, is only computed once everything else passed.
+// The configured state hooks run last, after the state root.
 func (v *BlockValidator) ValidateState(block *types.Block, statedb *state.StateDB, receipts types.Receipts, usedGas uint64) error {
+	_, err := v.ValidateStateWithRoot(block, statedb, receipts, usedGas)
+	return err
+}
+
+// ValidateStateWithRoot is ValidateState which additionally returns the state
+// root computed from statedb, so that callers can reuse it instead of walking
+// the trie again. The root is returned whenever it was computed, i.e. also on
+// a state root mismatch or a failing state hook, and is zero if validation
+// failed before reaching the state root.
+func (v *BlockValidator) ValidateStateWithRoot(block *types.Block, statedb *state.StateDB, receipts types.Receipts, usedGas uint64) (common.Hash, error) {
+	root, err := v.validateState(block, statedb, receipts, usedGas)
+	v.recordOutcome(err, true)
+	return root, err
+}
+
+// ValidateStateShallow runs the checks of ValidateState except for the state
+// root, i.e. those which only depend on the receipts and gas used. It is meant
+// for cheaply rejecting obviously bad blocks, e.g. during sync; passing it does
+// not make a block valid.
+func (v *BlockValidator) ValidateStateShallow(block *types.Block, receipts types.Receipts, usedGas uint64) error {
+	err := v.validateStateShallow(block, receipts, usedGas)
+	v.recordOutcome(err, false)
+	return err
+}
+
+func (v *BlockValidator) validateState(block *types.Block, statedb *state.StateDB, receipts types.Receipts, usedGas uint64) (common.Hash, error) {
+	if err := v.validateStateShallow(block, receipts, usedGas); err != nil {
+		return common.Hash{}, err
+	}
+	// Validate the state root against the received state root and throw
+	// an error if they don't match.
+	header := block.Header()
+	root, err := v.ComputeExpectedRoot(statedb, header.Number())
+	if err != nil {
+		return common.Hash{}, &StateCheckError{Stage: StateStageRoot, Err: err}
+	}
+	if header.Root() != root {
+		return root, &StateCheckError{Stage: StateStageRoot, Err: fmt.Errorf("invalid merkle root (remote: %x local: %x)", header.Root(), root)}
+	}
+	for _, hook := range v.vconfig.StateHooks {
+		if err := hook(block, statedb, receipts); err != nil {
+			return root, &StateCheckError{Stage: StateStageHooks, Err: err}
+		}
+	}
+	return root, nil
+}
+
+func (v *BlockValidator) validateStateShallow(block *types.Block, receipts types.Receipts, usedGas uint64) error {
 	header := block.Header()
 	if block.GasUsed() != usedGas {
-		return fmt.Errorf("invalid gas used (remote: %d local: %d)", block.GasUsed(), usedGas)
+		return &StateCheckError{Stage: StateStageGas, Err: fmt.Errorf("invalid gas used (remote: %d local: %d)", block.GasUsed(), usedGas)}
+	}
+	if !v.vconfig.SkipReceiptGasChecks {
+		if err := validateReceiptGas(receipts, usedGas); err != nil {
+			return &StateCheckError{Stage: StateStageGas, Err: err}
+		}
 	}
 	// Validate the received block's bloom with the one derived from the generated receipts.
 	// For valid blocks this should always validate to true.
 	rbloom := types.CreateBloom(receipts)
 	if rbloom != header.Bloom() {
-		return fmt.Errorf("invalid bloom (remote: %x  local: %x)", header.Bloom(), rbloom)
+		err := fmt.Errorf("invalid bloom (remote: %x  local: %x)", header.Bloom(), rbloom)
+		if v.vconfig.DiagnoseBloom {
+			err = fmt.Errorf("%v, %d receipt log entries missing from remote", err, len(DiffBloom(receipts, header.Bloom())))
+		}
+		return &StateCheckError{Stage: StateStageBloom, Err: err}
 	}
 	// Tre receipt Trie's root (R = (Tr [[H1, R1], ... [Hn, Rn]]))
 	receiptSha := types.DeriveSha(receipts, trie.NewStackTrie(nil))
 	if receiptSha != header.ReceiptHash() {
-		return fmt.Errorf("invalid receipt root hash (remote: %x local: %x)", header.ReceiptHash(), receiptSha)
-	}
-	// Validate the state root against the received state root and throw
-	// an error if they don't match.
-	if root, err := statedb.IntermediateRoot(v.config.IsEIP158(header.Number())); header.Root() != root || err != nil {
-		if err != nil {
-			return err
-		}
-		return fmt.Errorf("invalid merkle root (remote: %x local: %x)", header.Root(), root)
+		return &StateCheckError{Stage: StateStageReceipts, Err: fmt.Errorf("invalid receipt root hash (remote: %x local: %x)", header.ReceiptHash(), receiptSha)}
 	}
 	// Collect ETXs emitted from each successful transaction
 	var emittedEtxs types.Transactions
@@ -126,34 +803,264 @@ func (v *BlockValidator) ValidateState(block *types.Block, statedb *state.StateD
 		}
 	}
 	// Confirm the ETXs emitted by the transactions in this block exactly match the
-	// ETXs given in the block body
+	// ETXs given in the block body, naming the first difference before
+	// comparing the root
+	if err := compareEmittedEtxs(emittedEtxs, block.ExtTransactions()); err != nil {
+		return &StateCheckError{Stage: StateStageEtxs, Err: err}
+	}
 	if etxHash := types.DeriveSha(emittedEtxs, trie.NewStackTrie(nil)); etxHash != header.EtxHash() {
-		return fmt.Errorf("invalid etx hash (remote: %x local: %x)", header.EtxHash(), etxHash)
+		return &StateCheckError{Stage: StateStageEtxs, Err: fmt.Errorf("invalid etx hash (remote: %x local: %x)", header.EtxHash(), etxHash)}
+	}
+	if err := v.ValidateEtxValueConservation(block, receipts); err != nil {
+		return &StateCheckError{Stage: StateStageEtxs, Err: err}
 	}
 	// Collect the ETX rollup with emitted ETXs since the last coincident block,
 	// excluding this block.
 	etxRollup, err := v.hc.CollectEtxRollup(block)
 	if err != nil {
-		return fmt.Errorf("unable to get ETX rollup")
+		// Point at the rollup window which could not be walked
+		if _, boundaryErr := v.hc.LastCoincidentBlock(block); boundaryErr != nil {
+			err = boundaryErr
+		}
+		return &StateCheckError{Stage: StateStageEtxRollup, Err: fmt.Errorf("unable to get ETX rollup: %w", err)}
 	}
 	if etxRollupHash := types.DeriveSha(etxRollup, trie.NewStackTrie(nil)); etxRollupHash != header.EtxRollupHash() {
-		return fmt.Errorf("invalid etx rollup hash (remote: %x local: %x)", header.EtxRollupHash(), etxRollupHash)
+		if v.vconfig.LogEtxRollupEntries {
+			for i, etx := range etxRollup {
+				log.Debug("ETX rollup entry", "block", block.Hash(), "index", i, "hash", etx.Hash())
+			}
+		}
+		return &StateCheckError{Stage: StateStageEtxRollup, Err: &EtxRollupMismatchError{Have: etxRollupHash, Want: header.EtxRollupHash(), RollupLen: len(etxRollup)}}
+	}
+	return nil
+}
+
+// validateReceiptGas checks that the cumulative gas used of the receipts never
+// decreases and that the last receipt accounts for all of usedGas.
+func validateReceiptGas(receipts types.Receipts, usedGas uint64) error {
+	var cumulative uint64
+	for i, receipt := range receipts {
+		if receipt.CumulativeGasUsed < cumulative {
+			return fmt.Errorf("%w: receipt %d at %d, previous at %d", ErrReceiptGasNonMonotonic, i, receipt.CumulativeGasUsed, cumulative)
+		}
+		cumulative = receipt.CumulativeGasUsed
+	}
+	if cumulative != usedGas {
+		return fmt.Errorf("%w: receipts %d, block %d", ErrReceiptGasMismatch, cumulative, usedGas)
+	}
+	return nil
+}
+
+// compareEmittedEtxs checks that the ETXs emitted by a block's transactions
+// are the block's ETXs, in the same order, returning the first difference.
+func compareEmittedEtxs(emitted, included types.Transactions) error {
+	for i := 0; i < len(emitted) || i < len(included); i++ {
+		switch {
+		case i >= len(included):
+			return fmt.Errorf("%w: etx %d %x emitted but not included", ErrEtxOrderMismatch, i, emitted[i].Hash())
+		case i >= len(emitted):
+			return fmt.Errorf("%w: etx %d %x included but not emitted", ErrEtxOrderMismatch, i, included[i].Hash())
+		case emitted[i].Hash() != included[i].Hash():
+			return fmt.Errorf("%w: etx %d is %x, emitted %x", ErrEtxOrderMismatch, i, included[i].Hash(), emitted[i].Hash())
+		}
+	}
+	return nil
+}
+
+// DiffBloom recomputes the bloom of receipts and returns the log addresses and
+// topics it contains which headerBloom lacks, without duplicates and in receipt
+// order. Addresses are returned left-padded to hashes. Bits set in headerBloom
+// but not in the recomputed bloom cannot be traced back to log entries, so a
+// header bloom which is a strict superset yields an empty result.
+func DiffBloom(receipts types.Receipts, headerBloom types.Bloom) []common.Hash {
+	var (
+		missing []common.Hash
+		seen    = make(map[string]struct{})
+	)
+	check := func(entry common.Hash, data []byte) {
+		if _, ok := seen[string(data)]; ok {
+			return
+		}
+		seen[string(data)] = struct{}{}
+		if !headerBloom.Test(data) {
+			missing = append(missing, entry)
+		}
+	}
+	for _, receipt := range receipts {
+		for _, receiptLog := range receipt.Logs {
+			check(common.BytesToHash(receiptLog.Address.Bytes()), receiptLog.Address.Bytes())
+			for _, topic := range receiptLog.Topics {
+				check(topic, topic.Bytes())
+			}
+		}
+	}
+	return missing
+}
+
+// ValidateManifestEtxConsistency checks that the block includes exactly the
+// ETXs promised by its subordinate manifest, i.e. the ETXs the subordinate
+// blocks listed in the manifest emitted into the subordinate context, in
+// manifest order. The pending ETXs of every manifest entry must be known. The
+// first discrepancy is returned. Zone blocks have no subordinate manifest and
+// always pass.
+func (v *BlockValidator) ValidateManifestEtxConsistency(block *types.Block) error {
+	nodeCtx := common.GetNodeLocation().Context()
+	if nodeCtx == common.ZONE_CTX {
+		return nil
+	}
+	var promised types.Transactions
+	for _, hash := range block.SubManifest() {
+		pendingEtxs := rawdb.ReadPendingEtxs(v.hc.headerDb, hash)
+		if pendingEtxs == nil || len(pendingEtxs.Etxs) <= nodeCtx+1 {
+			return fmt.Errorf("%w: manifest entry %x", ErrPendingEtxNotFound, hash)
+		}
+		promised = append(promised, pendingEtxs.Etxs[nodeCtx+1]...)
+	}
+	included := block.ExtTransactions()
+	for i, etx := range promised {
+		if i >= len(included) {
+			return fmt.Errorf("%w: promised etx %x missing at index %d", ErrManifestEtxMismatch, etx.Hash(), i)
+		}
+		if included[i].Hash() != etx.Hash() {
+			return fmt.Errorf("%w: etx %d is %x, promised %x", ErrManifestEtxMismatch, i, included[i].Hash(), etx.Hash())
+		}
+	}
+	if len(included) > len(promised) {
+		return fmt.Errorf("%w: unpromised etx %x at index %d", ErrManifestEtxMismatch, included[len(promised)].Hash(), len(promised))
+	}
+	return nil
+}
+
+// ValidateCoincidentEtxReset checks that the ETX rollup restarts across a
+// coincident boundary. The rollup committed by a block collects the ETXs
+// emitted since the last dom coincident ancestor, including that ancestor's
+// own. If the block's parent is coincident (or genesis), the rollup therefore
+// consists of the parent's ETXs alone and EtxRollupHash must equal the
+// parent's EtxHash. Blocks following non-coincident parents are not checked.
+func (v *BlockValidator) ValidateCoincidentEtxReset(block *types.Block) error {
+	parent := v.hc.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.NumberU64() != 0 && !v.engines(common.GetNodeLocation().Context()).IsDomCoincident(parent) {
+		return nil
+	}
+	if block.EtxRollupHash() != parent.EtxHash() {
+		return fmt.Errorf("%w: rollup %x, coincident parent %x emitted %x", ErrEtxRollupNotReset, block.EtxRollupHash(), parent.Hash(), parent.EtxHash())
+	}
+	return nil
+}
+
+// ValidateEtxValueConservation checks that value is conserved across the
+// cross-chain boundary: every successful internal-to-external transaction
+// debits its value from the sender and must emit ETXs carrying exactly that
+// value. ETXs emitted by contracts are paid from the contract's balance rather
+// than the transaction's value, so they are not covered by this check.
+func (v *BlockValidator) ValidateEtxValueConservation(block *types.Block, receipts types.Receipts) error {
+	txs := block.Transactions()
+	if len(receipts) != len(txs) {
+		return fmt.Errorf("receipt count mismatch: have %d, want %d", len(receipts), len(txs))
+	}
+	for i, tx := range txs {
+		if tx.Type() != types.InternalToExternalTxType || receipts[i].Status != types.ReceiptStatusSuccessful {
+			continue
+		}
+		emitted := new(big.Int)
+		for _, etx := range receipts[i].Etxs {
+			emitted.Add(emitted, etx.Value())
+		}
+		if emitted.Cmp(tx.Value()) != 0 {
+			return fmt.Errorf("%w: tx %x debited %v, emitted %v", ErrEtxValueImbalance, tx.Hash(), tx.Value(), emitted)
+		}
+	}
+	return nil
+}
+
+// ValidateGenesisState checks the state roots of a genesis block against the
+// roots computed from the genesis specification's allocation. Unlike for any
+// other block, the header root of the genesis block cannot be verified by
+// executing it, so a misconfigured genesis would otherwise go unnoticed. Only
+// the contexts of the hierarchy in use are checked.
+func (v *BlockValidator) ValidateGenesisState(block *types.Block, genesis *Genesis) error {
+	if block.NumberU64() != 0 {
+		return fmt.Errorf("block %d is not a genesis block", block.NumberU64())
+	}
+	expected := genesis.ToBlock(nil)
+	for ctx := 0; ctx < common.GetHierarchyParams().Depth; ctx++ {
+		if root := expected.Root(ctx); root != block.Root(ctx) {
+			return fmt.Errorf("%w: context %d root %x, allocation root %x", ErrInvalidGenesisState, ctx, block.Root(ctx), root)
+		}
 	}
 	return nil
 }
 
+// ComputeExpectedRoot returns the state root a block at the given number must
+// commit to after producing statedb, applying the same empty object deletion
+// rules as ValidateState. Block producers should use it to set the header root
+// so that producer and validator agree.
+func (v *BlockValidator) ComputeExpectedRoot(statedb *state.StateDB, number *big.Int) (common.Hash, error) {
+	return statedb.IntermediateRoot(v.config.IsEIP158(number))
+}
+
+// ValidateStateWithStats is ValidateState which additionally reports the state
+// growth caused by the block, e.g. for state size monitoring. The statistics
+// are only gathered for blocks which pass validation.
+func (v *BlockValidator) ValidateStateWithStats(block *types.Block, statedb *state.StateDB, receipts types.Receipts, usedGas uint64) (*state.StateStats, error) {
+	if err := v.ValidateState(block, statedb, receipts, usedGas); err != nil {
+		return nil, err
+	}
+	return statedb.ChangeStats()
+}
+
 // CalcGasLimit computes the gas limit of the next block after parent. It aims
 // to keep the baseline gas close to the provided target, and increase it towards
 // the target if the baseline gas is lower.
 func CalcGasLimit(parentGasLimit, desiredLimit uint64) uint64 {
-	delta := parentGasLimit/params.GasLimitBoundDivisor - 1
+	return CalcGasLimitWithCeiling(parentGasLimit, desiredLimit, params.MaxGasLimit)
+}
+
+// CalcGasLimitForContext is CalcGasLimit for a chain in the given context,
+// which is usually common.GetNodeLocation().Context(). Below prime, a chain's
+// gas limit is capped at its dominant's gas limit divided by
+// params.SubGasLimitDivisor, but never below params.MinGasLimit, so that the
+// subordinates of a chain cannot together outgrow it. Prime has no dominant
+// and ignores domGasLimit. Like CalcGasLimitWithCeiling, a parent above the
+// cap ratchets down towards it.
+func CalcGasLimitForContext(ctx int, parentGasLimit, domGasLimit, desiredLimit uint64) uint64 {
+	ceiling := params.MaxGasLimit
+	if ctx > common.PRIME_CTX {
+		ceiling = domGasLimit / params.SubGasLimitDivisor
+		if ceiling < params.MinGasLimit {
+			ceiling = params.MinGasLimit
+		}
+	}
+	return CalcGasLimitWithCeiling(parentGasLimit, desiredLimit, ceiling)
+}
+
+// CalcGasLimitWithCeiling is CalcGasLimit with the target capped at maxLimit.
+// The result never exceeds maxLimit, unless the parent's gas limit already
+// does: as the gas limit may only change by a bounded step per block, it then
+// ratchets down towards maxLimit one step at a time.
+func CalcGasLimitWithCeiling(parentGasLimit, desiredLimit, maxLimit uint64) uint64 {
+	// Guard against corrupt parent limits: the step must neither underflow for
+	// tiny limits nor the step up overflow for huge ones
+	var delta uint64
+	if step := parentGasLimit / params.GasLimitBoundDivisor; step > 0 {
+		delta = step - 1
+	}
 	limit := parentGasLimit
 	if desiredLimit < params.MinGasLimit {
 		desiredLimit = params.MinGasLimit
 	}
+	if desiredLimit > maxLimit {
+		desiredLimit = maxLimit
+	}
 	// If we're outside our allowed gas range, we try to hone towards them
 	if limit < desiredLimit {
-		limit = parentGasLimit + delta
+		var overflow bool
+		if limit, overflow = math.SafeAdd(parentGasLimit, delta); overflow {
+			limit = math.MaxUint64
+		}
 		if limit > desiredLimit {
 			limit = desiredLimit
 		}
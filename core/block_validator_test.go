@@ -0,0 +1,1619 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/common/math"
+	"github.com/dominant-strategies/go-quai/consensus"
+	"github.com/dominant-strategies/go-quai/core/rawdb"
+	"github.com/dominant-strategies/go-quai/core/state"
+	"github.com/dominant-strategies/go-quai/core/types"
+	"github.com/dominant-strategies/go-quai/core/vm"
+	"github.com/dominant-strategies/go-quai/crypto"
+	"github.com/dominant-strategies/go-quai/params"
+	"github.com/dominant-strategies/go-quai/trie"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// testEngine is a consensus engine stub which accepts every uncle set and
+// reports the headers in coincident as dom coincident.
+type testEngine struct {
+	consensus.Engine
+	coincident map[common.Hash]bool
+}
+
+func (e *testEngine) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	return nil
+}
+
+func (e *testEngine) IsDomCoincident(header *types.Header) bool {
+	return e.coincident[header.Hash()]
+}
+
+// newTestHeaderChain creates an in-memory header chain rooted at genesis,
+// without any of the background processing of NewHeaderChain.
+func newTestHeaderChain(genesis *types.Block, engine consensus.Engine) *HeaderChain {
+	config := *params.TestChainConfig
+	config.GenesisHash = genesis.Hash()
+
+	db := rawdb.NewMemoryDatabase()
+	headerCache, _ := lru.New(headerCacheLimit)
+	numberCache, _ := lru.New(numberCacheLimit)
+	etxRollupCache, _ := lru.New(etxRollupCacheLimit)
+	hc := &HeaderChain{
+		config:         &config,
+		headerDb:       db,
+		headerCache:    headerCache,
+		numberCache:    numberCache,
+		etxRollupCache: etxRollupCache,
+		engine:         engine,
+	}
+	hc.bc, _ = NewBodyDb(db, engine, hc, &config, nil, vm.Config{})
+	hc.genesisHeader = genesis.Header()
+	writeTestBlocks(hc, genesis)
+	return hc
+}
+
+// writeTestBlocks stores the given blocks in the header chain's database and
+// marks them canonical.
+func writeTestBlocks(hc *HeaderChain, blocks ...*types.Block) {
+	for _, block := range blocks {
+		rawdb.WriteBlock(hc.headerDb, block)
+		rawdb.WriteCanonicalHash(hc.headerDb, block.Hash(), block.NumberU64())
+	}
+}
+
+// setTestNodeLocation overrides the node location for the duration of a test.
+func setTestNodeLocation(t testing.TB, location common.Location) {
+	prev := common.GetNodeLocation()
+	common.SetNodeLocation(location)
+	t.Cleanup(func() { common.SetNodeLocation(prev) })
+}
+
+// testCoinbase is the reward recipient of the blocks created by newTestChild.
+var testCoinbase = common.HexToAddress("0x1400000000000000000000000000000000000c0b")
+
+// newTestChild creates an empty header extending parent at the given location,
+// incrementing every context's number.
+func newTestChild(parent *types.Header, location common.Location) *types.Header {
+	header := types.EmptyHeader()
+	header.SetLocation(location)
+	for ctx := 0; ctx < common.HierarchyDepth; ctx++ {
+		header.SetParentHash(parent.Hash(), ctx)
+		header.SetNumber(new(big.Int).Add(parent.Number(ctx), common.Big1), ctx)
+		header.SetDifficulty(big.NewInt(1), ctx)
+		header.SetCoinbase(testCoinbase, ctx)
+	}
+	return header
+}
+
+// newTestTx creates an unsigned transaction to the given recipient.
+func newTestTx(nonce uint64, to common.Address) *types.Transaction {
+	return types.NewTx(&types.InternalTx{
+		ChainID:   params.TestChainConfig.ChainID,
+		Nonce:     nonce,
+		GasTipCap: big.NewInt(1),
+		GasFeeCap: big.NewInt(1),
+		Gas:       21000,
+		To:        &to,
+		Value:     big.NewInt(1),
+		V:         new(big.Int),
+		R:         new(big.Int),
+		S:         new(big.Int),
+	})
+}
+
+// newTestBlock assembles a block from header and body, deriving the body roots
+// of the node's context, including the subordinate manifest hash.
+func newTestBlock(header *types.Header, txs []*types.Transaction, uncles []*types.Header, etxs []*types.Transaction, subManifest types.BlockManifest) *types.Block {
+	header = types.CopyHeader(header)
+	if nodeCtx := common.GetNodeLocation().Context(); nodeCtx < common.ZONE_CTX {
+		header.SetManifestHash(types.DeriveSha(subManifest, trie.NewStackTrie(nil)), nodeCtx+1)
+	}
+	return types.NewBlock(header, txs, uncles, etxs, subManifest, nil, trie.NewStackTrie(nil))
+}
+
+// newTestValidator creates a validator over a fresh in-memory chain holding
+// only a genesis block at the node's location.
+func newTestValidator(vconfig *ValidationConfig) (*BlockValidator, *types.Block) {
+	genesis := types.NewBlockWithHeader(newTestHeader(common.GetNodeLocation(), 0, 0, 0))
+	hc := newTestHeaderChain(genesis, &testEngine{})
+	return NewBlockValidatorWithConfig(hc.config, hc, hc.engine, vconfig), genesis
+}
+
+// newTestHeader creates an empty header at the given location, with the
+// per-context block numbers set from numbers (prime first).
+func newTestHeader(location common.Location, numbers ...uint64) *types.Header {
+	header := types.EmptyHeader()
+	header.SetLocation(location)
+	for ctx, number := range numbers {
+		header.SetNumber(new(big.Int).SetUint64(number), ctx)
+	}
+	return header
+}
+
+func TestValidateBlockNumbering(t *testing.T) {
+	validator := NewBlockValidatorWithConfig(params.TestChainConfig, nil, nil, &ValidationConfig{
+		GenesisOffsets: map[string]uint64{"cyprus2": 100},
+	})
+	tests := []struct {
+		name    string
+		header  *types.Header
+		wantErr error
+	}{
+		{"prime at genesis", newTestHeader(common.Location{}, 0), nil},
+		{"zone above offset", newTestHeader(common.Location{0, 1}, 3, 7, 100), nil},
+		{"zone below offset", newTestHeader(common.Location{0, 1}, 3, 7, 99), ErrBadBlockNumber},
+		{"other zone without offset", newTestHeader(common.Location{1, 1}, 3, 7, 5), nil},
+	}
+	for _, tt := range tests {
+		err := validator.ValidateBlockNumbering(types.NewBlockWithHeader(tt.header))
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateBodyBlockNumbering(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	for _, tt := range []struct {
+		offset  uint64
+		wantErr error
+	}{
+		{0, nil},
+		{1, nil},
+		{2, ErrBadBlockNumber},
+	} {
+		validator, genesis := newTestValidator(&ValidationConfig{GenesisOffsets: map[string]uint64{"cyprus1": tt.offset}})
+		block := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), nil, nil, nil, nil)
+		if err := validator.ValidateBody(block); !errors.Is(err, tt.wantErr) {
+			t.Errorf("offset %d: have %v, want %v", tt.offset, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateAllManifestHashes(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0})
+
+	genesis := types.NewBlockWithHeader(newTestHeader(common.Location{0}, 0, 0, 0))
+	hc := newTestHeaderChain(genesis, &testEngine{})
+	parent := types.NewBlockWithHeader(newTestChild(genesis.Header(), common.Location{0}))
+	writeTestBlocks(hc, parent)
+
+	subManifest := types.BlockManifest{common.HexToHash("0x01"), common.HexToHash("0x02")}
+	header := newTestChild(parent.Header(), common.Location{0})
+	header.SetManifestHash(types.DeriveSha(types.BlockManifest{genesis.Hash(), parent.Hash()}, trie.NewStackTrie(nil)), common.REGION_CTX)
+	header.SetManifestHash(types.DeriveSha(subManifest, trie.NewStackTrie(nil)), common.ZONE_CTX)
+
+	validator := NewBlockValidatorWithConfig(hc.config, hc, hc.engine, &ValidationConfig{
+		ManifestContexts: []int{common.PRIME_CTX, common.REGION_CTX, common.ZONE_CTX},
+	})
+	block := types.NewBlockWithHeader(header).WithBody(nil, nil, nil, subManifest)
+	if err := validator.ValidateAllManifestHashes(block); err != nil {
+		t.Fatalf("valid manifests rejected: %v", err)
+	}
+
+	// Tamper with the region-level manifest hash
+	badHeader := types.CopyHeader(header)
+	badHeader.SetManifestHash(common.HexToHash("0xdead"), common.REGION_CTX)
+	block = types.NewBlockWithHeader(badHeader).WithBody(nil, nil, nil, subManifest)
+	if err := validator.ValidateAllManifestHashes(block); !errors.Is(err, ErrBadManifest) {
+		t.Errorf("region manifest mismatch: have %v, want %v", err, ErrBadManifest)
+	}
+
+	// Tamper with the zone-level (subordinate) manifest
+	block = types.NewBlockWithHeader(header).WithBody(nil, nil, nil, subManifest[:1])
+	if err := validator.ValidateAllManifestHashes(block); !errors.Is(err, ErrBadSubManifest) {
+		t.Errorf("zone manifest mismatch: have %v, want %v", err, ErrBadSubManifest)
+	}
+
+	// Regions of a hierarchy without zones have no subordinate manifest
+	t.Cleanup(func() { common.SetHierarchyParams(common.DefaultHierarchyParams) })
+	if err := common.SetHierarchyParams(common.HierarchyParams{Depth: 2, RegionsInPrime: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validator.ValidateAllManifestHashes(block); err != nil {
+		t.Errorf("zoneless hierarchy: have %v, want nil", err)
+	}
+}
+
+func TestValidateAgainstCheckpoint(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+
+	header := newTestHeader(common.Location{0, 0}, 1, 2, 3)
+	header.SetRoot(common.HexToHash("0x1234"))
+	block := types.NewBlockWithHeader(header)
+	validator := NewBlockValidator(params.TestChainConfig, nil, nil)
+
+	tests := []struct {
+		name       string
+		checkpoint Checkpoint
+		wantErr    error
+	}{
+		{"match", Checkpoint{Number: 3, Hash: block.Hash()}, nil},
+		{"match with root", Checkpoint{Number: 3, Hash: block.Hash(), Root: block.Root()}, nil},
+		{"other height", Checkpoint{Number: 4, Hash: common.HexToHash("0x01")}, nil},
+		{"hash mismatch", Checkpoint{Number: 3, Hash: common.HexToHash("0x01")}, ErrCheckpointMismatch},
+		{"root mismatch", Checkpoint{Number: 3, Hash: block.Hash(), Root: common.HexToHash("0x01")}, ErrCheckpointMismatch},
+	}
+	for _, tt := range tests {
+		if err := validator.ValidateAgainstCheckpoint(block, tt.checkpoint); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateUncleSlices(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0})
+	validator, genesis := newTestValidator(nil)
+
+	tests := []struct {
+		name    string
+		uncle   common.Location
+		wantErr error
+	}{
+		{"same zone", common.Location{0, 1}, nil},
+		{"dominant region", common.Location{0}, nil},
+		{"prime", common.Location{}, nil},
+		{"sibling zone", common.Location{0, 2}, ErrUncleWrongSlice},
+		{"other region", common.Location{1}, ErrUncleWrongSlice},
+		{"other region's zone", common.Location{2, 1}, ErrUncleWrongSlice},
+	}
+	for _, tt := range tests {
+		uncle := newTestHeader(tt.uncle, 0, 0, 0)
+		header := newTestChild(genesis.Header(), common.Location{0, 1})
+		block := newTestBlock(header, nil, []*types.Header{uncle}, nil, types.BlockManifest{common.HexToHash("0x01")})
+		if err := validator.ValidateBody(block); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateBodyUncleBounds(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(nil)
+
+	// Build a chain deep enough for uncles of every allowed depth
+	parent := genesis
+	for i := uint64(0); i < params.MaxUncleDepth+1; i++ {
+		parent = newTestBlock(newTestChild(parent.Header(), common.Location{0, 0}), nil, nil, nil, nil)
+		writeTestBlocks(validator.hc, parent)
+	}
+	number := parent.NumberU64() + 1
+	uncleAt := func(n uint64) *types.Header {
+		return newTestHeader(common.Location{0, 0}, n, n, n)
+	}
+	tests := []struct {
+		name    string
+		uncles  []*types.Header
+		wantErr error
+	}{
+		{"no uncles", nil, nil},
+		{"shallowest uncle", []*types.Header{uncleAt(number - 1)}, nil},
+		{"deepest uncle", []*types.Header{uncleAt(number - params.MaxUncleDepth)}, nil},
+		{"max uncles", []*types.Header{uncleAt(number - 1), uncleAt(number - 2)}, nil},
+		{"too many uncles", []*types.Header{uncleAt(number - 1), uncleAt(number - 2), uncleAt(number - 3)}, ErrTooManyUncles},
+		{"uncle too deep", []*types.Header{uncleAt(number - params.MaxUncleDepth - 1)}, ErrUncleDepth},
+		{"uncle at block height", []*types.Header{uncleAt(number)}, ErrUncleDepth},
+		{"uncle above block", []*types.Header{uncleAt(number + 1)}, ErrUncleDepth},
+		{"duplicate uncle", []*types.Header{uncleAt(number - 1), uncleAt(number - 1)}, ErrDuplicateUncle},
+	}
+	for _, tt := range tests {
+		block := newTestBlock(newTestChild(parent.Header(), common.Location{0, 0}), nil, tt.uncles, nil, nil)
+		if err := validator.ValidateBody(block); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	// A block cannot commit to itself as an uncle, but the check must not
+	// depend on the uncle root catching it
+	block := newTestBlock(newTestChild(parent.Header(), common.Location{0, 0}), nil, nil, nil, nil)
+	block = block.WithBody(nil, []*types.Header{block.Header()}, nil, nil)
+	if err := validator.ValidateBody(block); !errors.Is(err, ErrDuplicateUncle) {
+		t.Errorf("self uncle: error mismatch: have %v, want %v", err, ErrDuplicateUncle)
+	}
+}
+
+func TestComputeExpectedRoot(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(nil)
+
+	// Apply the same state transition on the producer's and validator's side
+	var (
+		db    = state.NewDatabase(rawdb.NewMemoryDatabase())
+		full  = common.HexToAddress("0x1400000000000000000000000000000000000001")
+		empty = common.HexToAddress("0x1400000000000000000000000000000000000002")
+	)
+	transition := func() *state.StateDB {
+		statedb, _ := state.New(common.Hash{}, db, nil)
+		statedb.AddBalance(full, big.NewInt(1))
+		statedb.AddBalance(empty, new(big.Int)) // touched empty account, deleted as of EIP-158
+		return statedb
+	}
+	header := newTestChild(genesis.Header(), common.Location{0, 0})
+	root, err := validator.ComputeExpectedRoot(transition(), header.Number())
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.SetRoot(root)
+	block := types.NewBlockWithHeader(header)
+	if err := validator.ValidateState(block, transition(), nil, 0); err != nil {
+		t.Fatalf("producer root rejected: %v", err)
+	}
+	// A root computed without the fork rules must not validate
+	statedb := transition()
+	stale, _ := statedb.IntermediateRoot(false)
+	if stale == root {
+		t.Fatal("empty object deletion did not affect the root")
+	}
+	header.SetRoot(stale)
+	if err := validator.ValidateState(types.NewBlockWithHeader(header), transition(), nil, 0); err == nil {
+		t.Fatal("mismatched root accepted")
+	}
+}
+
+func TestValidateBodyDifficulty(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(&ValidationConfig{MaxDifficulty: big.NewInt(1000)})
+
+	tests := []struct {
+		difficulty *big.Int
+		wantErr    error
+	}{
+		{big.NewInt(0), ErrZeroDifficulty},
+		{big.NewInt(-1), ErrZeroDifficulty},
+		{big.NewInt(1), nil},
+		{big.NewInt(1000), nil},
+		{big.NewInt(1001), ErrDifficultyTooHigh},
+	}
+	for _, tt := range tests {
+		header := newTestChild(genesis.Header(), common.Location{0, 0})
+		header.SetDifficulty(tt.difficulty)
+		if err := validator.ValidateBody(newTestBlock(header, nil, nil, nil, nil)); !errors.Is(err, tt.wantErr) {
+			t.Errorf("difficulty %v: error mismatch: have %v, want %v", tt.difficulty, err, tt.wantErr)
+		}
+	}
+	// Without a configured maximum, anything beyond 256 bits is rejected
+	validator, _ = newTestValidator(nil)
+	header := newTestChild(genesis.Header(), common.Location{0, 0})
+	header.SetDifficulty(new(big.Int).Lsh(common.Big1, 256))
+	if err := validator.ValidateBody(newTestBlock(header, nil, nil, nil, nil)); !errors.Is(err, ErrDifficultyTooHigh) {
+		t.Errorf("256 bit difficulty: error mismatch: have %v, want %v", err, ErrDifficultyTooHigh)
+	}
+}
+
+func TestValidateParentLocation(t *testing.T) {
+	tests := []struct {
+		name    string
+		node    common.Location
+		parent  common.Location
+		block   common.Location
+		wantErr error
+	}{
+		{"same zone", common.Location{0, 1}, common.Location{0, 1}, common.Location{0, 1}, nil},
+		{"sibling zone", common.Location{0, 1}, common.Location{0, 2}, common.Location{0, 1}, ErrParentWrongLocation},
+		{"other region's zone", common.Location{0, 1}, common.Location{1, 1}, common.Location{0, 1}, ErrParentWrongLocation},
+		{"region from sibling zones", common.Location{0}, common.Location{0, 2}, common.Location{0, 1}, nil},
+		{"region from other region", common.Location{0}, common.Location{1, 0}, common.Location{0, 1}, ErrParentWrongLocation},
+		{"prime from any zone", common.Location{}, common.Location{2, 2}, common.Location{0, 1}, nil},
+	}
+	for _, tt := range tests {
+		setTestNodeLocation(t, tt.node)
+		genesis := types.NewBlockWithHeader(newTestHeader(tt.node, 0, 0, 0))
+		hc := newTestHeaderChain(genesis, &testEngine{})
+		validator := NewBlockValidator(hc.config, hc, hc.engine)
+
+		parent := types.NewBlockWithHeader(newTestChild(genesis.Header(), tt.parent))
+		writeTestBlocks(hc, parent)
+		block := newTestBlock(newTestChild(parent.Header(), tt.block), nil, nil, nil, types.BlockManifest{common.HexToHash("0x01")})
+		if err := validator.ValidateBody(block); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateBodyOnGenesis(t *testing.T) {
+	for _, node := range []common.Location{{}, {0}, {0, 1}} {
+		setTestNodeLocation(t, node)
+		// Genesis is built without a location, exactly like on a live node
+		genesis := (&Genesis{
+			Config:     params.TestChainConfig,
+			GasLimit:   []uint64{params.GenesisGasLimit, params.GenesisGasLimit, params.GenesisGasLimit},
+			Difficulty: []*big.Int{big.NewInt(1), big.NewInt(1), big.NewInt(1)},
+		}).ToBlock(nil)
+		hc := newTestHeaderChain(genesis, &testEngine{})
+		validator := NewBlockValidator(hc.config, hc, hc.engine)
+
+		block := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 1}), nil, nil, nil, types.BlockManifest{common.HexToHash("0x01")})
+		if err := validator.ValidateBody(block); err != nil {
+			t.Errorf("node %v: first block rejected: %v", node, err)
+		}
+	}
+}
+
+func TestValidateEtxValueConservation(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(nil)
+
+	to := common.HexToAddress("0x3c00000000000000000000000000000000000001") // paxos1
+	tx := types.NewTx(&types.InternalToExternalTx{
+		ChainID:     params.TestChainConfig.ChainID,
+		GasTipCap:   big.NewInt(1),
+		GasFeeCap:   big.NewInt(1),
+		Gas:         50000,
+		To:          &to,
+		Value:       big.NewInt(100),
+		ETXGasLimit: 21000,
+		ETXGasPrice: big.NewInt(1),
+		ETXGasTip:   big.NewInt(1),
+		V:           new(big.Int),
+		R:           new(big.Int),
+		S:           new(big.Int),
+	})
+	etx := func(value int64) *types.Transaction {
+		return types.NewTx(&types.ExternalTx{ChainID: params.TestChainConfig.ChainID, To: &to, Value: big.NewInt(value), GasTipCap: new(big.Int), GasFeeCap: new(big.Int)})
+	}
+	block := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), []*types.Transaction{tx}, nil, nil, nil)
+
+	tests := []struct {
+		name     string
+		receipts types.Receipts
+		wantErr  error
+	}{
+		{"balanced", types.Receipts{{Status: types.ReceiptStatusSuccessful, Etxs: []*types.Transaction{etx(100)}}}, nil},
+		{"etx exceeds debit", types.Receipts{{Status: types.ReceiptStatusSuccessful, Etxs: []*types.Transaction{etx(101)}}}, ErrEtxValueImbalance},
+		{"split etx exceeds debit", types.Receipts{{Status: types.ReceiptStatusSuccessful, Etxs: []*types.Transaction{etx(60), etx(60)}}}, ErrEtxValueImbalance},
+		{"missing etx", types.Receipts{{Status: types.ReceiptStatusSuccessful}}, ErrEtxValueImbalance},
+		{"failed transaction", types.Receipts{{Status: types.ReceiptStatusFailed, Etxs: []*types.Transaction{etx(101)}}}, nil},
+	}
+	for _, tt := range tests {
+		if err := validator.ValidateEtxValueConservation(block, tt.receipts); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if err := validator.ValidateEtxValueConservation(block, nil); err == nil {
+		t.Error("missing receipts accepted")
+	}
+}
+
+func TestValidateGenesisState(t *testing.T) {
+	setTestNodeLocation(t, common.Location{})
+
+	newGenesis := func(balance int64) *Genesis {
+		return &Genesis{
+			Config:     params.TestChainConfig,
+			GasLimit:   []uint64{params.GenesisGasLimit, params.GenesisGasLimit, params.GenesisGasLimit},
+			Difficulty: []*big.Int{big.NewInt(1), big.NewInt(1), big.NewInt(1)},
+			Alloc: GenesisAlloc{
+				common.HexToAddress("0x0100000000000000000000000000000000000001"): {Balance: big.NewInt(balance)},
+			},
+		}
+	}
+	block := newGenesis(1000).ToBlock(nil)
+	validator := NewBlockValidator(params.TestChainConfig, nil, nil)
+	if err := validator.ValidateGenesisState(block, newGenesis(1000)); err != nil {
+		t.Fatalf("genesis rejected: %v", err)
+	}
+	if err := validator.ValidateGenesisState(block, newGenesis(1001)); !errors.Is(err, ErrInvalidGenesisState) {
+		t.Fatalf("tampered allocation: have %v, want %v", err, ErrInvalidGenesisState)
+	}
+	// Only the contexts of the hierarchy in use are checked
+	header := types.CopyHeader(block.Header())
+	header.SetRoot(common.Hash{1}, common.ZONE_CTX)
+	zoneless := types.NewBlockWithHeader(header)
+	if err := validator.ValidateGenesisState(zoneless, newGenesis(1000)); !errors.Is(err, ErrInvalidGenesisState) {
+		t.Fatalf("tampered zone root: have %v, want %v", err, ErrInvalidGenesisState)
+	}
+	t.Cleanup(func() { common.SetHierarchyParams(common.DefaultHierarchyParams) })
+	if err := common.SetHierarchyParams(common.HierarchyParams{Depth: 2, RegionsInPrime: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validator.ValidateGenesisState(zoneless, newGenesis(1000)); err != nil {
+		t.Errorf("zoneless hierarchy: have %v, want nil", err)
+	}
+}
+
+func TestCheckEquivocation(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(nil)
+
+	newSibling := func(time uint64, extra string) *types.Block {
+		header := newTestChild(genesis.Header(), common.Location{0, 0})
+		header.SetTime(time)
+		header.SetExtra([]byte(extra))
+		return types.NewBlockWithHeader(header)
+	}
+	seen := newSibling(10, "a")
+	if err := validator.CheckEquivocation(seen); err != nil {
+		t.Fatalf("first block reported: %v", err)
+	}
+	writeTestBlocks(validator.hc, seen)
+
+	if err := validator.CheckEquivocation(seen); err != nil {
+		t.Errorf("known block reported against itself: %v", err)
+	}
+	if err := validator.CheckEquivocation(newSibling(11, "b")); err != nil {
+		t.Errorf("fork with different timestamp reported: %v", err)
+	}
+	if err := validator.CheckEquivocation(newSibling(10, "b")); !errors.Is(err, ErrEquivocation) {
+		t.Errorf("conflicting block: have %v, want %v", err, ErrEquivocation)
+	}
+}
+
+func TestValidateManifestEtxConsistency(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0})
+	validator, genesis := newTestValidator(nil)
+
+	to := common.HexToAddress("0x3c00000000000000000000000000000000000001")
+	etx := func(nonce uint64) *types.Transaction {
+		return types.NewTx(&types.ExternalTx{ChainID: params.TestChainConfig.ChainID, Nonce: nonce, To: &to, Value: new(big.Int), GasTipCap: new(big.Int), GasFeeCap: new(big.Int)})
+	}
+	// Two subordinate blocks, emitting one and two ETXs into the zone context
+	var manifest types.BlockManifest
+	for i, etxs := range []types.Transactions{{etx(0)}, {etx(1), etx(2)}} {
+		sub := newTestHeader(common.Location{0, 0}, 0, 0, uint64(i+1))
+		rawdb.WritePendingEtxs(validator.hc.headerDb, types.PendingEtxs{Header: sub, Etxs: []types.Transactions{nil, nil, etxs}})
+		manifest = append(manifest, sub.Hash())
+	}
+	tests := []struct {
+		name     string
+		manifest types.BlockManifest
+		etxs     types.Transactions
+		wantErr  error
+	}{
+		{"consistent", manifest, types.Transactions{etx(0), etx(1), etx(2)}, nil},
+		{"omitted etx", manifest, types.Transactions{etx(0), etx(1)}, ErrManifestEtxMismatch},
+		{"unpromised etx", manifest, types.Transactions{etx(0), etx(1), etx(2), etx(3)}, ErrManifestEtxMismatch},
+		{"reordered etxs", manifest, types.Transactions{etx(0), etx(2), etx(1)}, ErrManifestEtxMismatch},
+		{"unknown manifest entry", append(manifest, common.HexToHash("0x01")), types.Transactions{etx(0), etx(1), etx(2)}, ErrPendingEtxNotFound},
+	}
+	for _, tt := range tests {
+		block := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), nil, nil, tt.etxs, tt.manifest)
+		if err := validator.ValidateManifestEtxConsistency(block); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckReorgDepth(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(&ValidationConfig{MaxReorgDepth: 2})
+
+	// newTestChain extends parent by n blocks, distinguished by their time
+	newTestChain := func(parent *types.Block, n int, time uint64) []*types.Block {
+		var chain []*types.Block
+		for i := 0; i < n; i++ {
+			header := newTestChild(parent.Header(), common.Location{0, 0})
+			header.SetTime(time)
+			parent = types.NewBlockWithHeader(header)
+			chain = append(chain, parent)
+		}
+		writeTestBlocks(validator.hc, chain...)
+		return chain
+	}
+	canonical := newTestChain(genesis, 5, 1)
+	head := canonical[4]
+
+	tests := []struct {
+		name    string
+		parent  *types.Block
+		wantErr error
+	}{
+		{"extends head", head, nil},
+		{"sibling of head", canonical[3], nil},
+		{"reorg at limit", canonical[2], nil},
+		{"reorg beyond limit", canonical[1], ErrReorgTooDeep},
+		{"longer side chain beyond limit", newTestChain(canonical[1], 6, 2)[5], ErrReorgTooDeep},
+		{"longer side chain within limit", newTestChain(canonical[2], 6, 2)[5], nil},
+	}
+	for _, tt := range tests {
+		block := types.NewBlockWithHeader(newTestChild(tt.parent.Header(), common.Location{0, 0}))
+		if err := validator.CheckReorgDepth(block, head); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	// Blocks on unknown parents cannot be checked
+	orphan := types.NewBlockWithHeader(newTestChild(newTestHeader(common.Location{0, 0}, 0, 0, 7), common.Location{0, 0}))
+	if err := validator.CheckReorgDepth(orphan, head); !errors.Is(err, consensus.ErrUnknownAncestor) {
+		t.Errorf("orphan: have %v, want %v", err, consensus.ErrUnknownAncestor)
+	}
+}
+
+// rejectingEngine is a consensus engine stub rejecting every uncle set with err.
+type rejectingEngine struct {
+	testEngine
+	err error
+}
+
+func (e *rejectingEngine) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	return e.err
+}
+
+// contextTestEngine is a ContextEngine delegating to a different engine per
+// context.
+type contextTestEngine struct {
+	testEngine
+	engines []consensus.Engine
+}
+
+func (e *contextTestEngine) EngineForContext(ctx int) consensus.Engine {
+	return e.engines[ctx]
+}
+
+func TestBlockValidatorEngineSelection(t *testing.T) {
+	var (
+		engines []consensus.Engine
+		errs    []error
+	)
+	for ctx := 0; ctx < common.HierarchyDepth; ctx++ {
+		errs = append(errs, fmt.Errorf("context %d engine", ctx))
+		engines = append(engines, &rejectingEngine{err: errs[ctx]})
+	}
+	var selected []int
+	selector := func(ctx int) consensus.Engine {
+		selected = append(selected, ctx)
+		return engines[ctx]
+	}
+	for _, loc := range []common.Location{{0, 0}, {0}} {
+		setTestNodeLocation(t, loc)
+		genesis := types.NewBlockWithHeader(newTestHeader(loc, 0, 0, 0))
+		hc := newTestHeaderChain(genesis, &testEngine{})
+		block := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), nil, nil, nil, nil)
+		ctx := loc.Context()
+
+		// The engine is picked for the node's context at validation time
+		selected = selected[:0]
+		validator := NewBlockValidatorWithSelector(hc.config, hc, selector, nil)
+		if err := validator.ValidateBody(block); err != errs[ctx] {
+			t.Errorf("%s: selector: have %v, want %v", loc.Name(), err, errs[ctx])
+		}
+		if len(selected) != 1 || selected[0] != ctx {
+			t.Errorf("%s: selected contexts %v, want [%d]", loc.Name(), selected, ctx)
+		}
+		// Engines implementing ContextEngine are asked for the context's engine
+		validator = NewBlockValidator(hc.config, hc, &contextTestEngine{engines: engines})
+		if err := validator.ValidateBody(block); err != errs[ctx] {
+			t.Errorf("%s: context engine: have %v, want %v", loc.Name(), err, errs[ctx])
+		}
+		// Any other engine verifies every context
+		validator = NewBlockValidator(hc.config, hc, engines[common.PRIME_CTX])
+		if err := validator.ValidateBody(block); err != errs[common.PRIME_CTX] {
+			t.Errorf("%s: plain engine: have %v, want %v", loc.Name(), err, errs[common.PRIME_CTX])
+		}
+	}
+}
+
+func TestValidateCoincidentEtxReset(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	engine := &testEngine{coincident: make(map[common.Hash]bool)}
+	genesis := types.NewBlockWithHeader(newTestHeader(common.Location{0, 0}, 0, 0, 0))
+	hc := newTestHeaderChain(genesis, engine)
+	validator := NewBlockValidator(hc.config, hc, engine)
+
+	// A coincident and a non-coincident parent, both having emitted ETXs
+	newParent := func(etxHash common.Hash, coincident bool) *types.Header {
+		header := newTestChild(genesis.Header(), common.Location{0, 0})
+		header.SetEtxHash(etxHash)
+		writeTestBlocks(hc, types.NewBlockWithHeader(header))
+		engine.coincident[header.Hash()] = coincident
+		return header
+	}
+	coincident := newParent(common.HexToHash("0x01"), true)
+	ordinary := newParent(common.HexToHash("0x02"), false)
+
+	tests := []struct {
+		name    string
+		parent  *types.Header
+		rollup  common.Hash
+		wantErr error
+	}{
+		{"reset after coincident parent", coincident, coincident.EtxHash(), nil},
+		{"carried over after coincident parent", coincident, common.HexToHash("0x03"), ErrEtxRollupNotReset},
+		{"empty after coincident parent", coincident, types.EmptyRootHash, ErrEtxRollupNotReset},
+		{"accumulated after ordinary parent", ordinary, common.HexToHash("0x03"), nil},
+		{"reset after genesis", genesis.Header(), genesis.EtxHash(), nil},
+		{"carried over after genesis", genesis.Header(), common.HexToHash("0x03"), ErrEtxRollupNotReset},
+	}
+	for _, tt := range tests {
+		header := newTestChild(tt.parent, common.Location{0, 0})
+		header.SetEtxRollupHash(tt.rollup)
+		if err := validator.ValidateCoincidentEtxReset(types.NewBlockWithHeader(header)); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateBodyInvalidLocation(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(nil)
+
+	block := newTestBlock(newTestChild(genesis.Header(), common.Location{5, 9}), nil, nil, nil, nil)
+	if err := validator.ValidateBody(block); !errors.Is(err, common.ErrInvalidLocation) {
+		t.Errorf("invalid block location: have %v, want %v", err, common.ErrInvalidLocation)
+	}
+	if err := validator.ValidateBlockNumbering(block); !errors.Is(err, common.ErrInvalidLocation) {
+		t.Errorf("invalid block location numbering: have %v, want %v", err, common.ErrInvalidLocation)
+	}
+	uncle := newTestChild(genesis.Header(), common.Location{0, 7})
+	block = newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), nil, []*types.Header{uncle}, nil, nil)
+	if err := validator.ValidateBody(block); !errors.Is(err, common.ErrInvalidLocation) {
+		t.Errorf("invalid uncle location: have %v, want %v", err, common.ErrInvalidLocation)
+	}
+}
+
+func TestValidateBodyZeroCoinbase(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(nil)
+
+	header := newTestChild(genesis.Header(), common.Location{0, 0})
+	header.SetCoinbase(common.ZeroAddr)
+	block := newTestBlock(header, nil, nil, nil, nil)
+	if err := validator.ValidateBody(block); !errors.Is(err, ErrZeroCoinbase) {
+		t.Errorf("zero coinbase: have %v, want %v", err, ErrZeroCoinbase)
+	}
+	validator, _ = newTestValidator(&ValidationConfig{AllowZeroCoinbase: true})
+	if err := validator.ValidateBody(block); err != nil {
+		t.Errorf("zero coinbase on permissive chain: %v", err)
+	}
+}
+
+func TestValidateBodyStreamingRoots(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	to := common.HexToAddress("0x1400000000000000000000000000000000000001")
+
+	var txs types.Transactions
+	for i := uint64(0); i < 200; i++ {
+		txs = append(txs, newTestTx(i, to))
+	}
+	for _, threshold := range []int{0, 1, 199, 200} {
+		validator, genesis := newTestValidator(&ValidationConfig{StreamingRootThreshold: threshold})
+		block := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), txs, nil, nil, nil)
+		if err := validator.ValidateBody(block); err != nil {
+			t.Errorf("threshold %d: valid body rejected: %v", threshold, err)
+		}
+		header := block.Header()
+		header.SetTxHash(types.DeriveSha(txs[1:], trie.NewStackTrie(nil)))
+		block = types.NewBlockWithHeader(header).WithBody(txs, nil, nil, nil)
+		if err := validator.ValidateBody(block); !errors.Is(err, ErrTxRootMismatch) {
+			t.Errorf("threshold %d: error mismatch: have %v, want %v", threshold, err, ErrTxRootMismatch)
+		}
+	}
+}
+
+func TestValidateBodyRootMismatch(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(nil)
+	to := common.HexToAddress("0x1400000000000000000000000000000000000001")
+	block := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), nil, nil, nil, nil)
+
+	tests := []struct {
+		name    string
+		block   *types.Block
+		wantErr error
+		want    common.Hash
+	}{
+		{"uncles", block.WithBody(nil, []*types.Header{newTestHeader(common.Location{0, 0}, 0, 0, 0)}, nil, nil), ErrUncleRootMismatch, block.UncleHash()},
+		{"transactions", block.WithBody([]*types.Transaction{newTestTx(0, to)}, nil, nil, nil), ErrTxRootMismatch, block.TxHash()},
+		{"external transactions", block.WithBody(nil, nil, []*types.Transaction{newTestTx(0, to)}, nil), ErrEtxRootMismatch, block.EtxHash()},
+	}
+	for _, tt := range tests {
+		err := validator.ValidateBody(tt.block)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		var mismatch *HashMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Errorf("%s: error %T is not a HashMismatchError", tt.name, err)
+			continue
+		}
+		if mismatch.Want != tt.want || mismatch.Have == tt.want {
+			t.Errorf("%s: hashes mismatch: have %x, want %x, header %x", tt.name, mismatch.Have, mismatch.Want, tt.want)
+		}
+	}
+	// Dominant chains additionally commit to their subordinate manifest
+	setTestNodeLocation(t, common.Location{0})
+	validator, genesis = newTestValidator(nil)
+	manifest := types.BlockManifest{common.HexToHash("0x01")}
+	block = newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), nil, nil, nil, manifest)
+	block = block.WithBody(nil, nil, nil, types.BlockManifest{common.HexToHash("0x02")})
+	var mismatch *HashMismatchError
+	if err := validator.ValidateBody(block); !errors.Is(err, ErrBadSubManifest) || !errors.As(err, &mismatch) {
+		t.Fatalf("sub manifest: error mismatch: have %v, want %v", err, ErrBadSubManifest)
+	}
+	if mismatch.Want != block.ManifestHash(common.ZONE_CTX) {
+		t.Errorf("sub manifest: want hash %x, header %x", mismatch.Want, block.ManifestHash(common.ZONE_CTX))
+	}
+}
+
+func TestValidateBodySubManifestDuplicates(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0})
+	validator, genesis := newTestValidator(nil)
+
+	first := types.NewBlockWithHeader(newTestChild(genesis.Header(), common.Location{0, 0}))
+	second := types.NewBlockWithHeader(newTestChild(first.Header(), common.Location{0, 0}))
+	writeTestBlocks(validator.hc, first, second)
+	unknown := common.HexToHash("0x01")
+
+	tests := []struct {
+		name     string
+		manifest types.BlockManifest
+		wantErr  error
+	}{
+		{"distinct", types.BlockManifest{first.Hash(), unknown, second.Hash()}, nil},
+		// Subordinate blocks are mostly unknown locally, so order is not checked
+		{"reversed", types.BlockManifest{second.Hash(), first.Hash()}, nil},
+		{"duplicate", types.BlockManifest{first.Hash(), second.Hash(), first.Hash()}, ErrManifestDuplicate},
+		{"duplicate unknown entry", types.BlockManifest{unknown, unknown}, ErrManifestDuplicate},
+	}
+	for _, tt := range tests {
+		block := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), nil, nil, nil, tt.manifest)
+		if err := validator.ValidateBody(block); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	// Zones have no subordinate manifest to check
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis = newTestValidator(nil)
+	block := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), nil, nil, nil, nil)
+	block = block.WithBody(nil, nil, nil, types.BlockManifest{unknown, unknown})
+	if err := validator.ValidateBody(block); err != nil {
+		t.Errorf("zone: unexpected error: %v", err)
+	}
+}
+
+func TestSubManifestHash(t *testing.T) {
+	header := types.EmptyHeader()
+	for ctx := 0; ctx < common.HierarchyDepth; ctx++ {
+		header.SetManifestHash(common.Hash{byte(ctx + 1)}, ctx)
+	}
+	tests := []struct {
+		ctx  int
+		want common.Hash
+		ok   bool
+	}{
+		{common.PRIME_CTX, common.Hash{byte(common.REGION_CTX + 1)}, true},
+		{common.REGION_CTX, common.Hash{byte(common.ZONE_CTX + 1)}, true},
+		{common.ZONE_CTX, common.Hash{}, false},
+		{-1, common.Hash{}, false},
+		{common.HierarchyDepth, common.Hash{}, false},
+	}
+	for _, tt := range tests {
+		if have, ok := SubManifestHash(header, tt.ctx); have != tt.want || ok != tt.ok {
+			t.Errorf("context %d: have %x (%v), want %x (%v)", tt.ctx, have, ok, tt.want, tt.ok)
+		}
+	}
+	// Regions are the leaves of a two-level hierarchy
+	prev := common.GetHierarchyParams()
+	if err := common.SetHierarchyParams(common.HierarchyParams{Depth: 2, RegionsInPrime: 3}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { common.SetHierarchyParams(prev) })
+	if _, ok := SubManifestHash(header, common.REGION_CTX); ok {
+		t.Errorf("region of a two-level hierarchy has a subordinate manifest")
+	}
+	if _, ok := SubManifestHash(header, common.PRIME_CTX); !ok {
+		t.Errorf("prime of a two-level hierarchy has no subordinate manifest")
+	}
+}
+
+func TestValidateBodies(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(nil)
+	to := common.HexToAddress("0x1400000000000000000000000000000000000001")
+
+	txs := []*types.Transaction{newTestTx(0, to), newTestTx(1, to)}
+	child := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), txs, nil, nil, nil)
+	grandchild := newTestBlock(newTestChild(child.Header(), common.Location{0, 0}), nil, nil, nil, nil)
+	sibling := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), nil, nil, nil, nil)
+	blocks := []*types.Block{
+		genesis,
+		child,
+		grandchild,
+		sibling.WithBody([]*types.Transaction{newTestTx(2, to)}, nil, nil, nil),
+		sibling,
+	}
+	errs := validator.ValidateBodies(blocks)
+	if len(errs) != len(blocks) {
+		t.Fatalf("result count mismatch: have %d, want %d", len(errs), len(blocks))
+	}
+	for i, block := range blocks {
+		if want := validator.ValidateBody(block); fmt.Sprint(errs[i]) != fmt.Sprint(want) {
+			t.Errorf("block %d: batch result %v, individual result %v", i, errs[i], want)
+		}
+	}
+	for i, want := range []error{ErrKnownBlock, nil, consensus.ErrUnknownAncestor, ErrTxRootMismatch, nil} {
+		if !errors.Is(errs[i], want) {
+			t.Errorf("block %d: have %v, want %v", i, errs[i], want)
+		}
+	}
+}
+
+func TestValidateBodyRecentKnownBlocks(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(&ValidationConfig{RecentKnownBlocks: 4})
+	child := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), nil, nil, nil, nil)
+
+	if validator.recentKnown.Seen(genesis.Hash()) {
+		t.Fatalf("genesis remembered before validation")
+	}
+	for i := 0; i < 2; i++ {
+		if err := validator.ValidateBody(genesis); !errors.Is(err, ErrKnownBlock) {
+			t.Errorf("attempt %d: have %v, want %v", i, err, ErrKnownBlock)
+		}
+	}
+	if !validator.recentKnown.Seen(genesis.Hash()) {
+		t.Errorf("known genesis not remembered")
+	}
+	if err := validator.ValidateBody(child); err != nil {
+		t.Fatalf("child: have %v, want nil", err)
+	}
+	if validator.recentKnown.Seen(child.Hash()) {
+		t.Errorf("unknown child remembered as known")
+	}
+	// A rollback of the canonical chain may delete the state of remembered
+	// blocks, so they are all forgotten
+	hc := validator.hc
+	hc.currentHeader.Store(genesis.Header())
+	c1 := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), nil, nil, nil, nil)
+	a2 := newTestBlock(newTestChild(c1.Header(), common.Location{0, 0}), []*types.Transaction{newTestTx(0, common.HexToAddress("0x1400000000000000000000000000000000000001"))}, nil, nil, nil)
+	b2 := newTestBlock(newTestChild(c1.Header(), common.Location{0, 0}), nil, nil, nil, nil)
+	writeTestBlocks(hc, c1, a2)
+	hc.currentHeader.Store(a2.Header())
+	rawdb.WriteBlock(hc.headerDb, b2)
+	if err := hc.SetCurrentHeader(b2.Header()); err != nil {
+		t.Fatal(err)
+	}
+	if hc.Rollbacks() != 1 {
+		t.Fatalf("rollback count mismatch: have %d, want 1", hc.Rollbacks())
+	}
+	validator.ValidateBody(child)
+	if validator.recentKnown.Seen(genesis.Hash()) {
+		t.Errorf("known genesis remembered across a rollback")
+	}
+	// Without the option nothing is remembered
+	plain := NewBlockValidator(validator.config, validator.hc, validator.hc.engine)
+	if plain.recentKnown != nil {
+		t.Errorf("recent known blocks tracked without being configured")
+	}
+	if err := plain.ValidateBody(genesis); !errors.Is(err, ErrKnownBlock) {
+		t.Errorf("plain: have %v, want %v", err, ErrKnownBlock)
+	}
+}
+
+func TestValidateBodyEtxDestinations(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	etx := func(to common.Address) *types.Transaction {
+		return types.NewTx(&types.ExternalTx{ChainID: params.TestChainConfig.ChainID, To: &to, Value: new(big.Int), GasTipCap: new(big.Int), GasFeeCap: new(big.Int)})
+	}
+	tests := []struct {
+		name    string
+		to      common.Address
+		wantErr error
+	}{
+		{"sibling zone", common.HexToAddress("0x1e00000000000000000000000000000000000001"), nil},
+		{"other region", common.HexToAddress("0x3c00000000000000000000000000000000000001"), nil},
+		{"own zone", common.HexToAddress("0x1400000000000000000000000000000000000001"), ErrEtxNotCrossChain},
+		{"own region", common.HexToAddress("0x0a00000000000000000000000000000000000001"), ErrEtxNotCrossChain},
+		{"prime", common.HexToAddress("0x0100000000000000000000000000000000000001"), ErrEtxNotCrossChain},
+		{"unroutable", common.HexToAddress("0xff00000000000000000000000000000000000001"), ErrEtxNotCrossChain},
+	}
+	for _, tt := range tests {
+		strict, genesis := newTestValidator(&ValidationConfig{StrictEtxDestinations: true})
+		block := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), nil, nil, []*types.Transaction{etx(tt.to)}, nil)
+		if err := strict.ValidateBody(block); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: strict: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+		// Without strict mode the destinations are not looked at
+		lax := NewBlockValidator(strict.config, strict.hc, strict.hc.engine)
+		if err := lax.ValidateBody(block); err != nil {
+			t.Errorf("%s: lax: have %v, want nil", tt.name, err)
+		}
+	}
+}
+
+func TestValidateBodyTxScope(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	var (
+		inScope    = common.HexToAddress("0x1400000000000000000000000000000000000001")
+		outOfScope = common.HexToAddress("0x3c00000000000000000000000000000000000001")
+	)
+	etx := func(to common.Address) *types.Transaction {
+		return types.NewTx(&types.ExternalTx{ChainID: params.TestChainConfig.ChainID, To: &to, Value: new(big.Int), GasTipCap: new(big.Int), GasFeeCap: new(big.Int)})
+	}
+	emitting := func(to common.Address) *types.Transaction {
+		return types.NewTx(&types.InternalToExternalTx{ChainID: params.TestChainConfig.ChainID, To: &to, Value: new(big.Int), GasTipCap: new(big.Int), GasFeeCap: new(big.Int), ETXGasPrice: new(big.Int), ETXGasTip: new(big.Int), V: new(big.Int), R: new(big.Int), S: new(big.Int)})
+	}
+	creation := types.NewTx(&types.InternalTx{ChainID: params.TestChainConfig.ChainID, GasTipCap: new(big.Int), GasFeeCap: new(big.Int), Value: new(big.Int), V: new(big.Int), R: new(big.Int), S: new(big.Int)})
+
+	tests := []struct {
+		name    string
+		txs     []*types.Transaction
+		wantErr error
+	}{
+		{"in scope", []*types.Transaction{newTestTx(0, inScope), newTestTx(1, common.ZeroAddr)}, nil},
+		{"contract creation", []*types.Transaction{creation}, nil},
+		{"etx emission", []*types.Transaction{emitting(outOfScope)}, nil},
+		{"out of scope", []*types.Transaction{newTestTx(0, inScope), newTestTx(1, outOfScope)}, ErrTxOutOfScope},
+		{"incoming etx out of scope", []*types.Transaction{etx(outOfScope)}, ErrTxOutOfScope},
+	}
+	for _, tt := range tests {
+		strict, genesis := newTestValidator(&ValidationConfig{StrictTxScope: true})
+		block := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), tt.txs, nil, nil, nil)
+		if err := strict.ValidateBody(block); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: strict: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+		// Without strict mode the recipients are not looked at
+		lax := NewBlockValidator(strict.config, strict.hc, strict.hc.engine)
+		if err := lax.ValidateBody(block); err != nil {
+			t.Errorf("%s: lax: have %v, want nil", tt.name, err)
+		}
+	}
+}
+
+func TestCalcGasLimitWithCeiling(t *testing.T) {
+	tests := []struct {
+		parent, desired, max uint64
+		want                 uint64
+	}{
+		{10240000, 20000000, params.MaxGasLimit, 10249999}, // step up towards desired
+		{10240000, 20000000, 10245000, 10245000},           // step up capped by the ceiling
+		{10240000, 10240000, 10000000, 10230001},           // step down towards the ceiling
+		{20480000, 30000000, 10000000, 20460001},           // parent above ceiling ratchets down
+		{10005000, 30000000, 10000000, 10000000},           // last step down lands on the ceiling
+		{10000000, 30000000, 10000000, 10000000},           // at the ceiling stays there
+		{200000, 0, params.MaxGasLimit, 199806},            // desired below the minimum
+		{500, 200000, params.MaxGasLimit, 500},             // tiny parent has no step to take
+		// Corrupt parents near the top of the range saturate instead of wrapping
+		{math.MaxUint64 - 10, math.MaxUint64, math.MaxUint64, math.MaxUint64},
+		{math.MaxUint64 - 1<<50, math.MaxUint64, math.MaxUint64, math.MaxUint64},
+		{math.MaxUint64, 30000000, params.MaxGasLimit, math.MaxUint64 - (math.MaxUint64/params.GasLimitBoundDivisor - 1)},
+	}
+	for _, tt := range tests {
+		if have := CalcGasLimitWithCeiling(tt.parent, tt.desired, tt.max); have != tt.want {
+			t.Errorf("parent %d, desired %d, max %d: have %d, want %d", tt.parent, tt.desired, tt.max, have, tt.want)
+		}
+		if tt.max == params.MaxGasLimit {
+			if have := CalcGasLimit(tt.parent, tt.desired); have != tt.want {
+				t.Errorf("parent %d, desired %d: have %d, want %d", tt.parent, tt.desired, have, tt.want)
+			}
+		}
+	}
+}
+
+func TestCalcGasLimitForContext(t *testing.T) {
+	const (
+		region = 30000000
+		share  = region / params.SubGasLimitDivisor
+	)
+	tests := []struct {
+		name                 string
+		ctx                  int
+		parent, dom, desired uint64
+		want                 uint64
+	}{
+		{"zone below share grows", common.ZONE_CTX, share - 1000, region, params.MaxGasLimit, share},
+		{"zone at share is held", common.ZONE_CTX, share, region, 2 * share, share},
+		{"zone above share shrinks", common.ZONE_CTX, 2 * share, region, 2 * share, 2*share - (2*share/params.GasLimitBoundDivisor - 1)},
+		{"zone under desired", common.ZONE_CTX, share, region, share / 2, share - (share/params.GasLimitBoundDivisor - 1)},
+		{"region bounded by prime", common.REGION_CTX, share, region, params.MaxGasLimit, share},
+		{"tiny dom keeps minimum", common.ZONE_CTX, params.MinGasLimit, 0, params.MaxGasLimit, params.MinGasLimit},
+		{"prime ignores dom", common.PRIME_CTX, region, 0, 2 * region, region + (region/params.GasLimitBoundDivisor - 1)},
+	}
+	for _, tt := range tests {
+		if have := CalcGasLimitForContext(tt.ctx, tt.parent, tt.dom, tt.desired); have != tt.want {
+			t.Errorf("%s: have %d, want %d", tt.name, have, tt.want)
+		}
+	}
+	// However high the desired limit, a zone never passes its share
+	limit := uint64(params.MinGasLimit)
+	for i := 0; i < 5000; i++ {
+		limit = CalcGasLimitForContext(common.ZONE_CTX, limit, region, params.MaxGasLimit)
+		if limit > share {
+			t.Fatalf("block %d: gas limit %d exceeds share %d", i, limit, share)
+		}
+	}
+	if limit != share {
+		t.Errorf("gas limit settled at %d, want %d", limit, share)
+	}
+}
+
+func TestValidateStateStages(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(nil)
+
+	db := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, _ := state.New(common.Hash{}, db, nil)
+	header := newTestChild(genesis.Header(), common.Location{0, 0})
+	root, err := validator.ComputeExpectedRoot(statedb, header.Number())
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.SetRoot(root)
+
+	// CopyHeader shares the gas and bloom fields, so build each header anew
+	tamper := func(fn func(*types.Header)) *types.Block {
+		header := newTestChild(genesis.Header(), common.Location{0, 0})
+		header.SetRoot(root)
+		fn(header)
+		return types.NewBlockWithHeader(header)
+	}
+	// A nil state database is never touched, since the cheap stages fail first
+	tests := []struct {
+		name    string
+		block   *types.Block
+		statedb *state.StateDB
+		stage   string
+	}{
+		{"gas", tamper(func(h *types.Header) { h.SetGasUsed(1) }), nil, StateStageGas},
+		{"bloom", tamper(func(h *types.Header) { h.SetBloom(types.BytesToBloom([]byte{1})) }), nil, StateStageBloom},
+		{"receipts", tamper(func(h *types.Header) { h.SetReceiptHash(common.Hash{1}) }), nil, StateStageReceipts},
+		{"etxs", tamper(func(h *types.Header) { h.SetEtxHash(common.Hash{1}) }), nil, StateStageEtxs},
+		{"etx rollup", tamper(func(h *types.Header) { h.SetEtxRollupHash(common.Hash{1}) }), nil, StateStageEtxRollup},
+		{"root", tamper(func(h *types.Header) { h.SetRoot(common.Hash{1}) }), statedb, StateStageRoot},
+	}
+	for _, tt := range tests {
+		var stageErr *StateCheckError
+		if err := validator.ValidateState(tt.block, tt.statedb, nil, 0); !errors.As(err, &stageErr) || stageErr.Stage != tt.stage {
+			t.Errorf("%s: have %v, want failure at %s", tt.name, err, tt.stage)
+		}
+		err := validator.ValidateStateShallow(tt.block, nil, 0)
+		if tt.stage == StateStageRoot {
+			if err != nil {
+				t.Errorf("%s: shallow validation failed: %v", tt.name, err)
+			}
+		} else if !errors.As(err, &stageErr) || stageErr.Stage != tt.stage {
+			t.Errorf("%s: shallow: have %v, want failure at %s", tt.name, err, tt.stage)
+		}
+	}
+	block := types.NewBlockWithHeader(header)
+	if err := validator.ValidateState(block, statedb, nil, 0); err != nil {
+		t.Errorf("valid state rejected: %v", err)
+	}
+	if err := validator.ValidateStateShallow(block, nil, 0); err != nil {
+		t.Errorf("valid state rejected by shallow validation: %v", err)
+	}
+}
+
+func TestValidateStateWithRoot(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(nil)
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.AddBalance(common.HexToAddress("0x1400000000000000000000000000000000000001"), big.NewInt(1))
+	header := newTestChild(genesis.Header(), common.Location{0, 0})
+	want, err := validator.ComputeExpectedRoot(statedb, header.Number())
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.SetRoot(want)
+	if root, err := validator.ValidateStateWithRoot(types.NewBlockWithHeader(header), statedb, nil, 0); err != nil || root != want {
+		t.Errorf("valid state: have %x (%v), want %x", root, err, want)
+	}
+	// A mismatching root is reported along with the computed one
+	header = newTestChild(genesis.Header(), common.Location{0, 0})
+	header.SetRoot(common.Hash{1})
+	root, err := validator.ValidateStateWithRoot(types.NewBlockWithHeader(header), statedb, nil, 0)
+	var stageErr *StateCheckError
+	if !errors.As(err, &stageErr) || stageErr.Stage != StateStageRoot || root != want {
+		t.Errorf("bad root: have %x (%v), want %x with failure at %s", root, err, want, StateStageRoot)
+	}
+	// No root is computed if a cheaper stage fails first
+	header = newTestChild(genesis.Header(), common.Location{0, 0})
+	header.SetGasUsed(1)
+	if root, err := validator.ValidateStateWithRoot(types.NewBlockWithHeader(header), nil, nil, 0); err == nil || root != (common.Hash{}) {
+		t.Errorf("bad gas: have %x (%v), want zero root with error", root, err)
+	}
+}
+
+func TestValidationHooks(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	errTooManyTxs := errors.New("too many transactions")
+	errRejected := errors.New("rejected")
+
+	var calls []string
+	maxTxs := func(block *types.Block) error {
+		calls = append(calls, "maxTxs")
+		if len(block.Transactions()) > 1 {
+			return errTooManyTxs
+		}
+		return nil
+	}
+	reject := func(*types.Block) error {
+		calls = append(calls, "reject")
+		return errRejected
+	}
+	rejectState := func(*types.Block, *state.StateDB, types.Receipts) error {
+		calls = append(calls, "rejectState")
+		return errRejected
+	}
+	validator, genesis := newTestValidator(&ValidationConfig{
+		BodyHooks:  []BodyValidatorFunc{maxTxs, reject},
+		StateHooks: []StateValidatorFunc{rejectState},
+	})
+	to := common.HexToAddress("0x1400000000000000000000000000000000000001")
+	single := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), []*types.Transaction{newTestTx(0, to)}, nil, nil, nil)
+	double := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), []*types.Transaction{newTestTx(0, to), newTestTx(1, to)}, nil, nil, nil)
+
+	tests := []struct {
+		name      string
+		block     *types.Block
+		wantErr   error
+		wantCalls []string
+	}{
+		{"built-in failure", genesis, ErrKnownBlock, nil},
+		{"tampered body", single.WithBody(double.Transactions(), nil, nil, nil), ErrTxRootMismatch, nil},
+		{"first hook fails", double, errTooManyTxs, []string{"maxTxs"}},
+		{"second hook fails", single, errRejected, []string{"maxTxs", "reject"}},
+	}
+	for _, tt := range tests {
+		calls = nil
+		if err := validator.ValidateBody(tt.block); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: have %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if fmt.Sprint(calls) != fmt.Sprint(tt.wantCalls) {
+			t.Errorf("%s: hooks called %v, want %v", tt.name, calls, tt.wantCalls)
+		}
+	}
+	// State hooks run after the state root only
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	header := newTestChild(genesis.Header(), common.Location{0, 0})
+	header.SetRoot(common.Hash{1})
+
+	calls = nil
+	var stageErr *StateCheckError
+	if err := validator.ValidateState(types.NewBlockWithHeader(header), statedb, nil, 0); !errors.As(err, &stageErr) || stageErr.Stage != StateStageRoot {
+		t.Errorf("bad root: have %v, want failure at %s", err, StateStageRoot)
+	}
+	root, err := validator.ComputeExpectedRoot(statedb, header.Number())
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.SetRoot(root)
+	block := types.NewBlockWithHeader(header)
+	if err := validator.ValidateStateShallow(block, nil, 0); err != nil {
+		t.Errorf("shallow: have %v, want nil", err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("state hooks called %v before passing the built-in checks", calls)
+	}
+	err = validator.ValidateState(block, statedb, nil, 0)
+	if !errors.Is(err, errRejected) || !errors.As(err, &stageErr) || stageErr.Stage != StateStageHooks {
+		t.Errorf("hook: have %v, want %v at %s", err, errRejected, StateStageHooks)
+	}
+}
+
+func TestValidateStateReceiptGas(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	receipts := func(cumulative ...uint64) types.Receipts {
+		var receipts types.Receipts
+		for _, gas := range cumulative {
+			receipts = append(receipts, &types.Receipt{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: gas, Logs: []*types.Log{}})
+		}
+		return receipts
+	}
+	tests := []struct {
+		name     string
+		receipts types.Receipts
+		usedGas  uint64
+		wantErr  error
+	}{
+		{"non-monotonic", receipts(42000, 21000), 21000, ErrReceiptGasNonMonotonic},
+		{"short of gas used", receipts(21000, 42000), 50000, ErrReceiptGasMismatch},
+		{"beyond gas used", receipts(21000, 42000), 21000, ErrReceiptGasMismatch},
+		{"gas used without receipts", nil, 21000, ErrReceiptGasMismatch},
+	}
+	validator, genesis := newTestValidator(nil)
+	trusted, _ := newTestValidator(&ValidationConfig{SkipReceiptGasChecks: true})
+	for _, tt := range tests {
+		header := newTestChild(genesis.Header(), common.Location{0, 0})
+		header.SetGasUsed(tt.usedGas)
+		block := types.NewBlockWithHeader(header)
+
+		err := validator.ValidateStateShallow(block, tt.receipts, tt.usedGas)
+		var stageErr *StateCheckError
+		if !errors.Is(err, tt.wantErr) || !errors.As(err, &stageErr) || stageErr.Stage != StateStageGas {
+			t.Errorf("%s: have %v, want %v at %s", tt.name, err, tt.wantErr, StateStageGas)
+		}
+		// Trusted execution skips the checks, leaving only the later stages
+		err = trusted.ValidateStateShallow(block, tt.receipts, tt.usedGas)
+		if errors.As(err, &stageErr) && stageErr.Stage == StateStageGas {
+			t.Errorf("%s: unchecked: have %v, want no failure at %s", tt.name, err, StateStageGas)
+		}
+	}
+}
+
+func TestValidateStateEtxOrder(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(nil)
+	to := common.HexToAddress("0x3c00000000000000000000000000000000000001")
+	a, b, c := newTestTx(0, to), newTestTx(1, to), newTestTx(2, to)
+
+	receipt := func(status uint64, etxs ...*types.Transaction) *types.Receipt {
+		return &types.Receipt{Status: status, Etxs: etxs, Logs: []*types.Log{}}
+	}
+	tests := []struct {
+		name     string
+		receipts types.Receipts
+		wantErr  bool
+		index    int
+	}{
+		{"swapped", types.Receipts{receipt(types.ReceiptStatusSuccessful, b), receipt(types.ReceiptStatusSuccessful, a)}, true, 0},
+		{"missing", types.Receipts{receipt(types.ReceiptStatusSuccessful, a)}, true, 1},
+		{"extra", types.Receipts{receipt(types.ReceiptStatusSuccessful, a, b, c)}, true, 2},
+		{"matching", types.Receipts{receipt(types.ReceiptStatusSuccessful, a, b)}, false, 0},
+		{"failed emission ignored", types.Receipts{receipt(types.ReceiptStatusSuccessful, a, b), receipt(types.ReceiptStatusFailed, c)}, false, 0},
+	}
+	for _, tt := range tests {
+		etxs := types.Transactions{a, b}
+		header := newTestChild(genesis.Header(), common.Location{0, 0})
+		header.SetEtxHash(types.DeriveSha(etxs, trie.NewStackTrie(nil)))
+		header.SetReceiptHash(types.DeriveSha(tt.receipts, trie.NewStackTrie(nil)))
+		block := types.NewBlockWithHeader(header).WithBody(nil, nil, etxs, nil)
+
+		err := validator.ValidateStateShallow(block, tt.receipts, 0)
+		if !tt.wantErr {
+			if errors.Is(err, ErrEtxOrderMismatch) {
+				t.Errorf("%s: unexpected order mismatch: %v", tt.name, err)
+			}
+			continue
+		}
+		var stageErr *StateCheckError
+		if !errors.Is(err, ErrEtxOrderMismatch) || !errors.As(err, &stageErr) || stageErr.Stage != StateStageEtxs {
+			t.Errorf("%s: have %v, want %v at %s", tt.name, err, ErrEtxOrderMismatch, StateStageEtxs)
+			continue
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("etx %d ", tt.index)) {
+			t.Errorf("%s: error %q does not name index %d", tt.name, err, tt.index)
+		}
+	}
+}
+
+func TestValidateStateEtxRollupMismatch(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(&ValidationConfig{LogEtxRollupEntries: true})
+
+	header := newTestChild(genesis.Header(), common.Location{0, 0})
+	header.SetEtxRollupHash(common.Hash{1})
+	err := validator.ValidateStateShallow(types.NewBlockWithHeader(header), nil, 0)
+
+	var mismatch *EtxRollupMismatchError
+	if !errors.Is(err, ErrEtxRollupMismatch) || !errors.As(err, &mismatch) {
+		t.Fatalf("have %v, want %v", err, ErrEtxRollupMismatch)
+	}
+	if want := types.EmptyRootHash; mismatch.Have != want {
+		t.Errorf("collected rollup hash %x, want %x", mismatch.Have, want)
+	}
+	if mismatch.Want != (common.Hash{1}) {
+		t.Errorf("claimed rollup hash %x, want %x", mismatch.Want, common.Hash{1})
+	}
+	if mismatch.RollupLen != 0 {
+		t.Errorf("rollup length %d, want 0", mismatch.RollupLen)
+	}
+}
+
+func TestDiffBloom(t *testing.T) {
+	var (
+		addr   = common.HexToAddress("0x1400000000000000000000000000000000000001")
+		other  = common.HexToAddress("0x1400000000000000000000000000000000000002")
+		topicA = common.HexToHash("0xa1")
+		topicB = common.HexToHash("0xb2")
+	)
+	receipts := types.Receipts{
+		{Logs: []*types.Log{{Address: addr, Topics: []common.Hash{topicA}}}},
+		{Logs: []*types.Log{{Address: other, Topics: []common.Hash{topicA, topicB}}}},
+	}
+	bloom := types.CreateBloom(receipts)
+	if missing := DiffBloom(receipts, bloom); len(missing) != 0 {
+		t.Errorf("matching bloom: have %x, want none", missing)
+	}
+	// A header bloom built from the first receipt only lacks the second's entries
+	partial := types.CreateBloom(receipts[:1])
+	want := []common.Hash{common.BytesToHash(other.Bytes()), topicB}
+	missing := DiffBloom(receipts, partial)
+	if len(missing) != len(want) {
+		t.Fatalf("partial bloom: have %x, want %x", missing, want)
+	}
+	for i := range want {
+		if missing[i] != want[i] {
+			t.Errorf("partial bloom entry %d: have %x, want %x", i, missing[i], want[i])
+		}
+	}
+	if missing := DiffBloom(receipts, types.Bloom{}); len(missing) != 4 {
+		t.Errorf("empty bloom: have %d missing entries, want 4", len(missing))
+	}
+
+	// ValidateState reports the count only when diagnosing blooms
+	setTestNodeLocation(t, common.Location{0, 0})
+	validator, genesis := newTestValidator(&ValidationConfig{DiagnoseBloom: true})
+	header := newTestChild(genesis.Header(), common.Location{0, 0})
+	header.SetBloom(partial)
+	err := validator.ValidateStateShallow(types.NewBlockWithHeader(header), receipts, 0)
+	if err == nil || !strings.Contains(err.Error(), "2 receipt log entries missing") {
+		t.Errorf("diagnosed bloom mismatch: have %v", err)
+	}
+}
+
+// testMetricsSink is a ValidationMetricsSink counting increments per counter
+// and location name.
+type testMetricsSink map[string]int
+
+func (s testMetricsSink) IncCounter(name string, loc common.Location) {
+	s[loc.Name()+"/"+name]++
+}
+
+func TestValidationMetrics(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	base, genesis := newTestValidator(nil)
+	sink := make(testMetricsSink)
+	validator := NewBlockValidatorForLocation(base.config, base.hc, base.hc.engine, nil, common.Location{0, 0}, sink)
+
+	// A valid block counts as validated once its state passes
+	db := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, _ := state.New(common.Hash{}, db, nil)
+	header := newTestChild(genesis.Header(), common.Location{0, 0})
+	root, err := validator.ComputeExpectedRoot(statedb, header.Number())
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.SetRoot(root)
+	block := newTestBlock(header, nil, nil, nil, nil)
+	if err := validator.ValidateBody(block); err != nil {
+		t.Fatalf("valid body rejected: %v", err)
+	}
+	if err := validator.ValidateState(block, statedb, nil, 0); err != nil {
+		t.Fatalf("valid state rejected: %v", err)
+	}
+	// Failing either step counts as a failure
+	bad := types.CopyHeader(header)
+	bad.SetCoinbase(common.ZeroAddr)
+	if err := validator.ValidateBody(newTestBlock(bad, nil, nil, nil, nil)); err == nil {
+		t.Fatal("invalid body accepted")
+	}
+	bad = types.CopyHeader(header)
+	bad.SetRoot(common.Hash{0x01})
+	if err := validator.ValidateState(newTestBlock(bad, nil, nil, nil, nil), statedb, nil, 0); err == nil {
+		t.Fatal("invalid state accepted")
+	}
+	// CopyHeader shares the gas used field, so build the header anew
+	bad = newTestChild(genesis.Header(), common.Location{0, 0})
+	bad.SetGasUsed(1)
+	if err := validator.ValidateStateShallow(newTestBlock(bad, nil, nil, nil, nil), nil, 0); err == nil {
+		t.Fatal("invalid gas used accepted")
+	}
+	want := testMetricsSink{
+		"cyprus1/" + blocksValidatedCounter:               1,
+		"cyprus1/" + validationFailuresCounter:            3,
+		"cyprus1/" + stateFailureCounters[StateStageRoot]: 1,
+		"cyprus1/" + stateFailureCounters[StateStageGas]:  1,
+	}
+	if len(sink) != len(want) {
+		t.Errorf("counters mismatch: have %v, want %v", sink, want)
+	}
+	for name, count := range want {
+		if sink[name] != count {
+			t.Errorf("counter %s: have %d, want %d", name, sink[name], count)
+		}
+	}
+}
+
+// tamperBlock returns a copy of block with the field selected by field
+// overwritten with values derived from data, and whether the copy differs from
+// the original in a way ValidateBody is responsible for catching.
+func tamperBlock(block *types.Block, field uint8, data []byte) (*types.Block, bool) {
+	var (
+		header      = types.CopyHeader(block.Header())
+		txs         = block.Transactions()
+		etxs        = block.ExtTransactions()
+		uncles      = block.Uncles()
+		subManifest = block.SubManifest()
+		nodeCtx     = common.GetNodeLocation().Context()
+		junk        = crypto.Keccak256Hash(data)
+		tampered    = true
+	)
+	switch field % 9 {
+	case 0:
+		header.SetTxHash(junk)
+	case 1:
+		header.SetEtxHash(junk)
+	case 2:
+		header.SetUncleHash(junk)
+	case 3:
+		header.SetManifestHash(junk, nodeCtx+1)
+	case 4:
+		txs = txs[:len(data)%(len(txs)+1)]
+		tampered = len(txs) != len(block.Transactions())
+	case 5:
+		// Number linkage is verified by the engine alongside the header, so
+		// only check that an arbitrary (or zero) number cannot panic
+		header.SetNumber(new(big.Int).SetBytes(data), nodeCtx)
+		tampered = false
+	case 6:
+		// Gas is verified by the engine and state validation, not the body
+		header.SetGasLimit(new(big.Int).SetBytes(data).Uint64())
+		header.SetGasUsed(uint64(len(data)))
+		tampered = false
+	case 7:
+		subManifest = append(append(types.BlockManifest{}, subManifest...), junk)
+	case 8:
+		header.SetParentHash(junk)
+	}
+	return types.NewBlockWithHeader(header).WithBody(txs, uncles, etxs, subManifest), tampered
+}
+
+func FuzzValidateBody(f *testing.F) {
+	setTestNodeLocation(f, common.Location{0})
+	validator, genesis := newTestValidator(nil)
+
+	txs := []*types.Transaction{newTestTx(0, common.HexToAddress("0x0a")), newTestTx(1, common.HexToAddress("0x0b"))}
+	subManifest := types.BlockManifest{common.HexToHash("0x01")}
+	good := newTestBlock(newTestChild(genesis.Header(), common.Location{0}), txs, nil, nil, subManifest)
+	if err := validator.ValidateBody(good); err != nil {
+		f.Fatalf("valid block rejected: %v", err)
+	}
+	for field := uint8(0); field < 9; field++ {
+		f.Add(field, []byte{})
+		f.Add(field, []byte{0x01})
+		f.Add(field, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	}
+	f.Fuzz(func(t *testing.T, field uint8, data []byte) {
+		block, tampered := tamperBlock(good, field, data)
+		if err := validator.ValidateBody(block); tampered && err == nil {
+			t.Fatalf("tampered block accepted (field %d, data %x)", field%9, data)
+		}
+	})
+}
+
+// BenchmarkValidateBodyMalformed measures how fast ValidateBody rejects blocks
+// whose bodies do not match their transaction roots, as sent in a flood of
+// malformed blocks. The roots are checked before the uncles are verified.
+func BenchmarkValidateBodyMalformed(b *testing.B) {
+	setTestNodeLocation(b, common.Location{0, 0})
+	validator, genesis := newTestValidator(nil)
+	to := common.HexToAddress("0x1400000000000000000000000000000000000001")
+	txs := make([]*types.Transaction, 64)
+	for i := range txs {
+		txs[i] = newTestTx(uint64(i), to)
+	}
+	uncles := []*types.Header{newTestChild(genesis.Header(), common.Location{0, 0})}
+	block := newTestBlock(newTestChild(genesis.Header(), common.Location{0, 0}), txs, uncles, nil, nil)
+
+	malformed := []struct {
+		name  string
+		block *types.Block
+	}{
+		{"transactions", block.WithBody(txs[1:], uncles, nil, nil)},
+		{"external transactions", block.WithBody(txs, uncles, txs[:1], nil)},
+	}
+	for _, tt := range malformed {
+		block := tt.block
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := validator.ValidateBody(block); err == nil {
+					b.Fatal("malformed block accepted")
+				}
+			}
+		})
+	}
+}
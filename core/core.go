@@ -57,7 +57,7 @@ func NewCore(db ethdb.Database, config *Config, isLocalBlock func(block *types.H
 }
 
 func (c *Core) InsertChain(blocks types.Blocks) (int, error) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	domWait := false
 	for i, block := range blocks {
 		isCoincident := c.sl.engine.IsDomCoincident(block.Header())
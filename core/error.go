@@ -18,7 +18,9 @@ package core
 
 import (
 	"errors"
+	"fmt"
 
+	"github.com/dominant-strategies/go-quai/common"
 	"github.com/dominant-strategies/go-quai/core/types"
 )
 
@@ -41,6 +43,9 @@ var (
 	// ErrDomClientNotUp is returned when block is trying to be appended when domClient is not up.
 	ErrDomClientNotUp = errors.New("dom client is not online")
 
+	// ErrBadManifest is returned when a block's manifest does not match the manifest hash of its own context
+	ErrBadManifest = errors.New("manifest does not match hash")
+
 	// ErrBadSubManifest is returned when a block's subordinate manifest does not match the subordinate manifest hash
 	ErrBadSubManifest = errors.New("subordinate manifest is incorrect")
 
@@ -49,8 +54,146 @@ var (
 
 	//ErrPendingEtxNotFound is returned when pendingEtxs cannot be found for a hash given in the submanifest
 	ErrPendingEtxNotFound = errors.New("pending etx not found")
+
+	// ErrCheckpointMismatch is returned when a block at a checkpoint height does not match the checkpoint
+	ErrCheckpointMismatch = errors.New("block does not match checkpoint")
+
+	// ErrBadBlockNumber is returned when a block's number is inconsistent with the numbering of its location
+	ErrBadBlockNumber = errors.New("block number inconsistent with location")
+
+	// ErrUncleWrongSlice is returned when an uncle originates from a different slice than the including block
+	ErrUncleWrongSlice = errors.New("uncle from different slice")
+
+	// ErrZeroDifficulty is returned when a block's difficulty is not positive
+	ErrZeroDifficulty = errors.New("non-positive difficulty")
+
+	// ErrDifficultyTooHigh is returned when a block's difficulty exceeds the configured maximum
+	ErrDifficultyTooHigh = errors.New("difficulty too high")
+
+	// ErrParentWrongLocation is returned when a block's parent belongs to a different chain than the block
+	ErrParentWrongLocation = errors.New("parent on different chain")
+
+	// ErrEtxValueImbalance is returned when the value of the ETXs emitted by a transaction differs from the value it debited
+	ErrEtxValueImbalance = errors.New("etx value does not match debited value")
+
+	// ErrInvalidGenesisState is returned when the genesis state root does not match the genesis allocation
+	ErrInvalidGenesisState = errors.New("genesis state root does not match allocation")
+
+	// ErrEquivocation is returned when a conflicting sibling of a block with the same timestamp is already known
+	ErrEquivocation = errors.New("equivocating block")
+
+	// ErrManifestEtxMismatch is returned when a block's ETXs differ from the ETXs promised by its subordinate manifest
+	ErrManifestEtxMismatch = errors.New("etxs do not match subordinate manifest")
+
+	// ErrReorgTooDeep is returned when importing a block would reorganize the chain deeper than allowed
+	ErrReorgTooDeep = errors.New("reorg too deep")
+
+	// ErrEtxRollupNotReset is returned when the ETX rollup of a block does not restart after a coincident parent
+	ErrEtxRollupNotReset = errors.New("etx rollup not reset at coincident boundary")
+
+	// ErrZeroCoinbase is returned when a reward-bearing block pays its reward to the zero address
+	ErrZeroCoinbase = errors.New("zero coinbase")
+
+	// ErrTooManyUncles is returned when a block includes more uncles than allowed
+	ErrTooManyUncles = errors.New("too many uncles")
+
+	// ErrDuplicateUncle is returned when a block includes the same uncle twice, or itself as an uncle
+	ErrDuplicateUncle = errors.New("duplicate uncle")
+
+	// ErrUncleDepth is returned when an uncle is not within the allowed generations below the including block
+	ErrUncleDepth = errors.New("uncle depth outside allowed window")
+
+	// ErrUncleRootMismatch is returned when a block's uncles do not match the uncle hash of its header
+	ErrUncleRootMismatch = errors.New("uncle root hash mismatch")
+
+	// ErrTxRootMismatch is returned when a block's transactions do not match the transaction root of its header
+	ErrTxRootMismatch = errors.New("transaction root hash mismatch")
+
+	// ErrEtxRootMismatch is returned when a block's external transactions do not match the etx root of its header
+	ErrEtxRootMismatch = errors.New("external transaction root hash mismatch")
+
+	// ErrEtxNotCrossChain is returned when an external transaction is destined within the slice of the node validating it
+	ErrEtxNotCrossChain = errors.New("etx destined within the node's slice")
+
+	// ErrEtxRollupMismatch is returned when the ETX rollup collected for a block does not match the rollup hash of its header
+	ErrEtxRollupMismatch = errors.New("invalid etx rollup hash")
+
+	// ErrManifestDuplicate is returned when a subordinate manifest lists the same block twice
+	ErrManifestDuplicate = errors.New("duplicate subordinate manifest entry")
+
+	// ErrReceiptGasNonMonotonic is returned when the cumulative gas used of a block's receipts decreases
+	ErrReceiptGasNonMonotonic = errors.New("receipt cumulative gas decreases")
+
+	// ErrReceiptGasMismatch is returned when the cumulative gas used of a block's last receipt differs from the gas used by the block
+	ErrReceiptGasMismatch = errors.New("receipt cumulative gas does not match gas used")
+
+	// ErrTxOutOfScope is returned when a zone block includes a transaction to a recipient outside of the zone
+	ErrTxOutOfScope = errors.New("transaction recipient out of chain scope")
+
+	// ErrEtxOrderMismatch is returned when the ETXs emitted by a block's transactions differ from the block's ETXs
+	ErrEtxOrderMismatch = errors.New("emitted etxs do not match block etxs")
 )
 
+// HashMismatchError reports a block body whose derived hash differs from the
+// hash committed to in its header. Err is the sentinel naming the mismatching
+// part of the body, so errors.Is works on the sentinel while errors.As gives
+// access to both hashes.
+type HashMismatchError struct {
+	Err  error       // Sentinel error naming the mismatching part
+	Have common.Hash // Hash derived from the body
+	Want common.Hash // Hash committed to in the header
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("%v: have %x, want %x", e.Err, e.Have, e.Want)
+}
+
+func (e *HashMismatchError) Unwrap() error {
+	return e.Err
+}
+
+// EtxRollupMismatchError reports a block whose ETX rollup, collected from the
+// ETXs emitted since the last coincident block, hashes differently than the
+// header claims. It unwraps to ErrEtxRollupMismatch.
+type EtxRollupMismatchError struct {
+	Have      common.Hash // Hash of the collected rollup
+	Want      common.Hash // Rollup hash committed to in the header
+	RollupLen int         // Number of ETXs in the collected rollup
+}
+
+func (e *EtxRollupMismatchError) Error() string {
+	return fmt.Sprintf("%v (remote: %x local: %x, %d etxs rolled up)", ErrEtxRollupMismatch, e.Want, e.Have, e.RollupLen)
+}
+
+func (e *EtxRollupMismatchError) Unwrap() error {
+	return ErrEtxRollupMismatch
+}
+
+// Stages of ValidateState, in the order they are checked.
+const (
+	StateStageGas       = "gas used"
+	StateStageBloom     = "bloom"
+	StateStageReceipts  = "receipt root"
+	StateStageEtxs      = "etxs"
+	StateStageEtxRollup = "etx rollup"
+	StateStageRoot      = "state root"
+	StateStageHooks     = "hooks"
+)
+
+// StateCheckError reports the stage at which a block failed ValidateState.
+type StateCheckError struct {
+	Stage string // Failed stage, one of the StateStage constants
+	Err   error  // Reason the stage failed
+}
+
+func (e *StateCheckError) Error() string {
+	return fmt.Sprintf("state validation failed at %s: %v", e.Stage, e.Err)
+}
+
+func (e *StateCheckError) Unwrap() error {
+	return e.Err
+}
+
 // List of evm-call-message pre-checking errors. All state transition messages will
 // be pre-checked before execution. If any invalidation detected, the corresponding
 // error should be returned which is defined here.
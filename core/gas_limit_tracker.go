@@ -0,0 +1,64 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/dominant-strategies/go-quai/params"
+)
+
+// GasLimitTracker keeps an exponential moving average of the gas used by the
+// recent blocks of a chain, from which it derives the desired gas limit to
+// feed into CalcGasLimit. It is safe for concurrent use.
+type GasLimitTracker struct {
+	window uint64
+	avg    uint64 // moving average of the recorded gas used
+	primed bool   // whether a value has been recorded yet
+	lock   sync.Mutex
+}
+
+// NewGasLimitTracker creates a tracker averaging over roughly the last window
+// blocks: each recorded value is weighted 2/(window+1), as for an N-period
+// EMA. A window below one is treated as one, so that the average is simply the
+// last recorded value.
+func NewGasLimitTracker(window int) *GasLimitTracker {
+	if window < 1 {
+		window = 1
+	}
+	return &GasLimitTracker{window: uint64(window)}
+}
+
+// Record adds the gas used by a block to the moving average. The first value
+// recorded seeds the average.
+func (t *GasLimitTracker) Record(gasUsed uint64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.primed {
+		t.avg, t.primed = gasUsed, true
+		return
+	}
+	// Split the weighting to keep 2*delta from overflowing
+	n := t.window + 1
+	if gasUsed > t.avg {
+		delta := gasUsed - t.avg
+		t.avg += delta/n*2 + delta%n*2/n
+	} else {
+		delta := t.avg - gasUsed
+		t.avg -= delta/n*2 + delta%n*2/n
+	}
+}
+
+// Target returns the desired gas limit: the average gas used scaled by
+// params.ElasticityMultiplier, so that blocks at the average usage are filled
+// to their target rather than to capacity, capped at params.MaxGasLimit. It is
+// zero until a value is recorded, which CalcGasLimit treats as
+// params.MinGasLimit.
+func (t *GasLimitTracker) Target() uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.avg > params.MaxGasLimit/params.ElasticityMultiplier {
+		return params.MaxGasLimit
+	}
+	return t.avg * params.ElasticityMultiplier
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/params"
+)
+
+func TestGasLimitTrackerConverges(t *testing.T) {
+	const (
+		window = 20
+		low    = 1000000
+		high   = 8000000
+	)
+	tracker := NewGasLimitTracker(window)
+	if target := tracker.Target(); target != 0 {
+		t.Fatalf("empty tracker: have target %d, want 0", target)
+	}
+	tracker.Record(low)
+	if target := tracker.Target(); target != low*params.ElasticityMultiplier {
+		t.Fatalf("seeded tracker: have target %d, want %d", target, low*params.ElasticityMultiplier)
+	}
+	// Sustained high usage pulls the target up monotonically
+	prev := tracker.Target()
+	for i := 0; i < 5*window; i++ {
+		tracker.Record(high)
+		target := tracker.Target()
+		if target < prev || target > high*params.ElasticityMultiplier {
+			t.Fatalf("block %d: target %d moved away from %d (previous %d)", i, target, high*params.ElasticityMultiplier, prev)
+		}
+		prev = target
+	}
+	if want := uint64(high * params.ElasticityMultiplier); want-prev > want/100 {
+		t.Fatalf("high usage: target %d not within 1%% of %d after %d blocks", prev, want, 5*window)
+	}
+	// And sustained low usage pulls it back down
+	for i := 0; i < 5*window; i++ {
+		tracker.Record(low)
+		target := tracker.Target()
+		if target > prev || target < low*params.ElasticityMultiplier {
+			t.Fatalf("block %d: target %d moved away from %d (previous %d)", i, target, low*params.ElasticityMultiplier, prev)
+		}
+		prev = target
+	}
+	if want := uint64(low * params.ElasticityMultiplier); prev-want > want/100 {
+		t.Fatalf("low usage: target %d not within 1%% of %d after %d blocks", prev, want, 5*window)
+	}
+}
+
+func TestGasLimitTrackerWindow(t *testing.T) {
+	// A shorter window reacts faster to a change in usage
+	short, long := NewGasLimitTracker(4), NewGasLimitTracker(64)
+	for _, tracker := range []*GasLimitTracker{short, long} {
+		tracker.Record(1000000)
+		for i := 0; i < 4; i++ {
+			tracker.Record(5000000)
+		}
+	}
+	if short.Target() <= long.Target() {
+		t.Errorf("short window target %d not above long window target %d", short.Target(), long.Target())
+	}
+	// A window below one tracks the last value only
+	for _, window := range []int{0, -1, 1} {
+		tracker := NewGasLimitTracker(window)
+		tracker.Record(1000000)
+		tracker.Record(3000000)
+		if want := uint64(3000000 * params.ElasticityMultiplier); tracker.Target() != want {
+			t.Errorf("window %d: have target %d, want %d", window, tracker.Target(), want)
+		}
+	}
+	// Extreme usage must neither overflow nor exceed the maximum gas limit
+	tracker := NewGasLimitTracker(8)
+	tracker.Record(0)
+	for i := 0; i < 100; i++ {
+		tracker.Record(^uint64(0))
+	}
+	if target := tracker.Target(); target != params.MaxGasLimit {
+		t.Errorf("extreme usage: have target %d, want %d", target, params.MaxGasLimit)
+	}
+}
+
+func TestGasLimitTrackerConcurrent(t *testing.T) {
+	tracker := NewGasLimitTracker(16)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				tracker.Record(2000000)
+				tracker.Target()
+			}
+		}()
+	}
+	wg.Wait()
+	if want := uint64(2000000 * params.ElasticityMultiplier); tracker.Target() != want {
+		t.Errorf("have target %d, want %d", tracker.Target(), want)
+	}
+}
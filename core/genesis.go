@@ -258,7 +258,7 @@ func (g *Genesis) configOrDefault(ghash common.Hash) *params.ChainConfig {
 // ToBlock creates the genesis block and writes state of a genesis specification
 // to the given database (or discards it if nil).
 func (g *Genesis) ToBlock(db ethdb.Database) *types.Block {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if db == nil {
 		db = rawdb.NewMemoryDatabase()
 	}
@@ -322,7 +322,7 @@ func (g *Genesis) ToBlock(db ethdb.Database) *types.Block {
 // Commit writes the block and state of a genesis specification to the database.
 // The block is committed as the canonical head block.
 func (g *Genesis) Commit(db ethdb.Database) (*types.Block, error) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	block := g.ToBlock(db)
 	if block.Number().Sign() != 0 {
 		return nil, fmt.Errorf("can't commit genesis block with number > 0")
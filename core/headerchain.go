@@ -19,6 +19,7 @@ import (
 	"github.com/dominant-strategies/go-quai/ethdb"
 	"github.com/dominant-strategies/go-quai/event"
 	"github.com/dominant-strategies/go-quai/log"
+	"github.com/dominant-strategies/go-quai/metrics"
 	"github.com/dominant-strategies/go-quai/params"
 	"github.com/dominant-strategies/go-quai/rlp"
 	"github.com/dominant-strategies/go-quai/trie"
@@ -28,9 +29,15 @@ import (
 const (
 	headerCacheLimit      = 512
 	numberCacheLimit      = 2048
+	etxRollupCacheLimit   = 256
 	primeHorizonThreshold = 20
 )
 
+var (
+	etxRollupCacheHitMeter  = metrics.NewRegisteredMeter("chain/etxrollup/cache/hits", nil)
+	etxRollupCacheMissMeter = metrics.NewRegisteredMeter("chain/etxrollup/cache/misses", nil)
+)
+
 type HeaderChain struct {
 	config *params.ChainConfig
 
@@ -46,8 +53,9 @@ type HeaderChain struct {
 
 	currentHeader atomic.Value // Current head of the header chain (may be above the block chain!)
 
-	headerCache *lru.Cache // Cache for the most recent block headers
-	numberCache *lru.Cache // Cache for the most recent block numbers
+	headerCache    *lru.Cache // Cache for the most recent block headers
+	numberCache    *lru.Cache // Cache for the most recent block numbers
+	etxRollupCache *lru.Cache // Cache for the inclusive ETX rollups of the most recent blocks
 
 	wg            sync.WaitGroup // chain processing wait group for shutting down
 	running       int32          // 0 if chain is running, 1 when stopped
@@ -55,6 +63,8 @@ type HeaderChain struct {
 
 	headermu sync.RWMutex
 	heads    []*types.Header
+
+	rollbacks uint64 // Number of times the canonical chain was rolled back (atomic)
 }
 
 // NewHeaderChain creates a new HeaderChain structure. ProcInterrupt points
@@ -62,13 +72,19 @@ type HeaderChain struct {
 func NewHeaderChain(db ethdb.Database, engine consensus.Engine, chainConfig *params.ChainConfig, cacheConfig *CacheConfig, vmConfig vm.Config) (*HeaderChain, error) {
 	headerCache, _ := lru.New(headerCacheLimit)
 	numberCache, _ := lru.New(numberCacheLimit)
+	rollupLimit := etxRollupCacheLimit
+	if cacheConfig != nil && cacheConfig.EtxRollupCacheLimit > 0 {
+		rollupLimit = cacheConfig.EtxRollupCacheLimit
+	}
+	etxRollupCache, _ := lru.New(rollupLimit)
 
 	hc := &HeaderChain{
-		config:      chainConfig,
-		headerDb:    db,
-		headerCache: headerCache,
-		numberCache: numberCache,
-		engine:      engine,
+		config:         chainConfig,
+		headerDb:       db,
+		headerCache:    headerCache,
+		numberCache:    numberCache,
+		etxRollupCache: etxRollupCache,
+		engine:         engine,
 	}
 
 	var err error
@@ -136,6 +152,36 @@ func (hc *HeaderChain) collectBlockManifest(h *types.Header) (types.BlockManifes
 	return manifest, nil
 }
 
+// LastCoincidentBlock returns the header of the block the ETX rollup of b
+// starts at, i.e. the closest ancestor of b which is dom coincident or genesis.
+// The rollup committed by b collects the ETXs emitted from that block up to
+// b's parent. The genesis block is its own boundary.
+func (hc *HeaderChain) LastCoincidentBlock(b *types.Block) (*types.Header, error) {
+	if b.NumberU64() == 0 && b.Hash() == hc.config.GenesisHash {
+		return b.Header(), nil
+	}
+	header := hc.GetHeader(b.ParentHash(), b.NumberU64()-1)
+	if header == nil {
+		return nil, fmt.Errorf("parent %x of block %d not found", b.ParentHash(), b.NumberU64())
+	}
+	for {
+		if header.NumberU64() == 0 {
+			if header.Hash() != hc.config.GenesisHash {
+				return nil, fmt.Errorf("rollup builds on incorrect genesis, block0 hash: %s", header.Hash().String())
+			}
+			return header, nil
+		}
+		if hc.engine.IsDomCoincident(header) {
+			return header, nil
+		}
+		ancestor := hc.GetHeader(header.ParentHash(), header.NumberU64()-1)
+		if ancestor == nil {
+			return nil, fmt.Errorf("ancestor %x of block %d not found", header.ParentHash(), header.NumberU64())
+		}
+		header = ancestor
+	}
+}
+
 // Collect all emmitted ETXs since the last coincident block, but excluding
 // those emitted in this block
 func (hc *HeaderChain) CollectEtxRollup(b *types.Block) (types.Transactions, error) {
@@ -149,7 +195,28 @@ func (hc *HeaderChain) CollectEtxRollup(b *types.Block) (types.Transactions, err
 	return hc.collectInclusiveEtxRollup(parent)
 }
 
+// collectInclusiveEtxRollup gathers the ETXs emitted since the last coincident
+// block, including those of b itself. Rollups are cached by block hash, which
+// commits to the whole ancestry the rollup is derived from.
 func (hc *HeaderChain) collectInclusiveEtxRollup(b *types.Block) (types.Transactions, error) {
+	hash := b.Hash()
+	if cached, ok := hc.etxRollupCache.Get(hash); ok {
+		etxRollupCacheHitMeter.Mark(1)
+		return cached.(types.Transactions), nil
+	}
+	etxRollupCacheMissMeter.Mark(1)
+	etxRollup, err := hc.computeInclusiveEtxRollup(b)
+	if err != nil {
+		return nil, err
+	}
+	// Cap the slice, so that appending to a returned rollup never writes into
+	// the cached one
+	etxRollup = etxRollup[:len(etxRollup):len(etxRollup)]
+	hc.etxRollupCache.Add(hash, etxRollup)
+	return etxRollup, nil
+}
+
+func (hc *HeaderChain) computeInclusiveEtxRollup(b *types.Block) (types.Transactions, error) {
 	// Initialize the rollup with ETXs emitted by this block
 	newEtxs := b.ExtTransactions()
 	// Terminate the search if we reached genesis
@@ -179,7 +246,7 @@ func (hc *HeaderChain) collectInclusiveEtxRollup(b *types.Block) (types.Transact
 
 // Append
 func (hc *HeaderChain) Append(batch ethdb.Batch, block *types.Block, newInboundEtxs types.Transactions) error {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	log.Debug("HeaderChain Append:", "Block information: Hash:", block.Hash(), "block header hash:", block.Header().Hash(), "Number:", block.NumberU64(), "Location:", block.Header().Location, "Parent:", block.ParentHash())
 
 	err := hc.engine.VerifyHeader(hc, block.Header(), true)
@@ -198,7 +265,7 @@ func (hc *HeaderChain) Append(batch ethdb.Batch, block *types.Block, newInboundE
 			return err
 		}
 		if block.ManifestHash(nodeCtx) != types.DeriveSha(manifest, trie.NewStackTrie(nil)) {
-			return errors.New("manifest does not match hash")
+			return ErrBadManifest
 		}
 	}
 
@@ -257,11 +324,17 @@ func (hc *HeaderChain) SetCurrentHeader(head *types.Header) error {
 		}
 	}
 
+	if prevHeader.Hash() != commonHeader.Hash() {
+		atomic.AddUint64(&hc.rollbacks, 1)
+	}
 	for {
 		if prevHeader.Hash() == commonHeader.Hash() {
 			break
 		}
 		rawdb.DeleteCanonicalHash(hc.headerDb, prevHeader.NumberU64())
+		// Rollups are keyed by hash and never go stale, but the rolled back
+		// blocks are unlikely to be needed again
+		hc.etxRollupCache.Remove(prevHeader.Hash())
 		prevHeader = hc.GetHeader(prevHeader.ParentHash(), prevHeader.NumberU64()-1)
 
 		// genesis check to not delete the genesis block
@@ -277,6 +350,12 @@ func (hc *HeaderChain) SetCurrentHeader(head *types.Header) error {
 	return nil
 }
 
+// Rollbacks returns the number of times blocks were rolled back from the
+// canonical chain, e.g. to invalidate caches of blocks assumed to be stored.
+func (hc *HeaderChain) Rollbacks() uint64 {
+	return atomic.LoadUint64(&hc.rollbacks)
+}
+
 // findCommonAncestor
 func (hc *HeaderChain) findCommonAncestor(header *types.Header) *types.Header {
 	for {
@@ -553,10 +632,11 @@ func (hc *HeaderChain) CheckContext(context int) error {
 
 // CheckLocationRange checks to make sure the range of r and z are valid
 func (hc *HeaderChain) CheckLocationRange(location []byte) error {
-	if int(location[0]) < 1 || int(location[0]) > common.NumRegionsInPrime {
+	params := common.GetHierarchyParams()
+	if int(location[0]) < 1 || int(location[0]) > params.RegionsInPrime {
 		return errors.New("the provided location is outside the allowable region range")
 	}
-	if int(location[1]) < 1 || int(location[1]) > common.NumZonesInRegion {
+	if int(location[1]) < 1 || int(location[1]) > params.ZonesInRegion {
 		return errors.New("the provided location is outside the allowable zone range")
 	}
 	return nil
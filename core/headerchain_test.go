@@ -0,0 +1,147 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/rawdb"
+	"github.com/dominant-strategies/go-quai/core/types"
+)
+
+func TestEtxRollupCacheReorg(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	genesis := types.NewBlockWithHeader(newTestHeader(common.Location{0, 0}, 0, 0, 0))
+	hc := newTestHeaderChain(genesis, &testEngine{})
+	hc.currentHeader.Store(genesis.Header())
+
+	to := common.HexToAddress("0x1400000000000000000000000000000000000001")
+	etxs := make([]*types.Transaction, 6)
+	for i := range etxs {
+		etxs[i] = newTestTx(uint64(i), to)
+	}
+	extend := func(parent *types.Block, etx *types.Transaction) *types.Block {
+		return newTestBlock(newTestChild(parent.Header(), common.Location{0, 0}), nil, nil, []*types.Transaction{etx}, nil)
+	}
+	assertRollup := func(name string, parent *types.Block, want ...*types.Transaction) {
+		t.Helper()
+		rollup, err := hc.CollectEtxRollup(extend(parent, etxs[5]))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(rollup) != len(want) {
+			t.Fatalf("%s: rollup length mismatch: have %d, want %d", name, len(rollup), len(want))
+		}
+		for i := range want {
+			if rollup[i].Hash() != want[i].Hash() {
+				t.Errorf("%s: etx %d mismatch: have %x, want %x", name, i, rollup[i].Hash(), want[i].Hash())
+			}
+		}
+	}
+	// Canonical chain genesis <- c1 <- a2 <- a3
+	c1 := extend(genesis, etxs[0])
+	a2 := extend(c1, etxs[1])
+	a3 := extend(a2, etxs[2])
+	writeTestBlocks(hc, c1, a2, a3)
+	hc.currentHeader.Store(a3.Header())
+
+	assertRollup("canonical", a3, etxs[0], etxs[1], etxs[2])
+	if !hc.etxRollupCache.Contains(a3.Hash()) || !hc.etxRollupCache.Contains(a2.Hash()) {
+		t.Fatal("rollups not cached")
+	}
+	// Appending to a returned rollup must not corrupt the cached one
+	rollup, _ := hc.CollectEtxRollup(extend(a3, etxs[5]))
+	_ = append(rollup, etxs[5])
+	assertRollup("cached", a3, etxs[0], etxs[1], etxs[2])
+
+	// Reorg to genesis <- c1 <- b2 <- b3
+	b2 := extend(c1, etxs[3])
+	b3 := extend(b2, etxs[4])
+	rawdb.WriteBlock(hc.headerDb, b2)
+	rawdb.WriteBlock(hc.headerDb, b3)
+	if err := hc.SetCurrentHeader(b3.Header()); err != nil {
+		t.Fatal(err)
+	}
+	if hc.etxRollupCache.Contains(a3.Hash()) || hc.etxRollupCache.Contains(a2.Hash()) {
+		t.Error("rolled back rollups still cached")
+	}
+	if !hc.etxRollupCache.Contains(c1.Hash()) {
+		t.Error("common ancestor rollup evicted")
+	}
+	assertRollup("reorged", b3, etxs[0], etxs[3], etxs[4])
+}
+
+func TestLastCoincidentBlock(t *testing.T) {
+	setTestNodeLocation(t, common.Location{0, 0})
+	genesis := types.NewBlockWithHeader(newTestHeader(common.Location{0, 0}, 0, 0, 0))
+	engine := &testEngine{coincident: make(map[common.Hash]bool)}
+	hc := newTestHeaderChain(genesis, engine)
+
+	to := common.HexToAddress("0x1400000000000000000000000000000000000001")
+	extend := func(parent *types.Block, nonce uint64) *types.Block {
+		etxs := []*types.Transaction{newTestTx(nonce, to)}
+		return newTestBlock(newTestChild(parent.Header(), common.Location{0, 0}), nil, nil, etxs, nil)
+	}
+	// Chain genesis <- b1 <- b2 <- b3 <- b4, with b2 dom coincident
+	b1 := extend(genesis, 1)
+	b2 := extend(b1, 2)
+	b3 := extend(b2, 3)
+	b4 := extend(b3, 4)
+	writeTestBlocks(hc, b1, b2, b3, b4)
+	engine.coincident[b2.Hash()] = true
+
+	tests := []struct {
+		name  string
+		block *types.Block
+		want  *types.Block
+	}{
+		{"genesis", genesis, genesis},
+		{"child of genesis", b1, genesis},
+		{"before coincidence", b2, genesis},
+		{"child of coincident", b3, b2},
+		{"after coincidence", b4, b2},
+		{"head child", extend(b4, 5), b2},
+	}
+	for _, tt := range tests {
+		header, err := hc.LastCoincidentBlock(tt.block)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if header.Hash() != tt.want.Hash() {
+			t.Errorf("%s: boundary mismatch: have block %d, want block %d", tt.name, header.NumberU64(), tt.want.NumberU64())
+		}
+		// The rollup spans the ETXs from the boundary to the parent
+		if tt.block == genesis {
+			continue
+		}
+		rollup, err := hc.CollectEtxRollup(tt.block)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want := int(tt.block.NumberU64() - header.NumberU64())
+		if header.NumberU64() == 0 {
+			want-- // genesis emits no etxs
+		}
+		if len(rollup) != want {
+			t.Errorf("%s: rollup of %d etxs from boundary %d, want %d", tt.name, len(rollup), header.NumberU64(), want)
+		}
+	}
+	// A gap in the ancestry names the missing block, also when validating
+	missing := extend(b4, 5)
+	orphan := newTestBlock(newTestChild(missing.Header(), common.Location{0, 0}), nil, nil, nil, nil)
+	if _, err := hc.LastCoincidentBlock(orphan); err == nil {
+		t.Error("boundary found past a missing parent")
+	}
+	validator := NewBlockValidator(hc.config, hc, engine)
+	err := validator.ValidateStateShallow(orphan, nil, 0)
+	var stageErr *StateCheckError
+	if !errors.As(err, &stageErr) || stageErr.Stage != StateStageEtxRollup {
+		t.Fatalf("orphan: have %v, want failure at %s", err, StateStageEtxRollup)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("%x", missing.Hash())) {
+		t.Errorf("orphan: error %q does not name missing parent %x", err, missing.Hash())
+	}
+}
@@ -13,7 +13,7 @@ import (
 )
 
 func ReadKnot(chainfile string) []*types.Block {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	// Load chain.rlp.
 	fh, err := os.Open(chainfile)
 	if err != nil {
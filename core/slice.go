@@ -65,7 +65,7 @@ type Slice struct {
 }
 
 func NewSlice(db ethdb.Database, config *Config, txConfig *TxPoolConfig, isLocalBlock func(block *types.Header) bool, chainConfig *params.ChainConfig, domClientUrl string, subClientUrls []string, engine consensus.Engine, cacheConfig *CacheConfig, vmConfig vm.Config, genesis *Genesis) (*Slice, error) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	sl := &Slice{
 		config:  chainConfig,
 		engine:  engine,
@@ -118,7 +118,7 @@ func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, do
 	sl.phCachemu.Lock()
 	defer sl.phCachemu.Unlock()
 
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	location := header.Location()
 	isDomCoincident := sl.engine.IsDomCoincident(header)
 
@@ -174,7 +174,7 @@ func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, do
 	}
 
 	// Append the new block
-	err = sl.hc.Append(batch, block, newInboundEtxs.FilterToLocation(common.NodeLocation))
+	err = sl.hc.Append(batch, block, newInboundEtxs.FilterToLocation(common.GetNodeLocation()))
 	if err != nil {
 		return nil, err
 	}
@@ -280,11 +280,11 @@ func (sl *Slice) backfillPETXs(header *types.Header, subManifest types.BlockMani
 
 // relayPh sends pendingHeaderWithTermini to subordinates
 func (sl *Slice) relayPh(pendingHeaderWithTermini types.PendingHeader, updateMiner bool, reorg bool, domOrigin bool, location common.Location) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 
 	if nodeCtx == common.ZONE_CTX {
 		if updateMiner {
-			sl.phCache[sl.pendingHeaderHeadHash].Header.SetLocation(common.NodeLocation)
+			sl.phCache[sl.pendingHeaderHeadHash].Header.SetLocation(common.GetNodeLocation())
 			sl.miner.worker.pendingHeaderFeed.Send(sl.phCache[sl.pendingHeaderHeadHash].Header)
 			return
 		}
@@ -299,7 +299,7 @@ func (sl *Slice) relayPh(pendingHeaderWithTermini types.PendingHeader, updateMin
 
 // CollectEtxsForManifest will gather the full list of ETXs that are referenceable through a given manifest
 func (sl *Slice) CollectSubRollups(b *types.Block) ([]types.Transactions, error) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	subRollups := make([]types.Transactions, 3)
 	if nodeCtx < common.ZONE_CTX {
 		for _, hash := range b.SubManifest() {
@@ -328,7 +328,7 @@ func (sl *Slice) CollectSubRollups(b *types.Block) ([]types.Transactions, error)
 
 // CollectNewlyConfirmedEtxs collects all newly confirmed ETXs since the last coincident with the given location
 func (sl *Slice) CollectNewlyConfirmedEtxs(block *types.Block, location common.Location) (types.Transactions, types.Transactions, error) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	// Collect rollup of ETXs from the subordinate node's manifest
 	referencableEtxs := types.Transactions{}
 	subRollup := types.Transactions{}
@@ -406,7 +406,7 @@ func (sl *Slice) setHeaderChainHead(batch ethdb.Batch, block *types.Block, reorg
 
 // PCRC previous coincidence reference check makes sure there are not any cyclic references in the graph and calculates new termini and the block terminus
 func (sl *Slice) pcrc(batch ethdb.Batch, header *types.Header, domTerminus common.Hash) (common.Hash, []common.Hash, error) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	location := header.Location()
 
 	isDomCoincident := sl.engine.IsDomCoincident(header)
@@ -530,27 +530,27 @@ func (sl *Slice) SendPendingEtxsToDom(pEtxs types.PendingEtxs) error {
 func (sl *Slice) SubRelayPendingHeader(pendingHeader types.PendingHeader, reorg bool, location common.Location) {
 	sl.phCachemu.Lock()
 	defer sl.phCachemu.Unlock()
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 
 	if nodeCtx == common.REGION_CTX {
 		// Adding a guard on the region that was already updated in the synchronous path.
-		if location.Region() != common.NodeLocation.Region() {
-			err := sl.updatePhCacheFromDom(pendingHeader, common.NodeLocation.Region(), []int{common.PRIME_CTX}, reorg)
+		if location.Region() != common.GetNodeLocation().Region() {
+			err := sl.updatePhCacheFromDom(pendingHeader, common.GetNodeLocation().Region(), []int{common.PRIME_CTX}, reorg)
 			if err != nil {
 				return
 			}
 		}
 		for i := range sl.subClients {
 			if sl.subClients[i] != nil {
-				sl.subClients[i].SubRelayPendingHeader(context.Background(), sl.phCache[pendingHeader.Termini[common.NodeLocation.Region()]], reorg, location)
+				sl.subClients[i].SubRelayPendingHeader(context.Background(), sl.phCache[pendingHeader.Termini[common.GetNodeLocation().Region()]], reorg, location)
 			}
 		}
 	} else {
 		// This check prevents a double send to the miner.
 		// If the previous block on which the given pendingHeader was built is the same as the NodeLocation
 		// the pendingHeader update has already been sent to the miner for the given location in relayPh.
-		if !location.Equal(common.NodeLocation) {
-			err := sl.updatePhCacheFromDom(pendingHeader, common.NodeLocation.Zone(), []int{common.PRIME_CTX, common.REGION_CTX}, reorg)
+		if !location.Equal(common.GetNodeLocation()) {
+			err := sl.updatePhCacheFromDom(pendingHeader, common.GetNodeLocation().Zone(), []int{common.PRIME_CTX, common.REGION_CTX}, reorg)
 			if err != nil {
 				return
 			}
@@ -564,7 +564,7 @@ func (sl *Slice) SubRelayPendingHeader(pendingHeader types.PendingHeader, reorg
 
 // computePendingHeader takes in an localPendingHeaderWithTermini and updates the pending header on the same terminus if the number is greater
 func (sl *Slice) computePendingHeader(localPendingHeaderWithTermini types.PendingHeader, domPendingHeader *types.Header, domOrigin bool) types.PendingHeader {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 
 	var cachedPendingHeaderWithTermini types.PendingHeader
 	hash := localPendingHeaderWithTermini.Termini[terminiIndex]
@@ -594,7 +594,7 @@ func (sl *Slice) updatePhCacheFromDom(pendingHeader types.PendingHeader, termini
 		for _, i := range indices {
 			localPendingHeader.Header = sl.combinePendingHeader(pendingHeader.Header, localPendingHeader.Header, i)
 		}
-		localPendingHeader.Header.SetLocation(common.NodeLocation)
+		localPendingHeader.Header.SetLocation(common.GetNodeLocation())
 		sl.phCache[hash] = localPendingHeader
 
 		if reorg {
@@ -623,7 +623,7 @@ func (sl *Slice) pickPhCacheHead(reorg bool, externPendingHeaderWithTermini type
 	if domOrigin {
 		//calc local cache head reorg
 		localCacheReorg := true
-		for i := 0; i < common.NodeLocation.Context(); i++ {
+		for i := 0; i < common.GetNodeLocation().Context(); i++ {
 			localCacheReorg = (externPendingHeaderWithTermini.Header.NumberArray()[i].Cmp(localPendingHeader.Header.NumberArray()[i]) >= 0) && localCacheReorg
 		}
 
@@ -654,7 +654,7 @@ func (sl *Slice) updateCurrentPendingHeader(externPendingHeader types.PendingHea
 // init checks if the headerchain is empty and if it's empty appends the Knot
 // otherwise loads the last stored state of the chain.
 func (sl *Slice) init(genesis *Genesis) error {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	// Even though the genesis block cannot have any ETXs, we still need an empty
 	// pending ETX entry for that block hash, so that the state processor can build
 	// on it
@@ -688,9 +688,9 @@ func (sl *Slice) init(genesis *Genesis) error {
 					if err != nil {
 						log.Warn("Failed to append block", "hash:", block.Hash(), "Number:", block.Number(), "Location:", block.Header().Location(), "error:", err)
 					}
-				} else if location.Region() == common.NodeLocation.Region() && len(common.NodeLocation) == common.REGION_CTX {
+				} else if location.Region() == common.GetNodeLocation().Region() && len(common.GetNodeLocation()) == common.REGION_CTX {
 					rawdb.WriteCandidateBody(sl.sliceDb, block.Hash(), block.Body())
-				} else if bytes.Equal(location, common.NodeLocation) {
+				} else if bytes.Equal(location, common.GetNodeLocation()) {
 					rawdb.WriteCandidateBody(sl.sliceDb, block.Hash(), block.Body())
 				}
 			}
@@ -719,7 +719,7 @@ func (sl *Slice) gcPendingHeaders() {
 // from the candidate body db. This method is used when peers give the block as a placeholder
 // for the body.
 func (sl *Slice) ConstructLocalBlock(header *types.Header) (*types.Block, error) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if nodeCtx == common.ZONE_CTX && header.EmptyBody() {
 		// This shortcut is only available to zone chains. Prime and region chains can
 		// never have an empty body, because they will always have at least one block
@@ -760,7 +760,7 @@ func (sl *Slice) ConstructLocalBlock(header *types.Header) (*types.Block, error)
 // body from the workers pendingBlockBodyCache. This method is used when the miner sends in the
 // header.
 func (sl *Slice) ConstructLocalMinedBlock(header *types.Header) (*types.Block, error) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if nodeCtx == common.ZONE_CTX && header.EmptyBody() {
 		// This shortcut is only available to zone chains. Prime and region chains can
 		// never have an empty body, because they will always have at least one block
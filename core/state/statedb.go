@@ -968,6 +968,82 @@ func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) (common.Hash, error)
 	return s.trie.Hash(), nil
 }
 
+// StateStats summarises how a state transition changed the size of the state.
+type StateStats struct {
+	AccountsCreated     int // Accounts absent from the original state which now exist
+	AccountsDeleted     int // Accounts present in the original state which were removed
+	StorageSlotsTouched int // Storage slots whose value differs from the original state
+}
+
+// ChangeStats compares every account finalised since the state was opened
+// against the original state root and reports the resulting state growth.
+// It should be called after IntermediateRoot. Since it reads the original
+// tries, it is intended for monitoring rather than the block processing path.
+func (s *StateDB) ChangeStats() (*StateStats, error) {
+	origTrie, err := s.db.OpenTrie(s.originalRoot)
+	if err != nil {
+		return nil, err
+	}
+	stats := new(StateStats)
+	for addr := range s.stateObjectsDirty {
+		obj := s.stateObjects[addr]
+		enc, err := origTrie.TryGet(addr.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		var orig *Account
+		if len(enc) > 0 {
+			orig = new(Account)
+			if err := rlp.DecodeBytes(enc, orig); err != nil {
+				return nil, err
+			}
+		}
+		if obj.deleted {
+			if orig != nil {
+				stats.AccountsDeleted++
+			}
+			continue
+		}
+		if orig == nil {
+			stats.AccountsCreated++
+		}
+		// Compare each slot the account touched against its original value
+		var origStorage Trie
+		if orig != nil && orig.Root != emptyRoot {
+			if origStorage, err = s.db.OpenStorageTrie(obj.addrHash, orig.Root); err != nil {
+				return nil, err
+			}
+		}
+		current := make(Storage, len(obj.originStorage)+len(obj.pendingStorage))
+		for key, value := range obj.originStorage {
+			current[key] = value
+		}
+		for key, value := range obj.pendingStorage {
+			current[key] = value
+		}
+		for key, value := range current {
+			var prev common.Hash
+			if origStorage != nil {
+				enc, err := origStorage.TryGet(key.Bytes())
+				if err != nil {
+					return nil, err
+				}
+				if len(enc) > 0 {
+					_, content, _, err := rlp.Split(enc)
+					if err != nil {
+						return nil, err
+					}
+					prev.SetBytes(content)
+				}
+			}
+			if prev != value {
+				stats.StorageSlotsTouched++
+			}
+		}
+	}
+	return stats, nil
+}
+
 // Prepare sets the current transaction hash and index which are
 // used when the EVM emits new state logs.
 func (s *StateDB) Prepare(thash common.Hash, ti int) {
@@ -0,0 +1,63 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/rawdb"
+)
+
+func TestChangeStats(t *testing.T) {
+	var (
+		db      = NewDatabase(rawdb.NewMemoryDatabase())
+		kept    = common.HexToAddress("0x01")
+		removed = common.HexToAddress("0x02")
+		created = common.HexToAddress("0x03")
+	)
+	// Create the original state with two accounts, one holding storage
+	statedb, _ := New(common.Hash{}, db, nil)
+	statedb.AddBalance(kept, big.NewInt(1))
+	statedb.SetState(kept, common.HexToHash("0x01"), common.HexToHash("0x01"))
+	statedb.SetState(kept, common.HexToHash("0x02"), common.HexToHash("0x02"))
+	statedb.AddBalance(removed, big.NewInt(1))
+	root, err := statedb.Commit(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Modify one slot, rewrite another with its original value, add a new one,
+	// delete an account and create another
+	statedb, _ = New(root, db, nil)
+	statedb.SetState(kept, common.HexToHash("0x01"), common.HexToHash("0x11"))
+	statedb.SetState(kept, common.HexToHash("0x02"), common.HexToHash("0x02"))
+	statedb.SetState(kept, common.HexToHash("0x03"), common.HexToHash("0x03"))
+	statedb.Suicide(removed)
+	statedb.AddBalance(created, big.NewInt(1))
+	if _, err := statedb.IntermediateRoot(true); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := statedb.ChangeStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := StateStats{AccountsCreated: 1, AccountsDeleted: 1, StorageSlotsTouched: 2}
+	if *stats != want {
+		t.Errorf("stats mismatch: have %+v, want %+v", *stats, want)
+	}
+}
@@ -101,6 +101,8 @@ type CacheConfig struct {
 	Preimages           bool          // Whether to store preimage of trie key to the disk
 
 	SnapshotWait bool // Wait for snapshot construction on startup. TODO(karalabe): This is a dirty hack for testing, nuke it
+
+	EtxRollupCacheLimit int // Number of ETX rollups to cache, zero for the default
 }
 
 // defaultCacheConfig are the default caching values if none are specified by the
@@ -73,8 +73,8 @@ func TestCreateETX(t *testing.T) {
 		gasLimit   = GasPool(params.GenesisGasLimit)
 	)
 	t.Log(addr)
-	common.NodeLocation = *addr.Location()
-	t.Log(common.NodeLocation.Name())
+	common.SetNodeLocation(*addr.Location())
+	t.Log(common.GetNodeLocation().Name())
 	toAddr := common.HexToAddress("0x3C97734DfD0376b0b1a57f48e2049A092fD89058")
 	location := toAddr.Location()
 	t.Log(location.Name())
@@ -140,8 +140,8 @@ func TestExternalTokenTransfer(t *testing.T) {
 		gasLimit   = GasPool(params.GenesisGasLimit)
 	)
 	t.Log(addr)
-	common.NodeLocation = *addr.Location()
-	t.Log(common.NodeLocation.Name())
+	common.SetNodeLocation(*addr.Location())
+	t.Log(common.GetNodeLocation().Name())
 	location := common.HexToAddress("0x3C97734DfD0376b0b1a57f48e2049A092fD89058").Location()
 	t.Log(location.Name())
 	params.TestChainConfig.GenesisHash = genesis.Hash()
@@ -325,7 +325,7 @@ func TestInboundETX(t *testing.T) {
 		gasLimit = GasPool(params.GenesisGasLimit)
 		zero     = uint64(0)
 	)
-	common.NodeLocation = *to.Location()
+	common.SetNodeLocation(*to.Location())
 	params.TestChainConfig.GenesisHash = genesis.Hash()
 	blocks, _ := GenerateChain(params.TestChainConfig, genesis, blake3pow.NewFaker(), testdb, 2, gen)
 	mockContext := MockChainContext{blocks}
@@ -382,7 +382,7 @@ func TestInvalidInboundETX(t *testing.T) {
 		gasLimit = GasPool(params.GenesisGasLimit)
 		zero     = uint64(0)
 	)
-	common.NodeLocation = *to.Location()
+	common.SetNodeLocation(*to.Location())
 	params.TestChainConfig.GenesisHash = genesis.Hash()
 	blocks, _ := GenerateChain(params.TestChainConfig, genesis, blake3pow.NewFaker(), testdb, 2, gen)
 	mockContext := MockChainContext{blocks}
@@ -426,7 +426,7 @@ func TestOpETX(t *testing.T) {
 		gasLimit   = GasPool(params.GenesisGasLimit)
 	)
 	t.Log(addr)
-	common.NodeLocation = *addr.Location()
+	common.SetNodeLocation(*addr.Location())
 	params.TestChainConfig.GenesisHash = genesis.Hash()
 	blocks, _ := GenerateChain(params.TestChainConfig, genesis, blake3pow.NewFaker(), testdb, 2, gen)
 	statedb.AddBalance(addr, big.NewInt(params.Ether*2)) // give me 2 eth
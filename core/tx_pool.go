@@ -544,7 +544,7 @@ func (pool *TxPool) TxPoolPending(enforceTips bool, etxSet types.EtxSet) (map[co
 		for _, entry := range etxSet {
 			addr := entry.ETX.ETXSender()
 			tx := entry.ETX
-			if tx.ETXSender().Location().Equal(common.NodeLocation) { // Sanity check
+			if tx.ETXSender().Location().Equal(common.GetNodeLocation()) { // Sanity check
 				log.Error("ETX sender is in our location!", "tx", tx.Hash().String(), "sender", tx.ETXSender().String())
 				continue // skip this tx
 			}
@@ -614,6 +614,10 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	if tx.GasFeeCapIntCmp(tx.GasTipCap()) < 0 {
 		return ErrTipAboveFeeCap
 	}
+	// Reject transactions that can never pay the chain's minimum gas price
+	if tx.GasFeeCapIntCmp(pool.chainconfig.MinGasPrice(common.GetNodeLocation())) < 0 {
+		return ErrUnderpriced
+	}
 	// Make sure the transaction is signed properly.
 	from, err := types.Sender(pool.signer, tx)
 	if err != nil {
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+	"github.com/dominant-strategies/go-quai/params"
+)
+
+func TestValidateTxMinGasPrice(t *testing.T) {
+	config := *params.TestChainConfig
+	config.MinGasPrices = map[string]*big.Int{"cyprus2": big.NewInt(10)}
+	pool := &TxPool{
+		chainconfig:   &config,
+		signer:        types.LatestSigner(&config),
+		eip2718:       true,
+		currentMaxGas: 1000000,
+	}
+	to := common.HexToAddress("0x1400000000000000000000000000000000000001")
+	tx := func(feeCap int64) *types.Transaction {
+		return types.NewTx(&types.InternalTx{
+			ChainID:   config.ChainID,
+			GasTipCap: big.NewInt(1),
+			GasFeeCap: big.NewInt(feeCap),
+			Gas:       21000,
+			To:        &to,
+			Value:     big.NewInt(1),
+			V:         new(big.Int),
+			R:         new(big.Int),
+			S:         new(big.Int),
+		})
+	}
+	tests := []struct {
+		loc         common.Location
+		feeCap      int64
+		underpriced bool
+	}{
+		{common.Location{0, 1}, 9, true},
+		{common.Location{0, 1}, 10, false},
+		{common.Location{0, 0}, 1, false},
+	}
+	for _, tt := range tests {
+		setTestNodeLocation(t, tt.loc)
+		// Transactions above the floor fail later on their missing signature
+		err := pool.validateTx(tx(tt.feeCap), true)
+		if underpriced := errors.Is(err, ErrUnderpriced); underpriced != tt.underpriced {
+			t.Errorf("%v, fee cap %d: have error %v, want underpriced %v", tt.loc, tt.feeCap, err, tt.underpriced)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+	"github.com/dominant-strategies/go-quai/crypto"
+	"github.com/dominant-strategies/go-quai/ethdb/memorydb"
+	"github.com/dominant-strategies/go-quai/rlp"
+	"github.com/dominant-strategies/go-quai/trie"
+)
+
+var (
+	// errTxProofIndex is returned when a proof is requested for a transaction
+	// outside of the set.
+	errTxProofIndex = errors.New("transaction index out of range")
+
+	// errTxProofMismatch is returned when a proof is valid for the root but
+	// proves a different transaction than the one being verified.
+	errTxProofMismatch = errors.New("proven transaction does not match")
+)
+
+// proofList collects the nodes written by trie.Prove in path order.
+type proofList [][]byte
+
+func (l *proofList) Put(key []byte, value []byte) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func (l *proofList) Delete(key []byte) error {
+	panic("not supported")
+}
+
+// DeriveShaWithProof computes the transaction root of txs, as DeriveSha does
+// in ValidateBody, together with a Merkle proof of inclusion of the
+// transaction at index. The proof lists the encoded trie nodes on the path
+// from the root to the transaction and can be checked with VerifyTxProof.
+func DeriveShaWithProof(txs types.Transactions, index int) (common.Hash, [][]byte, error) {
+	if index < 0 || index >= len(txs) {
+		return common.Hash{}, nil, fmt.Errorf("%w: index %d, %d transactions", errTxProofIndex, index, len(txs))
+	}
+	tr, err := trie.New(common.Hash{}, trie.NewDatabase(memorydb.New()))
+	if err != nil {
+		return common.Hash{}, nil, err
+	}
+	var (
+		keyBuf   []byte
+		valueBuf = new(bytes.Buffer)
+	)
+	for i := range txs {
+		keyBuf = rlp.AppendUint64(keyBuf[:0], uint64(i))
+		valueBuf.Reset()
+		txs.EncodeIndex(i, valueBuf)
+		if err := tr.TryUpdate(keyBuf, common.CopyBytes(valueBuf.Bytes())); err != nil {
+			return common.Hash{}, nil, err
+		}
+	}
+	var proof proofList
+	if err := tr.Prove(rlp.AppendUint64(nil, uint64(index)), 0, &proof); err != nil {
+		return common.Hash{}, nil, err
+	}
+	return tr.Hash(), proof, nil
+}
+
+// VerifyTxProof checks that proof, as returned by DeriveShaWithProof, proves
+// the inclusion of tx at index in the transaction set committed to by root.
+func VerifyTxProof(root common.Hash, proof [][]byte, tx *types.Transaction, index int) error {
+	if index < 0 {
+		return fmt.Errorf("%w: index %d", errTxProofIndex, index)
+	}
+	proofDb := memorydb.New()
+	for _, node := range proof {
+		if err := proofDb.Put(crypto.Keccak256(node), node); err != nil {
+			return err
+		}
+	}
+	value, err := trie.VerifyProof(root, rlp.AppendUint64(nil, uint64(index)), proofDb)
+	if err != nil {
+		return err
+	}
+	want := new(bytes.Buffer)
+	types.Transactions{tx}.EncodeIndex(0, want)
+	if !bytes.Equal(value, want.Bytes()) {
+		return fmt.Errorf("%w: index %d", errTxProofMismatch, index)
+	}
+	return nil
+}
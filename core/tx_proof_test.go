@@ -0,0 +1,48 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+	"github.com/dominant-strategies/go-quai/trie"
+)
+
+func TestDeriveShaWithProof(t *testing.T) {
+	to := common.HexToAddress("0x1400000000000000000000000000000000000001")
+	for _, n := range []int{1, 2, 16, 0x81} {
+		txs := make(types.Transactions, n)
+		for i := range txs {
+			txs[i] = newTestTx(uint64(i), to)
+		}
+		want := types.DeriveSha(txs, trie.NewStackTrie(nil))
+		for _, index := range []int{0, n / 2, n - 1} {
+			root, proof, err := DeriveShaWithProof(txs, index)
+			if err != nil {
+				t.Fatalf("%d txs, index %d: %v", n, index, err)
+			}
+			if root != want {
+				t.Fatalf("%d txs: root mismatch: have %x, want %x", n, root, want)
+			}
+			if err := VerifyTxProof(root, proof, txs[index], index); err != nil {
+				t.Errorf("%d txs, index %d: valid proof rejected: %v", n, index, err)
+			}
+			if n > 1 {
+				other := (index + 1) % n
+				if err := VerifyTxProof(root, proof, txs[other], index); !errors.Is(err, errTxProofMismatch) {
+					t.Errorf("%d txs, index %d: wrong transaction accepted: %v", n, index, err)
+				}
+				if err := VerifyTxProof(root, proof, txs[index], other); err == nil {
+					t.Errorf("%d txs, index %d: proof accepted for index %d", n, index, other)
+				}
+			}
+			if err := VerifyTxProof(common.Hash{1}, proof, txs[index], index); err == nil {
+				t.Errorf("%d txs, index %d: proof accepted for wrong root", n, index)
+			}
+		}
+	}
+	if _, _, err := DeriveShaWithProof(types.Transactions{newTestTx(0, to)}, 1); !errors.Is(err, errTxProofIndex) {
+		t.Errorf("out of range index: have %v, want %v", err, errTxProofIndex)
+	}
+}
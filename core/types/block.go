@@ -215,112 +215,112 @@ func (h *Header) EncodeRLP(w io.Writer) error {
 
 // Localized accessors
 func (h *Header) ParentHash(args ...int) common.Hash {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.parentHash[nodeCtx]
 }
 func (h *Header) UncleHash(args ...int) common.Hash {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.uncleHash[nodeCtx]
 }
 func (h *Header) Coinbase(args ...int) common.Address {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.coinbase[nodeCtx]
 }
 func (h *Header) Root(args ...int) common.Hash {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.root[nodeCtx]
 }
 func (h *Header) TxHash(args ...int) common.Hash {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.txHash[nodeCtx]
 }
 func (h *Header) EtxHash(args ...int) common.Hash {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.etxHash[nodeCtx]
 }
 func (h *Header) EtxRollupHash(args ...int) common.Hash {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.etxRollupHash[nodeCtx]
 }
 func (h *Header) ManifestHash(args ...int) common.Hash {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.manifestHash[nodeCtx]
 }
 func (h *Header) ReceiptHash(args ...int) common.Hash {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.receiptHash[nodeCtx]
 }
 func (h *Header) Bloom(args ...int) Bloom {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.bloom[nodeCtx]
 }
 func (h *Header) Difficulty(args ...int) *big.Int {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.difficulty[nodeCtx]
 }
 func (h *Header) Number(args ...int) *big.Int {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.number[nodeCtx]
 }
 func (h *Header) NumberU64(args ...int) uint64 {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.number[nodeCtx].Uint64()
 }
 func (h *Header) GasLimit(args ...int) uint64 {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.gasLimit[nodeCtx]
 }
 func (h *Header) GasUsed(args ...int) uint64 {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	return h.gasUsed[nodeCtx]
 }
 func (h *Header) BaseFee(args ...int) *big.Int {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
@@ -333,105 +333,105 @@ func (h *Header) Nonce() BlockNonce         { return h.nonce }
 func (h *Header) NonceU64() uint64          { return binary.BigEndian.Uint64(h.nonce[:]) }
 
 func (h *Header) SetParentHash(val common.Hash, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.parentHash[nodeCtx] = val
 }
 func (h *Header) SetUncleHash(val common.Hash, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.uncleHash[nodeCtx] = val
 }
 func (h *Header) SetCoinbase(val common.Address, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.coinbase[nodeCtx] = val
 }
 func (h *Header) SetRoot(val common.Hash, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.root[nodeCtx] = val
 }
 func (h *Header) SetTxHash(val common.Hash, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.txHash[nodeCtx] = val
 }
 func (h *Header) SetEtxHash(val common.Hash, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.etxHash[nodeCtx] = val
 }
 func (h *Header) SetEtxRollupHash(val common.Hash, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.etxRollupHash[nodeCtx] = val
 }
 func (h *Header) SetManifestHash(val common.Hash, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.manifestHash[nodeCtx] = val
 }
 func (h *Header) SetReceiptHash(val common.Hash, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.receiptHash[nodeCtx] = val
 }
 func (h *Header) SetBloom(val Bloom, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.bloom[nodeCtx] = val
 }
 func (h *Header) SetDifficulty(val *big.Int, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.difficulty[nodeCtx] = new(big.Int).Set(val)
 }
 func (h *Header) SetNumber(val *big.Int, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.number[nodeCtx] = new(big.Int).Set(val)
 }
 func (h *Header) SetGasLimit(val uint64, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.gasLimit[nodeCtx] = val
 }
 func (h *Header) SetGasUsed(val uint64, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
 	h.gasUsed[nodeCtx] = val
 }
 func (h *Header) SetBaseFee(val *big.Int, args ...int) {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if len(args) > 0 {
 		nodeCtx = args[0]
 	}
@@ -643,7 +643,7 @@ type extblock struct {
 }
 
 func NewBlock(header *Header, txs []*Transaction, uncles []*Header, etxs []*Transaction, subManifest BlockManifest, receipts []*Receipt, hasher TrieHasher) *Block {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	b := &Block{header: CopyHeader(header), td: new(big.Int)}
 
 	// TODO: panic if len(txs) != len(receipts)
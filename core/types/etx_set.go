@@ -27,7 +27,7 @@ func NewEtxSet() EtxSet {
 func (set EtxSet) Update(newInboundEtxs Transactions, currentHeight uint64) {
 	// Add new ETX entries to the inbound set
 	for _, etx := range newInboundEtxs {
-		if etx.To().Location().Equal(common.NodeLocation) {
+		if etx.To().Location().Equal(common.GetNodeLocation()) {
 			set[etx.Hash()] = EtxSetEntry{currentHeight, *etx}
 		} else {
 			panic("cannot add ETX destined to other chain to our ETX set")
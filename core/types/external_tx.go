@@ -45,7 +45,7 @@ type PendingEtxs struct {
 }
 
 func (p *PendingEtxs) IsValid(hasher TrieHasher) bool {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	if p == nil || p.Header == nil || p.Etxs == nil {
 		return false
 	}
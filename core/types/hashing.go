@@ -111,3 +111,55 @@ func DeriveSha(list DerivableList, hasher TrieHasher) common.Hash {
 	}
 	return hasher.Hash()
 }
+
+// EncodedItems returns an iterator over the encodings of the items of list, in
+// order, for DeriveShaStream. Items are only encoded when requested.
+func EncodedItems(list DerivableList) func() ([]byte, bool) {
+	var (
+		buf = new(bytes.Buffer)
+		i   int
+	)
+	return func() ([]byte, bool) {
+		if i >= list.Len() {
+			return nil, false
+		}
+		value := encodeForDerive(list, i, buf)
+		i++
+		return value, true
+	}
+}
+
+// DeriveShaStream is DeriveSha over the encoded items yielded by next in list
+// order, until next reports the end of the list. Items are fed to the hasher as
+// they arrive, so with a StackTrie the list never has to be held in memory at
+// once. The values yielded must not be modified afterwards.
+func DeriveShaStream(next func() ([]byte, bool), hasher TrieHasher) common.Hash {
+	hasher.Reset()
+
+	// The first item has to be inserted after items 1 through 0x7f, see
+	// DeriveSha, so it is held back until then.
+	var (
+		indexBuf []byte
+		first    []byte
+		count    uint64
+	)
+	for value, ok := next(); ok; value, ok = next() {
+		if count == 0 {
+			first = value
+			count++
+			continue
+		}
+		if count == 0x80 {
+			indexBuf = rlp.AppendUint64(indexBuf[:0], 0)
+			hasher.Update(indexBuf, first)
+		}
+		indexBuf = rlp.AppendUint64(indexBuf[:0], count)
+		hasher.Update(indexBuf, value)
+		count++
+	}
+	if count > 0 && count <= 0x80 {
+		indexBuf = rlp.AppendUint64(indexBuf[:0], 0)
+		hasher.Update(indexBuf, first)
+	}
+	return hasher.Hash()
+}
@@ -115,6 +115,27 @@ func TestFuzzDeriveSha(t *testing.T) {
 	}
 }
 
+func TestDeriveShaStream(t *testing.T) {
+	lists := []types.DerivableList{flatList{}}
+	for _, n := range []int{1, 2, 0x7f, 0x80, 0x81, 0x100, 1000} {
+		list := make(flatList, n)
+		for i := range list {
+			list[i] = hexutil.Encode([]byte(fmt.Sprintf("item %d", i)))
+		}
+		lists = append(lists, list)
+	}
+	for i := 0; i < 10; i++ {
+		lists = append(lists, newDummy(i))
+	}
+	for i, list := range lists {
+		exp := types.DeriveSha(list, trie.NewStackTrie(nil))
+		got := types.DeriveShaStream(types.EncodedItems(list), trie.NewStackTrie(nil))
+		if got != exp {
+			t.Errorf("list %d (%d items): got %x exp %x", i, list.Len(), got, exp)
+		}
+	}
+}
+
 // TestDerivableList contains testcases found via fuzzing
 func TestDerivableList(t *testing.T) {
 	type tcase []string
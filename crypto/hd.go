@@ -0,0 +1,166 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/dominant-strategies/go-quai/common"
+)
+
+const (
+	// HDPurpose and HDCoinType are the BIP-44 purpose and SLIP-44 coin type
+	// leading every Quai derivation path.
+	HDPurpose  = 44
+	HDCoinType = 994
+
+	// hdHardenedOffset marks a BIP-32 child index as hardened.
+	hdHardenedOffset = 0x80000000
+
+	// maxHDScopeAttempts bounds the search for an in-scope key below an
+	// account index. Each attempt lands in scope with probability ~10/256, so
+	// exhausting the bound is practically impossible.
+	maxHDScopeAttempts = 1024
+)
+
+var (
+	// hdMasterKey is the HMAC key for the BIP-32 master key derivation.
+	hdMasterKey = []byte("Bitcoin seed")
+
+	errHDInvalidKey   = errors.New("invalid hd derived key")
+	errHDIndexRange   = errors.New("hd account index must be below 2^31")
+	errHDOutOfScope   = errors.New("no in-scope hd key found")
+	errHDSeedTooShort = errors.New("hd seed must be at least 16 bytes")
+)
+
+// hdKey is an extended private key: a secp256k1 scalar and its chain code.
+type hdKey struct {
+	key       []byte
+	chainCode []byte
+}
+
+// hdMaster derives the BIP-32 master key from a seed.
+func hdMaster(seed []byte) (*hdKey, error) {
+	mac := hmac.New(sha512.New, hdMasterKey)
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	if k := new(big.Int).SetBytes(sum[:32]); k.Sign() == 0 || k.Cmp(secp256k1N) >= 0 {
+		return nil, errHDInvalidKey
+	}
+	return &hdKey{key: sum[:32], chainCode: sum[32:]}, nil
+}
+
+// hardenedChild derives the hardened child at index, as per BIP-32.
+func (k *hdKey) hardenedChild(index uint32) (*hdKey, error) {
+	data := make([]byte, 1+32+4)
+	copy(data[1:], k.key)
+	binary.BigEndian.PutUint32(data[33:], index+hdHardenedOffset)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(secp256k1N) >= 0 {
+		return nil, errHDInvalidKey
+	}
+	child := il.Add(il, new(big.Int).SetBytes(k.key))
+	child.Mod(child, secp256k1N)
+	if child.Sign() == 0 {
+		return nil, errHDInvalidKey
+	}
+	return &hdKey{key: scalarBytes(child), chainCode: sum[32:]}, nil
+}
+
+// scalarBytes returns the 32 byte big endian encoding of a scalar.
+func scalarBytes(n *big.Int) []byte {
+	buf := make([]byte, 32)
+	return n.FillBytes(buf)
+}
+
+// DeriveHDKey derives the private key for an account of a location from a
+// wallet seed. Keys are derived along the hardened BIP-32 path
+//
+//	m/44'/994'/<context>'/<location indices>'/<index>'
+//
+// where the context level holds the location's depth in the hierarchy and each
+// byte of the location contributes one further level, so prime accounts live
+// at m/44'/994'/0'/index', cyprus at m/44'/994'/1'/0'/index' and cyprus1 at
+// m/44'/994'/2'/0'/0'/index'.
+//
+// A key derived that way only owns an address in loc's prefix range by
+// chance. If it does not, the search continues with the hardened children
+// m/.../<index>'/0', m/.../<index>'/1', ... and the first key whose address
+// is in scope is returned. The search depends only on the seed, location and
+// index, so every wallet derives the same account. Since the context level
+// fixes how many levels precede the index, neither an account nor one of its
+// retries ever shares a path with another location's account: distinct
+// accounts, of the same or of different locations, never share a derivation
+// path.
+func DeriveHDKey(seed []byte, loc common.Location, index uint32) (*ecdsa.PrivateKey, error) {
+	if len(seed) < 16 {
+		return nil, errHDSeedTooShort
+	}
+	if index >= hdHardenedOffset {
+		return nil, errHDIndexRange
+	}
+	ctx, err := loc.ContextErr()
+	if err != nil {
+		return nil, err
+	}
+	key, err := hdMaster(seed)
+	if err != nil {
+		return nil, err
+	}
+	path := []uint32{HDPurpose, HDCoinType, uint32(ctx)}
+	for _, b := range loc {
+		path = append(path, uint32(b))
+	}
+	for _, i := range append(path, index) {
+		if key, err = key.hardenedChild(i); err != nil {
+			return nil, err
+		}
+	}
+	if priv, ok := hdInScope(key, loc); ok {
+		return priv, nil
+	}
+	for attempt := uint32(0); attempt < maxHDScopeAttempts; attempt++ {
+		// An invalid child is as unusable as an out-of-scope one; skip it
+		child, err := key.hardenedChild(attempt)
+		if err != nil {
+			continue
+		}
+		if priv, ok := hdInScope(child, loc); ok {
+			return priv, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: location %s, index %d", errHDOutOfScope, loc.Name(), index)
+}
+
+// hdInScope converts key to a private key if its address is within loc.
+func hdInScope(key *hdKey, loc common.Location) (*ecdsa.PrivateKey, bool) {
+	priv, err := ToECDSA(key.key)
+	if err != nil {
+		return nil, false
+	}
+	if !loc.ContainsAddress(PubkeyToAddress(priv.PublicKey)) {
+		return nil, false
+	}
+	return priv, true
+}
+
+// DeriveHDAddress derives the address of an account of a location from a
+// wallet seed. The address is guaranteed to be in loc's prefix range. See
+// DeriveHDKey for the derivation path.
+func DeriveHDAddress(seed []byte, loc common.Location, index uint32) (common.Address, error) {
+	priv, err := DeriveHDKey(seed, loc, index)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return PubkeyToAddress(priv.PublicKey), nil
+}
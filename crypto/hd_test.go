@@ -0,0 +1,166 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/common"
+)
+
+var testHDSeed = common.FromHex("000102030405060708090a0b0c0d0e0f")
+
+// Tests the key derivation against BIP-32 test vector 1, chain m/0H.
+func TestHDHardenedChild(t *testing.T) {
+	master, err := hdMaster(testHDSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := hex.EncodeToString(master.key), "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35"; have != want {
+		t.Fatalf("master key mismatch: have %s, want %s", have, want)
+	}
+	child, err := master.hardenedChild(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := hex.EncodeToString(child.key), "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea"; have != want {
+		t.Fatalf("child key mismatch: have %s, want %s", have, want)
+	}
+}
+
+func TestDeriveHDAddress(t *testing.T) {
+	locations := []common.Location{{}, {0}, {1}, {2}, {0, 0}, {0, 2}, {1, 1}, {2, 0}, {2, 2}}
+	for _, loc := range locations {
+		seen := make(map[common.Address]bool)
+		for index := uint32(0); index < 8; index++ {
+			addr, err := DeriveHDAddress(testHDSeed, loc, index)
+			if err != nil {
+				t.Fatalf("%s/%d: %v", loc.Name(), index, err)
+			}
+			if !loc.ContainsAddress(addr) {
+				t.Errorf("%s/%d: address %x out of scope", loc.Name(), index, addr)
+			}
+			if seen[addr] {
+				t.Errorf("%s/%d: duplicate address %x", loc.Name(), index, addr)
+			}
+			seen[addr] = true
+
+			again, err := DeriveHDAddress(testHDSeed, loc, index)
+			if err != nil || again != addr {
+				t.Errorf("%s/%d: derivation not deterministic: %x != %x (%v)", loc.Name(), index, again, addr, err)
+			}
+		}
+	}
+}
+
+// hdPath derives the key at the given hardened path below the master key.
+func hdPath(t *testing.T, seed []byte, path ...uint32) *hdKey {
+	t.Helper()
+	key, err := hdMaster(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range path {
+		if key, err = key.hardenedChild(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return key
+}
+
+// hdAccountPath returns the path of the in-scope key DeriveHDKey returns for
+// an account, given the path of the account's base key.
+func hdAccountPath(t *testing.T, key []byte, base ...uint32) []uint32 {
+	t.Helper()
+	if bytes.Equal(hdPath(t, testHDSeed, base...).key, key) {
+		return base
+	}
+	for attempt := uint32(0); attempt < maxHDScopeAttempts; attempt++ {
+		retry := append(append([]uint32{}, base...), attempt)
+		if bytes.Equal(hdPath(t, testHDSeed, retry...).key, key) {
+			return retry
+		}
+	}
+	t.Fatalf("key not found below path %v", base)
+	return nil
+}
+
+func TestDeriveHDKeyPath(t *testing.T) {
+	tests := []struct {
+		loc  common.Location
+		base []uint32
+	}{
+		{common.Location{}, []uint32{HDPurpose, HDCoinType, 0, 1}},
+		{common.Location{0}, []uint32{HDPurpose, HDCoinType, 1, 0, 1}},
+		{common.Location{2, 1}, []uint32{HDPurpose, HDCoinType, 2, 2, 1, 1}},
+	}
+	for _, tt := range tests {
+		key, err := DeriveHDKey(testHDSeed, tt.loc, 1)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.loc.Name(), err)
+		}
+		hdAccountPath(t, FromECDSA(key), tt.base...)
+	}
+}
+
+func TestDeriveHDKeyDisjointLocations(t *testing.T) {
+	// The retries of prime account i are children of its base path, which
+	// must not coincide with the accounts of region i
+	for index := uint32(0); index < 3; index++ {
+		for attempt := uint32(0); attempt < 4; attempt++ {
+			retry := hdPath(t, testHDSeed, HDPurpose, HDCoinType, 0, index, attempt)
+			region, err := DeriveHDKey(testHDSeed, common.Location{byte(index)}, attempt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(retry.key, FromECDSA(region)) {
+				t.Errorf("prime account %d retry %d shares its key with region %d account %d", index, attempt, index, attempt)
+			}
+			regionBase := hdPath(t, testHDSeed, HDPurpose, HDCoinType, 1, index, attempt)
+			if bytes.Equal(retry.key, regionBase.key) {
+				t.Errorf("prime account %d retry %d shares its path with region %d account %d", index, attempt, index, attempt)
+			}
+		}
+	}
+}
+
+func TestDeriveHDKeyMatchesAddress(t *testing.T) {
+	loc := common.Location{1, 2}
+	key, err := DeriveHDKey(testHDSeed, loc, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := DeriveHDAddress(testHDSeed, loc, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := PubkeyToAddress(key.PublicKey); have != addr {
+		t.Fatalf("key address mismatch: have %x, want %x", have, addr)
+	}
+	other, err := DeriveHDKey(append(common.CopyBytes(testHDSeed), 0x00), loc, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(FromECDSA(other), FromECDSA(key)) {
+		t.Fatal("different seeds derived the same key")
+	}
+}
+
+func TestDeriveHDAddressErrors(t *testing.T) {
+	tests := []struct {
+		seed  []byte
+		loc   common.Location
+		index uint32
+	}{
+		{seed: testHDSeed[:15], loc: common.Location{0, 0}},
+		{seed: testHDSeed, loc: common.Location{0, 0}, index: 1 << 31},
+		{seed: testHDSeed, loc: common.Location{3}},
+		{seed: testHDSeed, loc: common.Location{0, 3}},
+		{seed: testHDSeed, loc: common.Location{0, 0, 0}},
+	}
+	for i, tt := range tests {
+		if _, err := DeriveHDAddress(tt.seed, tt.loc, tt.index); err == nil {
+			t.Errorf("test %d: expected error", i)
+		}
+	}
+}
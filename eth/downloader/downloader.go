@@ -535,7 +535,7 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64) error {
 
 	// peer height
 	peerHeight := from
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 
 	localHeight := d.core.CurrentBlock().NumberU64()
 
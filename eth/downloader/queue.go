@@ -28,6 +28,7 @@ import (
 
 	"github.com/dominant-strategies/go-quai/common"
 	"github.com/dominant-strategies/go-quai/common/prque"
+	"github.com/dominant-strategies/go-quai/core"
 	"github.com/dominant-strategies/go-quai/core/types"
 	"github.com/dominant-strategies/go-quai/log"
 	"github.com/dominant-strategies/go-quai/metrics"
@@ -794,7 +795,7 @@ func (q *queue) DeliverHeaders(id string, headers []*types.Header, headerProcCh
 func (q *queue) DeliverBodies(id string, txLists [][]*types.Transaction, uncleLists [][]*types.Header, etxLists [][]*types.Transaction, manifests []types.BlockManifest) (int, error) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	trieHasher := trie.NewStackTrie(nil)
 	validate := func(index int, header *types.Header) error {
 		if types.DeriveSha(types.Transactions(txLists[index]), trieHasher) != header.TxHash() {
@@ -803,8 +804,8 @@ func (q *queue) DeliverBodies(id string, txLists [][]*types.Transaction, uncleLi
 		if types.DeriveSha(types.Transactions(etxLists[index]), trieHasher) != header.EtxHash() {
 			return errInvalidBody
 		}
-		if nodeCtx < common.ZONE_CTX {
-			if types.DeriveSha(manifests[index], trieHasher) != header.ManifestHash(nodeCtx+1) {
+		if want, ok := core.SubManifestHash(header, nodeCtx); ok {
+			if types.DeriveSha(manifests[index], trieHasher) != want {
 				return errInvalidBody
 			}
 		}
@@ -139,7 +139,7 @@ func (api *PublicBlockChainQuaiAPI) ChainId() (*hexutil.Big, error) {
 
 // NodeLocation is the access call to the location of the node.
 func (api *PublicBlockChainQuaiAPI) NodeLocation() []hexutil.Uint64 {
-	return common.NodeLocation.RPCMarshal()
+	return common.GetNodeLocation().RPCMarshal()
 }
 
 // BlockNumber returns the block number of the chain head.
@@ -567,10 +567,14 @@ func (s *PublicBlockChainQuaiAPI) CreateAccessList(ctx context.Context, args Tra
 }
 
 func (s *PublicBlockChainQuaiAPI) fillSubordinateManifest(b *types.Block) (*types.Block, error) {
-	nodeCtx := common.NodeLocation.Context()
-	if b.ManifestHash(nodeCtx+1) == types.EmptyRootHash {
+	nodeCtx := common.GetNodeLocation().Context()
+	manifestHash, ok := core.SubManifestHash(b.Header(), nodeCtx)
+	if !ok {
+		return nil, errors.New("no subordinate manifest to fill")
+	}
+	if manifestHash == types.EmptyRootHash {
 		return nil, errors.New("cannot fill empty subordinate manifest")
-	} else if subManifestHash := types.DeriveSha(b.SubManifest(), trie.NewStackTrie(nil)); subManifestHash == b.ManifestHash(nodeCtx+1) {
+	} else if subManifestHash := types.DeriveSha(b.SubManifest(), trie.NewStackTrie(nil)); subManifestHash == manifestHash {
 		// If the manifest hashes match, nothing to do
 		return b, nil
 	} else {
@@ -593,7 +597,7 @@ func (s *PublicBlockChainQuaiAPI) fillSubordinateManifest(b *types.Block) (*type
 		if len(subManifest) == 0 {
 			return nil, errors.New("reconstructed sub manifest is empty")
 		}
-		if subManifest == nil || b.ManifestHash(nodeCtx+1) != types.DeriveSha(subManifest, trie.NewStackTrie(nil)) {
+		if subManifest == nil || manifestHash != types.DeriveSha(subManifest, trie.NewStackTrie(nil)) {
 			return nil, errors.New("reconstructed sub manifest does not match manifest hash")
 		}
 		return types.NewBlockWithHeader(b.Header()).WithBody(b.Transactions(), b.Uncles(), b.ExtTransactions(), subManifest), nil
@@ -602,14 +606,14 @@ func (s *PublicBlockChainQuaiAPI) fillSubordinateManifest(b *types.Block) (*type
 
 // ReceiveMinedHeader will run checks on the block and add to canonical chain if valid.
 func (s *PublicBlockChainQuaiAPI) ReceiveMinedHeader(ctx context.Context, raw json.RawMessage) error {
-	nodeCtx := common.NodeLocation.Context()
+	nodeCtx := common.GetNodeLocation().Context()
 	// Decode header and transactions.
 	var header *types.Header
 	if err := json.Unmarshal(raw, &header); err != nil {
 		return err
 	}
 	block, err := s.b.ConstructLocalMinedBlock(header)
-	if err == core.ErrBadSubManifest && nodeCtx < common.ZONE_CTX {
+	if errors.Is(err, core.ErrBadSubManifest) && nodeCtx < common.ZONE_CTX {
 		log.Info("filling sub manifest")
 		// If we just mined this block, and we have a subordinate chain, its possible
 		// the subordinate manifest in our block body is incorrect. If so, ask our sub
@@ -62,5 +62,5 @@ func KnownDNSNetwork(genesis common.Hash, protocol string) string {
 	default:
 		return ""
 	}
-	return dnsPrefix + common.NodeLocation.Name() + "." + net + ".quainodes.io"
+	return dnsPrefix + common.GetNodeLocation().Name() + "." + net + ".quainodes.io"
 }
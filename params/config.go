@@ -121,9 +121,9 @@ var (
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllBlake3powProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), new(Blake3powConfig), common.Hash{}}
+	AllBlake3powProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), new(Blake3powConfig), common.Hash{}, nil}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), new(Blake3powConfig), common.Hash{}}
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), new(Blake3powConfig), common.Hash{}, nil}
 	TestRules       = TestChainConfig.Rules(new(big.Int))
 )
 
@@ -158,6 +158,12 @@ type ChainConfig struct {
 	// Various consensus engines
 	Blake3pow   *Blake3powConfig `json:"blake3pow,omitempty"`
 	GenesisHash common.Hash
+
+	// MinGasPrices maps a chain's location name (see common.Location.Name) to
+	// the minimum gas price of its transactions. Chains missing from the map
+	// use the global MinGasPrice. The transaction pool rejects transactions
+	// whose fee cap is below the floor of the node's chain.
+	MinGasPrices map[string]*big.Int `json:"minGasPrices,omitempty"`
 }
 
 // Blake3powConfig is the consensus engine configs for proof-of-work based sealing.
@@ -258,6 +264,16 @@ func (c *ChainConfig) IsLondon(num *big.Int) bool {
 	return isForked(c.LondonBlock, num)
 }
 
+// MinGasPrice returns the minimum gas price of transactions on the chain at
+// the given location, falling back to the global floor if the chain has none
+// configured. The returned value must not be modified.
+func (c *ChainConfig) MinGasPrice(loc common.Location) *big.Int {
+	if price, ok := c.MinGasPrices[loc.Name()]; ok && price != nil {
+		return price
+	}
+	return MinGasPrice
+}
+
 // CheckCompatible checks whether scheduled fork transitions have been imported
 // with a mismatching chain configuration.
 func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64) *ConfigCompatError {
@@ -20,6 +20,8 @@ import (
 	"math/big"
 	"reflect"
 	"testing"
+
+	"github.com/dominant-strategies/go-quai/common"
 )
 
 func TestCheckCompatible(t *testing.T) {
@@ -96,3 +98,24 @@ func TestCheckCompatible(t *testing.T) {
 		}
 	}
 }
+
+func TestMinGasPrice(t *testing.T) {
+	config := &ChainConfig{MinGasPrices: map[string]*big.Int{"cyprus2": big.NewInt(1000)}}
+	tests := []struct {
+		loc  common.Location
+		want *big.Int
+	}{
+		{common.Location{0, 1}, big.NewInt(1000)},
+		{common.Location{0, 0}, MinGasPrice},
+		{common.Location{0}, MinGasPrice},
+		{common.Location{}, MinGasPrice},
+	}
+	for _, tt := range tests {
+		if have := config.MinGasPrice(tt.loc); have.Cmp(tt.want) != 0 {
+			t.Errorf("%s: min gas price mismatch: have %v, want %v", tt.loc.Name(), have, tt.want)
+		}
+	}
+	if have := new(ChainConfig).MinGasPrice(common.Location{0, 1}); have.Cmp(MinGasPrice) != 0 {
+		t.Errorf("unconfigured chain: have %v, want %v", have, MinGasPrice)
+	}
+}
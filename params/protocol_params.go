@@ -19,10 +19,14 @@ package params
 import "math/big"
 
 const (
-	GasLimitBoundDivisor uint64 = 1024      // The bound divisor of the gas limit, used in update calculations.
-	MinGasLimit          uint64 = 100000    // Minimum the gas limit may ever be.
-	GenesisGasLimit      uint64 = 471238800 // Gas limit of the Genesis block.
-
+	GasLimitBoundDivisor uint64 = 1024               // The bound divisor of the gas limit, used in update calculations.
+	MinGasLimit          uint64 = 100000             // Minimum the gas limit may ever be.
+	MaxGasLimit          uint64 = 0x7fffffffffffffff // Maximum the gas limit may ever be.
+	GenesisGasLimit      uint64 = 471238800          // Gas limit of the Genesis block.
+	SubGasLimitDivisor   uint64 = 3                  // A subordinate chain's gas limit may be at most its dominant's gas limit divided by this.
+
+	MaxUnclesPerBlock     int    = 2     // Maximum number of uncles a block may include.
+	MaxUncleDepth         uint64 = 6     // Maximum number of generations an uncle may lag behind the block including it.
 	MaximumExtraDataSize  uint64 = 32    // Maximum size extra data may be after Genesis.
 	ExpByteGas            uint64 = 10    // Times ceil(log256(exponent)) for the EXP instruction.
 	SloadGas              uint64 = 50    // Multiplied by the number of 32-byte words that are copied (round up) for any *COPY operation and added.
@@ -162,4 +166,5 @@ var (
 	GardenDurationLimit    = []*big.Int{big.NewInt(150), big.NewInt(30), big.NewInt(3)}             // The decision boundary on the blocktime duration used to determine whether difficulty should go up or not.
 	OrchardDurationLimit   = []*big.Int{big.NewInt(150), big.NewInt(30), big.NewInt(3)}             // The decision boundary on the blocktime duration used to determine whether difficulty should go up or not.
 	LocalDurationLimit     = []*big.Int{big.NewInt(24), big.NewInt(7), big.NewInt(2)}               // The decision boundary on the blocktime duration used to determine whether difficulty should go up or not.
+	MinGasPrice            = big.NewInt(1)                                                          // The global minimum gas price of chains without a configured floor.
 )
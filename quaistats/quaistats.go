@@ -679,7 +679,7 @@ func (s *Service) assembleBlockStats(block *types.Block) *blockStats {
 		ManifestHash:  header.ManifestHash(),
 		Root:          header.Root(),
 		Uncles:        uncles,
-		Chain:         IntArrayLocation(common.NodeLocation),
+		Chain:         IntArrayLocation(common.GetNodeLocation()),
 	}
 }
 